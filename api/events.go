@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"perth/player"
+)
+
+// positionInterval is how often the event stream reports the position
+// while playing
+const positionInterval = time.Second
+
+// eventJSON is the data of a stream event named after the controller
+// event type. It carries the status after the change, so clients never
+// have to ask for it separately.
+type eventJSON struct {
+	Auto   bool       `json:"auto,omitempty"` // The controller acted on its own
+	Error  string     `json:"error,omitempty"`
+	Status statusJSON `json:"status"`
+}
+
+type positionJSON struct {
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+}
+
+// events streams changes as Server-Sent Events. The stream starts with a
+// "status" event, continues with one event per controller event, and
+// adds a "position" event every second while playing, as the player
+// reports it.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	events, cancel := s.ctl.Subscribe()
+	defer cancel()
+	ticks, stopTicks := s.ctl.SubscribePlayer(positionInterval)
+	defer stopTicks()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(name string, data any) error {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, b); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	if err := send("status", eventJSON{Status: newStatus(s.ctl.Status())}); err != nil {
+		return
+	}
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			data := eventJSON{Auto: e.Auto, Status: newStatus(s.ctl.Status())}
+			if e.Err != nil {
+				data.Error = e.Err.Error()
+			}
+			err = send(e.Type.String(), data)
+
+		case e, ok := <-ticks:
+			if !ok {
+				return
+			}
+			if e.Type == player.EventPosition {
+				err = send("position", positionJSON{Position: e.Position.Seconds(), Duration: e.Duration.Seconds()})
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"perth/internal"
+	"perth/playlist"
+)
+
+// statusJSON is the body of GET /status and of most command responses.
+// Times are in seconds.
+type statusJSON struct {
+	State        string     `json:"state"`
+	Path         string     `json:"path,omitempty"`
+	Track        *trackJSON `json:"track"`
+	Next         *trackJSON `json:"next"`
+	Position     float64    `json:"position"`
+	Duration     float64    `json:"duration"`
+	Volume       float64    `json:"volume"`
+	Repeat       string     `json:"repeat"`
+	Shuffle      bool       `json:"shuffle"`
+	Crossfade    float64    `json:"crossfade"`
+	QueueIndex   int        `json:"queue_index"`
+	QueueLength  int        `json:"queue_length"`
+	QueueVersion uint64     `json:"queue_version"`
+}
+
+type trackJSON struct {
+	ID       string  `json:"id"`
+	Path     string  `json:"path"`
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Genre    string  `json:"genre,omitempty"`
+	Year     int     `json:"year,omitempty"`
+	Duration float64 `json:"duration"`
+	Format   string  `json:"format"`
+}
+
+type queueJSON struct {
+	Version uint64           `json:"version"`
+	Index   int              `json:"index"` // Current entry, -1 if none
+	Entries []queueEntryJSON `json:"entries"`
+}
+
+type queueEntryJSON struct {
+	ID    string     `json:"id"`
+	Track *trackJSON `json:"track"` // null if the track left the library
+}
+
+func newStatus(st internal.Status) statusJSON {
+	return statusJSON{
+		State:        st.State.String(),
+		Path:         st.Path,
+		Track:        newTrack(st.Track),
+		Next:         newTrack(st.Next),
+		Position:     st.Position.Seconds(),
+		Duration:     st.Duration.Seconds(),
+		Volume:       st.Volume,
+		Repeat:       st.Repeat.String(),
+		Shuffle:      st.Shuffle,
+		Crossfade:    st.Crossfade.Seconds(),
+		QueueIndex:   st.QueueIndex,
+		QueueLength:  st.QueueLength,
+		QueueVersion: st.QueueVersion,
+	}
+}
+
+func newTrack(t *playlist.Track) *trackJSON {
+	if t == nil {
+		return nil
+	}
+	return &trackJSON{
+		ID:       t.ID,
+		Path:     filepath.ToSlash(t.Path),
+		Title:    t.DisplayName(),
+		Artist:   t.Artist(),
+		Album:    t.Album(),
+		Genre:    t.Genre(),
+		Year:     t.Year(),
+		Duration: t.Duration.Seconds(),
+		Format:   t.Format,
+	}
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, newStatus(s.ctl.Status()))
+}
+
+// command wraps a controller command that takes no arguments; the
+// response is the new status
+func (s *Server) command(run func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := run(); err != nil {
+			controllerError(w, err)
+			return
+		}
+		s.status(w, r)
+	}
+}
+
+// seek takes {"position": seconds} for an absolute position or
+// {"offset": seconds} to move relative to the current one
+func (s *Server) seek(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Position *float64 `json:"position"`
+		Offset   *float64 `json:"offset"`
+	}
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var err error
+	switch {
+	case req.Position != nil && req.Offset == nil:
+		err = s.ctl.Seek(seconds(*req.Position))
+	case req.Offset != nil && req.Position == nil:
+		err = s.ctl.SeekBy(seconds(*req.Offset))
+	default:
+		writeError(w, http.StatusBadRequest, errors.New("give either position or offset"))
+		return
+	}
+	if err != nil {
+		controllerError(w, err)
+		return
+	}
+	s.status(w, r)
+}
+
+// volume takes {"volume": 0.0-1.0}
+func (s *Server) volume(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Volume *float64 `json:"volume"`
+	}
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Volume == nil || *req.Volume < 0 || *req.Volume > 1 {
+		writeError(w, http.StatusBadRequest, errors.New("volume must be between 0 and 1"))
+		return
+	}
+	s.ctl.SetVolume(*req.Volume)
+	s.status(w, r)
+}
+
+func (s *Server) tracks(w http.ResponseWriter, r *http.Request) {
+	library := s.ctl.Tracks()
+	tracks := make([]*trackJSON, len(library))
+	for i, t := range library {
+		tracks[i] = newTrack(t)
+	}
+	writeJSON(w, http.StatusOK, tracks)
+}
+
+// playTrack plays a library track, jumping to it if it is queued
+func (s *Server) playTrack(w http.ResponseWriter, r *http.Request) {
+	if err := s.ctl.PlayTrack(r.PathValue("id")); err != nil {
+		controllerError(w, err)
+		return
+	}
+	s.status(w, r)
+}
+
+// cover serves the cover art embedded in a track
+func (s *Server) cover(w http.ResponseWriter, r *http.Request) {
+	track := s.ctl.Track(r.PathValue("id"))
+	if track == nil {
+		writeError(w, http.StatusNotFound, internal.ErrMissingTrack)
+		return
+	}
+	cover, err := track.Cover()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if cover == nil {
+		writeError(w, http.StatusNotFound, errors.New("track has no cover art"))
+		return
+	}
+
+	mimeType := cover.MIMEType
+	if mimeType == "" {
+		mimeType = http.DetectContentType(cover.Data)
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Cache-Control", "max-age=3600")
+	_, _ = w.Write(cover.Data)
+}
+
+func (s *Server) queue(w http.ResponseWriter, r *http.Request) {
+	st := s.ctl.Status()
+	entries := s.ctl.Queue()
+	q := queueJSON{
+		Version: st.QueueVersion,
+		Index:   st.QueueIndex,
+		Entries: make([]queueEntryJSON, len(entries)),
+	}
+	for i, e := range entries {
+		q.Entries[i] = queueEntryJSON{ID: e.ID, Track: newTrack(e.Track)}
+	}
+	writeJSON(w, http.StatusOK, q)
+}
+
+// playQueued plays the queue entry at the given index
+func (s *Server) playQueued(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid queue index"))
+		return
+	}
+	if i < 0 || i >= s.ctl.Status().QueueLength {
+		writeError(w, http.StatusNotFound, errors.New("no such queue entry"))
+		return
+	}
+	if err := s.ctl.PlayQueued(i); err != nil {
+		controllerError(w, err)
+		return
+	}
+	s.status(w, r)
+}
+
+// enqueue takes {"ids": [...]} to queue library tracks, or {"query": "..."}
+// to queue every track matching the query. The response is the new queue.
+func (s *Server) enqueue(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs   []string `json:"ids"`
+		Query string   `json:"query"`
+	}
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch {
+	case len(req.IDs) > 0 && req.Query == "":
+		for _, id := range req.IDs {
+			if s.ctl.Track(id) == nil {
+				writeError(w, http.StatusNotFound, errors.New("unknown track id: "+id))
+				return
+			}
+		}
+		s.ctl.Enqueue(req.IDs...)
+	case req.Query != "" && len(req.IDs) == 0:
+		if _, err := s.ctl.EnqueueMatching(req.Query); err != nil {
+			controllerError(w, err)
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, errors.New("give either ids or query"))
+		return
+	}
+	s.queue(w, r)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,127 @@
+// Package api exposes a Controller over HTTP with JSON endpoints and a
+// Server-Sent Events stream, for remote control and dashboards on the
+// same machine.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	"perth/internal"
+	"perth/player"
+)
+
+// maxBody limits the size of request bodies
+const maxBody = 64 * 1024
+
+// Options configures a Server
+type Options struct {
+	// AllowRemote accepts requests addressed to any host, for servers
+	// listening on the network. Changes from other origins are still
+	// rejected.
+	AllowRemote bool
+}
+
+// Server is an http.Handler serving the API
+type Server struct {
+	ctl  *internal.Controller
+	mux  *http.ServeMux
+	opts Options
+}
+
+// New creates a Server that drives ctl and only answers local requests
+func New(ctl *internal.Controller) *Server {
+	return NewWithOptions(ctl, Options{})
+}
+
+// NewWithOptions creates a Server that drives ctl
+func NewWithOptions(ctl *internal.Controller, opts Options) *Server {
+	s := &Server{ctl: ctl, mux: http.NewServeMux(), opts: opts}
+
+	s.mux.HandleFunc("GET /status", s.status)
+	s.mux.HandleFunc("POST /play", s.command(ctl.Play))
+	s.mux.HandleFunc("POST /pause", s.command(ctl.Pause))
+	s.mux.HandleFunc("POST /stop", s.command(ctl.Stop))
+	s.mux.HandleFunc("POST /next", s.command(ctl.Next))
+	s.mux.HandleFunc("POST /prev", s.command(ctl.Prev))
+	s.mux.HandleFunc("POST /seek", s.seek)
+	s.mux.HandleFunc("PUT /volume", s.volume)
+	s.mux.HandleFunc("GET /tracks", s.tracks)
+	s.mux.HandleFunc("POST /tracks/{id}/play", s.playTrack)
+	s.mux.HandleFunc("GET /tracks/{id}/cover", s.cover)
+	s.mux.HandleFunc("GET /queue", s.queue)
+	s.mux.HandleFunc("POST /queue", s.enqueue)
+	s.mux.HandleFunc("POST /queue/{index}/play", s.playQueued)
+	s.mux.HandleFunc("GET /events", s.events)
+	return s
+}
+
+// ServeHTTP only answers requests addressed to a loopback host, unless
+// remote requests are allowed, so web pages cannot reach the API through
+// DNS rebinding. It rejects changes requested by pages from other origins.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.opts.AllowRemote && !isLoopback(r.Host) {
+		writeError(w, http.StatusForbidden, errors.New("only local requests are allowed"))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				writeError(w, http.StatusForbidden, errors.New("cross-origin requests are not allowed"))
+				return
+			}
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// isLoopback reports whether host, with or without a port, names this machine
+func isLoopback(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// readJSON decodes the request body into v
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errors.New("invalid JSON body: " + err.Error())
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// controllerError writes a failed controller command with a fitting status code
+func controllerError(w http.ResponseWriter, err error) {
+	var transition *player.TransitionError
+	code := http.StatusInternalServerError
+	switch {
+	case errors.As(err, &transition),
+		errors.Is(err, internal.ErrEndOfQueue),
+		errors.Is(err, internal.ErrStartOfQueue),
+		errors.Is(err, internal.ErrEmptyQueue):
+		code = http.StatusConflict
+	case errors.Is(err, internal.ErrMissingTrack), errors.Is(err, internal.ErrNoMatch):
+		code = http.StatusNotFound
+	}
+	writeError(w, code, err)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"perth/internal"
+	"perth/player"
+	"perth/playlist"
+)
+
+// newTestServer returns a Server over a controller with an empty library
+// and a player without sound
+func newTestServer(t *testing.T, opts Options) (*Server, *internal.Controller) {
+	t.Helper()
+	library := playlist.NewScannerWithOptions([]string{t.TempDir()}, playlist.ScannerOptions{NoCache: true})
+	ctl := internal.New(player.NewWithOptions(player.Options{Output: player.NewNullOutput()}), library, playlist.NewQueue())
+	t.Cleanup(func() { ctl.Close() })
+	return NewWithOptions(ctl, opts), ctl
+}
+
+// serve sends a request to s with the given Host and Origin headers
+func serve(s *Server, method, host, origin, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/status", nil)
+	if method == http.MethodPut {
+		r = httptest.NewRequest(method, "/volume", strings.NewReader(body))
+	}
+	r.Host = host
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestServerOnlyAnswersLocalHosts(t *testing.T) {
+	local, _ := newTestServer(t, Options{})
+	remote, _ := newTestServer(t, Options{AllowRemote: true})
+
+	tests := []struct {
+		host          string
+		local, remote int
+	}{
+		{"127.0.0.1:8080", http.StatusOK, http.StatusOK},
+		{"localhost:8080", http.StatusOK, http.StatusOK},
+		{"localhost", http.StatusOK, http.StatusOK},
+		{"[::1]:8080", http.StatusOK, http.StatusOK},
+		// A rebound DNS name resolving to 127.0.0.1 still carries its own name
+		{"attacker.example:8080", http.StatusForbidden, http.StatusOK},
+		{"192.168.1.5:8080", http.StatusForbidden, http.StatusOK},
+		{"localhost.attacker.example", http.StatusForbidden, http.StatusOK},
+		{"", http.StatusForbidden, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := serve(local, http.MethodGet, tt.host, "", ""); w.Code != tt.local {
+			t.Errorf("GET with Host %q = %d, want %d", tt.host, w.Code, tt.local)
+		}
+		if w := serve(remote, http.MethodGet, tt.host, "", ""); w.Code != tt.remote {
+			t.Errorf("GET with Host %q allowing remote hosts = %d, want %d", tt.host, w.Code, tt.remote)
+		}
+	}
+}
+
+func TestServerRejectsChangesFromOtherOrigins(t *testing.T) {
+	const host = "127.0.0.1:8080"
+	for _, opts := range []Options{{}, {AllowRemote: true}} {
+		s, ctl := newTestServer(t, opts)
+		ctl.SetVolume(0.5)
+
+		tests := []struct {
+			method string
+			origin string
+			want   int
+		}{
+			{http.MethodPut, "http://attacker.example", http.StatusForbidden},
+			{http.MethodPut, "http://localhost:8080", http.StatusForbidden}, // Same machine, other host
+			{http.MethodPut, "null", http.StatusForbidden},
+			{http.MethodPut, "http://" + host, http.StatusOK},
+			{http.MethodPut, "", http.StatusOK}, // Not sent by a browser
+			// Reading is left to the browser's same-origin policy
+			{http.MethodGet, "http://attacker.example", http.StatusOK},
+		}
+		for _, tt := range tests {
+			before := ctl.Status().Volume
+			w := serve(s, tt.method, host, tt.origin, `{"volume": 0.25}`)
+			if w.Code != tt.want {
+				t.Errorf("%+v: %s with Origin %q = %d %s, want %d", opts, tt.method, tt.origin, w.Code, w.Body, tt.want)
+			}
+			if w.Code == http.StatusForbidden {
+				var body struct {
+					Error string `json:"error"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == "" {
+					t.Errorf("%+v: a rejected request answered %q, want a JSON error", opts, w.Body)
+				}
+				if after := ctl.Status().Volume; after != before {
+					t.Errorf("%+v: a rejected request changed the volume from %v to %v", opts, before, after)
+				}
+			}
+		}
+	}
+}
+
+func TestServerOverHTTP(t *testing.T) {
+	s, _ := newTestServer(t, Options{})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var status statusJSON
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || status.State == "" {
+		t.Errorf("GET /status = %d %+v", resp.StatusCode, status)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/template"
+
+	"perth/internal"
+	"perth/player"
+	"perth/playlist"
+	"perth/util"
+)
+
+// trackInfo is how ls and info show a track in JSON and to templates
+type trackInfo struct {
+	ID       string  `json:"id"`
+	Path     string  `json:"path"`
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Genre    string  `json:"genre,omitempty"`
+	Year     int     `json:"year,omitempty"`
+	Duration float64 `json:"duration"` // Seconds
+	Length   string  `json:"-"`        // Duration as MM:SS
+	Format   string  `json:"format"`
+	Size     int64   `json:"size"`
+}
+
+func newTrackInfo(t *playlist.Track) trackInfo {
+	return trackInfo{
+		ID:       t.ID,
+		Path:     t.Path,
+		Title:    t.DisplayName(),
+		Artist:   t.Artist(),
+		Album:    t.Album(),
+		Genre:    t.Genre(),
+		Year:     t.Year(),
+		Duration: t.Duration.Seconds(),
+		Length:   util.FormatDuration(t.Duration),
+		Format:   t.Format,
+		Size:     t.Size,
+	}
+}
+
+// newFlags creates the flag set of a command, printing synopsis as its usage
+func newFlags(name, synopsis string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: perth %s\n", synopsis)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// runPlay plays files and directories in the order given and returns once
+// the last track finishes. Unless --repeat says otherwise nothing repeats,
+// so it can be used in scripts.
+func runPlay(opts *options, args []string) int {
+	flags := newFlags("play", "play <file|dir>...")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	// The files are not the library, so they stay out of its cache
+	library := playlist.NewScannerWithOptions(flags.Args(), playlist.ScannerOptions{NoCache: true})
+	result, err := library.Scan()
+	if err != nil {
+		return fail(err)
+	}
+	code := exitOK
+	if warnScanErrors(result) {
+		code = exitPartial
+	}
+	if result.TotalFiles == 0 {
+		return fail(errors.New("nothing to play"))
+	}
+
+	cfg := *opts.cfg
+	if !opts.set["repeat"] {
+		cfg.Repeat = "off"
+	}
+	ctl := setUp(&cfg, internal.New(player.NewWithOptions(cfg.PlayerOptions()), library, playlist.NewQueue()))
+	defer ctl.Close()
+
+	events, cancel := ctl.Subscribe()
+	defer cancel()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	skipped, err := start(ctl)
+	if err != nil {
+		return fail(err)
+	}
+	if skipped {
+		code = exitPartial
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return exitInterrupted
+
+		case e, ok := <-events:
+			if !ok {
+				return code
+			}
+			switch e.Type {
+			case internal.EventTrackChanged:
+				if e.Track != nil {
+					fmt.Printf("▶️  %s\n", e.Track)
+				}
+			case internal.EventQueueEnded:
+				return code
+			case internal.EventError:
+				// A track that cannot be played is skipped
+				fmt.Fprintf(os.Stderr, "⚠️  %v\n", e.Err)
+				code = exitPartial
+				if !skip(ctl) {
+					return code
+				}
+			}
+		}
+	}
+}
+
+// start plays the first track that plays, reporting whether tracks that
+// could not be played were skipped. It fails only when none plays.
+func start(ctl *internal.Controller) (skipped bool, err error) {
+	err = ctl.Play()
+	if err == nil {
+		return false, nil
+	}
+	if errors.Is(err, internal.ErrEmptyQueue) {
+		return false, errors.New("nothing to play")
+	}
+	fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+	if !skip(ctl) {
+		return true, errors.New("none of the tracks can be played")
+	}
+	return true, nil
+}
+
+// skip moves on to the next track that plays. It reports false when the
+// queue ends first.
+func skip(ctl *internal.Controller) bool {
+	for range ctl.Status().QueueLength {
+		err := ctl.Next()
+		if err == nil {
+			return true
+		}
+		if errors.Is(err, internal.ErrEndOfQueue) {
+			return false
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+	}
+	return false
+}
+
+// scanJSON is the output of scan --json. Its tracks replace the cache
+// entries of the embedded result, which JSON allows since they are less
+// deeply nested.
+type scanJSON struct {
+	*playlist.ScanResult
+	Tracks []trackInfo `json:"tracks"`
+}
+
+// runScan scans the library into the cache, or the given directories on
+// their own
+func runScan(opts *options, args []string) int {
+	flags := newFlags("scan", "scan [--json] [--verify] [dir]...")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	verify := flags.Bool("verify", false, "hash every file in full to find changes that size and time do not show")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	scanOpts := opts.cfg.ScannerOptions()
+	if len(paths) == 0 {
+		paths = opts.cfg.Library
+	} else {
+		// Other directories are not the library: caching them would drop
+		// every library track outside them from the cache
+		scanOpts.NoCache = true
+	}
+	if *verify {
+		scanOpts.Hash = playlist.HashFull
+	}
+	// Interrupting keeps what was scanned so far in the cache
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	result, err := playlist.NewScannerWithOptions(paths, scanOpts).ScanContext(ctx, nil)
+	if errors.Is(err, context.Canceled) {
+		return exitInterrupted
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	if *asJSON {
+		out := scanJSON{ScanResult: result, Tracks: make([]trackInfo, len(result.Tracks))}
+		for i, t := range result.Tracks {
+			out.Tracks[i] = newTrackInfo(t)
+		}
+		if err := printJSON(out); err != nil {
+			return fail(err)
+		}
+	} else {
+		fmt.Printf("Total tracks: %d\n", result.TotalFiles)
+		fmt.Printf("New tracks: %d\n", result.NewTracks)
+		fmt.Printf("Updated tracks: %d\n", result.UpdatedTracks)
+		fmt.Printf("Removed tracks: %d\n", result.RemovedTracks)
+		warnScanErrors(result)
+	}
+
+	if len(result.Errors) > 0 {
+		return exitPartial
+	}
+	return exitOK
+}
+
+// runList prints the library tracks
+func runList(opts *options, args []string) int {
+	flags := newFlags("ls", "ls [--format text|json|<template>]")
+	format := flags.String("format", "text", "text, json, or a Go template such as '{{.Artist}}\\t{{.Title}}'")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	// Templates are checked before the library is scanned
+	var tmpl *template.Template
+	if *format != "text" && *format != "json" {
+		var err error
+		if tmpl, err = template.New("format").Parse(*format + "\n"); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Invalid format: %v\n", err)
+			return exitUsage
+		}
+	}
+
+	library := playlist.NewScannerWithOptions(opts.cfg.Library, opts.cfg.ScannerOptions())
+	result, err := library.Scan()
+	if err != nil {
+		return fail(err)
+	}
+	code := exitOK
+	if warnScanErrors(result) {
+		code = exitPartial
+	}
+
+	tracks := make([]trackInfo, len(result.Tracks))
+	for i, t := range result.Tracks {
+		tracks[i] = newTrackInfo(t)
+	}
+	switch {
+	case *format == "json":
+		err = printJSON(tracks)
+	case tmpl != nil:
+		for _, t := range tracks {
+			if err = tmpl.Execute(os.Stdout, t); err != nil {
+				break
+			}
+		}
+	default:
+		for _, t := range tracks {
+			name := t.Title
+			if t.Artist != "" {
+				name = t.Artist + " - " + t.Title
+			}
+			fmt.Printf("%s  %s\n", t.Length, name)
+		}
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return code
+}
+
+// runInfo prints the details of a single audio file
+func runInfo(args []string) int {
+	flags := newFlags("info", "info [--json] <file>")
+	asJSON := flags.Bool("json", false, "print the details as JSON")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	track, err := playlist.LoadTrack(flags.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	info := newTrackInfo(track)
+	if err := track.MetadataErr(); err != nil {
+		// The file still plays; only its tags are missing
+		fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+	}
+	if *asJSON {
+		if err := printJSON(info); err != nil {
+			return fail(err)
+		}
+		return exitOK
+	}
+
+	fmt.Printf("Path:     %s\n", filepath.Clean(info.Path))
+	fmt.Printf("Format:   %s\n", info.Format)
+	fmt.Printf("Duration: %s\n", info.Length)
+	fmt.Printf("Size:     %s\n", formatSize(info.Size))
+	fmt.Printf("Title:    %s\n", info.Title)
+	for _, field := range []struct{ name, value string }{
+		{"Artist", info.Artist},
+		{"Album", info.Album},
+		{"Genre", info.Genre},
+	} {
+		if field.value != "" {
+			fmt.Printf("%-9s %s\n", field.name+":", field.value)
+		}
+	}
+	if info.Year != 0 {
+		fmt.Printf("Year:     %d\n", info.Year)
+	}
+	return exitOK
+}
+
+// warnScanErrors prints the errors of a scan on stderr and reports
+// whether there were any
+func warnScanErrors(result *playlist.ScanResult) bool {
+	for _, err := range result.Errors {
+		fmt.Fprintf(os.Stderr, "⚠️  %s\n", err)
+	}
+	return len(result.Errors) > 0
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// formatSize formats a byte count for people
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"perth/config"
+	"perth/internal"
+	"perth/player"
+	"perth/playlist"
+)
+
+// writeSilence writes a short 16-bit stereo WAV file of silence
+func writeSilence(t *testing.T, path string) {
+	t.Helper()
+	const rate, frames = 8000, 800
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 36+frames*4)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1)
+	binary.LittleEndian.PutUint16(h[22:], 2)
+	binary.LittleEndian.PutUint32(h[24:], rate)
+	binary.LittleEndian.PutUint32(h[28:], rate*4)
+	binary.LittleEndian.PutUint16(h[32:], 4)
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], frames*4)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(h, make([]byte, frames*4)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cachedPaths returns the paths of the tracks in the cache file at path
+func cachedPaths(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cache struct {
+		Tracks []struct {
+			Path string `json:"path"`
+		} `json:"tracks"`
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, track := range cache.Tracks {
+		paths = append(paths, track.Path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func TestScanDirsLeavesTheLibraryCacheAlone(t *testing.T) {
+	dir := t.TempDir()
+	library := filepath.Join(dir, "music")
+	album := filepath.Join(library, "album")
+	single, track := filepath.Join(library, "single.wav"), filepath.Join(album, "track.wav")
+	writeSilence(t, single)
+	writeSilence(t, track)
+
+	cfg := config.Default()
+	cfg.Library = []string{library}
+	cfg.Cache = filepath.Join(dir, "cache.json")
+	opts := &options{set: make(map[string]bool), cfg: cfg}
+
+	if code := runScan(opts, nil); code != exitOK {
+		t.Fatalf("scanning the library exited with %d", code)
+	}
+	want := []string{track, single} // Sorted
+	if got := cachedPaths(t, cfg.Cache); !slices.Equal(got, want) {
+		t.Fatalf("library cache holds %v, want %v", got, want)
+	}
+
+	if code := runScan(opts, []string{album}); code != exitOK {
+		t.Fatalf("scanning %s exited with %d", album, code)
+	}
+	if got := cachedPaths(t, cfg.Cache); !slices.Equal(got, want) {
+		t.Errorf("after scanning a subdirectory the library cache holds %v, want %v", got, want)
+	}
+}
+
+func TestStartSkipsTracksThatCannotPlay(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "1.wav"), filepath.Join(dir, "2.wav")
+	writeSilence(t, first)
+	writeSilence(t, second)
+	newController := func() *internal.Controller {
+		t.Helper()
+		library := playlist.NewScannerWithOptions([]string{dir}, playlist.ScannerOptions{NoCache: true})
+		if _, err := library.Scan(); err != nil {
+			t.Fatal(err)
+		}
+		ctl := internal.New(player.NewWithOptions(player.Options{Output: player.NewNullOutput()}), library, playlist.NewQueue())
+		t.Cleanup(func() { ctl.Close() })
+		return ctl
+	}
+
+	// The first track goes away after the scan
+	ctl := newController()
+	if err := os.Remove(first); err != nil {
+		t.Fatal(err)
+	}
+	skipped, err := start(ctl)
+	if err != nil || !skipped {
+		t.Fatalf("start = %v, %v, want the first track skipped", skipped, err)
+	}
+	if path := ctl.Status().Path; path != second {
+		t.Errorf("playing %q, want %q", path, second)
+	}
+
+	// With none left to play starting fails
+	writeSilence(t, first)
+	ctl = newController()
+	for _, path := range []string{first, second} {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := start(ctl); err == nil {
+		t.Error("start succeeded with no track that plays")
+	}
+}
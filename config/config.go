@@ -0,0 +1,232 @@
+// Package config reads the user configuration: where the library lives,
+// which files belong to it, where the cache is kept, playback defaults and
+// TUI key bindings.
+//
+// The configuration is a JSON file, config.json in the perth directory
+// under $XDG_CONFIG_HOME (~/.config by default). Every field is optional:
+//
+//	{
+//	  "library": ["~/Music"],
+//	  "exclude": ["*.part", "Podcasts"],
+//	  "extensions": [".flac", ".mp3"],
+//	  "cache": "~/.cache/perth/cache.json",
+//	  "state": "~/.local/state/perth",
+//	  "hash": "off",
+//	  "watch": true,
+//	  "volume": 80,
+//	  "crossfade": "2s",
+//	  "gapless_albums": true,
+//	  "repeat": "all",
+//	  "shuffle": false,
+//	  "resume": true,
+//	  "output": "speaker",
+//	  "keys": {"next": ["n", "ctrl+n"]}
+//	}
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"perth/player"
+	"perth/playlist"
+)
+
+// Config is the user configuration. Fields missing from the file keep the
+// values of Default.
+type Config struct {
+	Library    []string `json:"library"`    // Directories scanned for audio files
+	Exclude    []string `json:"exclude"`    // Glob patterns of files and directories to skip
+	Extensions []string `json:"extensions"` // Extensions to scan, every playable one if empty
+	Cache      string   `json:"cache"`      // Library cache file
+	State      string   `json:"state"`      // Directory for what is kept between runs, like the last session
+	Hash       string   `json:"hash"`       // Content hashing to find changed files: off, partial or full
+	Watch      bool     `json:"watch"`      // Pick up changes to the library files as they happen
+
+	Volume        float64  `json:"volume"` // Starting volume, 0-100
+	Crossfade     Duration `json:"crossfade"`
+	GaplessAlbums bool     `json:"gapless_albums"`
+	Repeat        string   `json:"repeat"` // Starting repeat mode: off, one or all
+	Shuffle       bool     `json:"shuffle"`
+	Resume        bool     `json:"resume"` // Restore the last session at startup, paused
+	Output        string   `json:"output"` // Audio sink: speaker, null, or wav:<file> to record what plays
+
+	// Keys maps TUI actions, like "next" or "volume_up", to the keys that
+	// trigger them, replacing the default keys of those actions
+	Keys map[string][]string `json:"keys"`
+}
+
+// Default returns the configuration used when there is no config file
+func Default() *Config {
+	return &Config{
+		Library: []string{"assets"},
+		Cache:   playlist.DefaultCachePath(),
+		State:   StateDir(),
+		Hash:    "off",
+		Watch:   true,
+		Volume:  100,
+		Repeat:  "all",
+		Resume:  true,
+		Output:  "speaker",
+	}
+}
+
+// Path returns the default location of the config file
+func Path() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = ".config"
+	}
+	return filepath.Join(dir, "perth", "config.json")
+}
+
+// StateDir returns the default state directory, perth under
+// $XDG_STATE_HOME (~/.local/state by default)
+func StateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "perth")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".perth"
+	}
+	return filepath.Join(home, ".local", "state", "perth")
+}
+
+// Load reads the config file at path over the defaults. An empty path
+// means the default location, where a missing file is not an error.
+func Load(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = Path()
+	}
+
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	// Unknown fields are rejected so misspelled settings do not go unnoticed
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	if err := cfg.check(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	for i, dir := range cfg.Library {
+		cfg.Library[i] = expandHome(dir)
+	}
+	cfg.Cache = expandHome(cfg.Cache)
+	if cfg.State == "" {
+		cfg.State = StateDir()
+	}
+	cfg.State = expandHome(cfg.State)
+	return cfg, nil
+}
+
+// check reports the first setting that cannot be used
+func (c *Config) check() error {
+	if len(c.Library) == 0 {
+		return errors.New("library must name at least one directory")
+	}
+	for _, pattern := range c.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("exclude pattern %q: %w", pattern, err)
+		}
+	}
+	for _, ext := range c.Extensions {
+		if !strings.HasPrefix(ext, ".") || !player.Supported(ext) {
+			return fmt.Errorf("extension %q is not a playable format (want one of %s)",
+				ext, strings.Join(player.Extensions(), ", "))
+		}
+	}
+	if _, err := playlist.ParseHashMode(c.Hash); err != nil {
+		return err
+	}
+	if c.Volume < 0 || c.Volume > 100 {
+		return errors.New("volume must be between 0 and 100")
+	}
+	if c.Crossfade < 0 {
+		return errors.New("crossfade must not be negative")
+	}
+	if _, err := playlist.ParseRepeatMode(c.Repeat); err != nil {
+		return err
+	}
+	if _, err := player.ParseOutput(c.Output); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ScannerOptions returns the options for scanning the library
+func (c *Config) ScannerOptions() playlist.ScannerOptions {
+	hash, _ := playlist.ParseHashMode(c.Hash) // Checked when loading
+	return playlist.ScannerOptions{
+		CachePath:  c.Cache,
+		Exclude:    c.Exclude,
+		Extensions: c.Extensions,
+		Hash:       hash,
+	}
+}
+
+// PlayerOptions returns the options for creating the player
+func (c *Config) PlayerOptions() player.Options {
+	out, _ := player.ParseOutput(c.Output) // Checked when loading
+	return player.Options{Output: out}
+}
+
+// RepeatMode returns the starting repeat mode
+func (c *Config) RepeatMode() playlist.RepeatMode {
+	mode, _ := playlist.ParseRepeatMode(c.Repeat) // Checked when loading
+	return mode
+}
+
+// Duration is a time.Duration written as a string like "1.5s", or as a
+// number of seconds
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New(`duration must be a string like "2s" or a number of seconds`)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
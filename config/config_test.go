@@ -0,0 +1,235 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file into a temporary directory
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// isolate points the home and XDG directories at temporary ones
+func isolate(t *testing.T) (home string) {
+	t.Helper()
+	home = t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, "cache"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(home, "state"))
+	return home
+}
+
+func TestLoadDefaults(t *testing.T) {
+	home := isolate(t)
+
+	// Without a file at the default location the defaults are used
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Load without a file = %+v, want the defaults %+v", cfg, Default())
+	}
+	if want := filepath.Join(home, "state", "perth"); cfg.State != want {
+		t.Errorf("State = %q, want %q", cfg.State, want)
+	}
+	if want := filepath.Join(home, "cache", "perth", "cache.json"); cfg.Cache != want {
+		t.Errorf("Cache = %q, want %q", cfg.Cache, want)
+	}
+
+	// A file that was asked for has to be there
+	if _, err := Load(filepath.Join(home, "missing.json")); err == nil {
+		t.Error("loading a missing config file that was named succeeded")
+	}
+
+	// An empty file object keeps every default
+	cfg, err = Load(writeConfig(t, "{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Load({}) = %+v, want the defaults", cfg)
+	}
+}
+
+func TestLoadDefaultLocation(t *testing.T) {
+	home := isolate(t)
+	path := filepath.Join(home, "config", "perth", "config.json")
+	if Path() != path {
+		t.Fatalf("Path = %q, want %q", Path(), path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"volume": 30}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Volume != 30 {
+		t.Errorf("Volume = %v, want 30 from the default location", cfg.Volume)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	home := isolate(t)
+	cfg, err := Load(writeConfig(t, `{
+		"library": ["~/Music", "/srv/music"],
+		"exclude": ["*.part"],
+		"extensions": [".flac", ".mp3"],
+		"cache": "~/perth/cache.json",
+		"state": "~/perth/state",
+		"hash": "partial",
+		"watch": false,
+		"volume": 55.5,
+		"crossfade": "1.5s",
+		"gapless_albums": true,
+		"repeat": "one",
+		"shuffle": true,
+		"resume": false,
+		"output": "null",
+		"keys": {"next": ["n", "ctrl+n"]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Library:       []string{filepath.Join(home, "Music"), "/srv/music"},
+		Exclude:       []string{"*.part"},
+		Extensions:    []string{".flac", ".mp3"},
+		Cache:         filepath.Join(home, "perth", "cache.json"),
+		State:         filepath.Join(home, "perth", "state"),
+		Hash:          "partial",
+		Watch:         false,
+		Volume:        55.5,
+		Crossfade:     Duration(1500 * time.Millisecond),
+		GaplessAlbums: true,
+		Repeat:        "one",
+		Shuffle:       true,
+		Resume:        false,
+		Output:        "null",
+		Keys:          map[string][]string{"next": {"n", "ctrl+n"}},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Load = %+v\nwant %+v", cfg, want)
+	}
+}
+
+func TestLoadEmptyStateUsesTheDefault(t *testing.T) {
+	home := isolate(t)
+	// An empty cache is resolved by the scanner, an empty state here, so
+	// neither ends up in the working directory
+	cfg, err := Load(writeConfig(t, `{"cache": "", "state": ""}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(home, "state", "perth"); cfg.State != want {
+		t.Errorf("State = %q, want %q", cfg.State, want)
+	}
+
+	// A relative XDG_STATE_HOME is ignored, as the spec asks
+	t.Setenv("XDG_STATE_HOME", "state")
+	if want := filepath.Join(home, ".local", "state", "perth"); StateDir() != want {
+		t.Errorf("StateDir with a relative XDG_STATE_HOME = %q, want %q", StateDir(), want)
+	}
+}
+
+func TestDurationUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want time.Duration
+	}{
+		{`"2s"`, 2 * time.Second},
+		{`"1m30s"`, 90 * time.Second},
+		{`"0"`, 0},
+		{`3`, 3 * time.Second},
+		{`0.25`, 250 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		var d Duration
+		if err := d.UnmarshalJSON([]byte(tt.json)); err != nil {
+			t.Errorf("%s: %v", tt.json, err)
+			continue
+		}
+		if time.Duration(d) != tt.want {
+			t.Errorf("%s = %v, want %v", tt.json, time.Duration(d), tt.want)
+		}
+	}
+	for _, bad := range []string{`"fast"`, `"2"`, `true`, `[1]`} {
+		var d Duration
+		if err := d.UnmarshalJSON([]byte(bad)); err == nil {
+			t.Errorf("%s parsed as %v, want an error", bad, time.Duration(d))
+		}
+	}
+
+	b, err := Duration(1500 * time.Millisecond).MarshalJSON()
+	if err != nil || string(b) != `"1.5s"` {
+		t.Errorf("MarshalJSON = %s, %v, want \"1.5s\"", b, err)
+	}
+}
+
+func TestLoadRejectsBadConfigs(t *testing.T) {
+	isolate(t)
+	tests := []struct {
+		name    string
+		content string
+		err     string // Part of the expected message
+	}{
+		{"not json", `{"volume": }`, "failed to parse"},
+		{"unknown field", `{"volme": 50}`, `unknown field "volme"`},
+		{"wrong type", `{"library": "~/Music"}`, "failed to parse"},
+		{"bad duration", `{"crossfade": "soon"}`, "failed to parse"},
+		{"empty library", `{"library": []}`, "library"},
+		{"bad exclude pattern", `{"exclude": ["[a-"]}`, "exclude pattern"},
+		{"extension without a dot", `{"extensions": ["mp3"]}`, "not a playable format"},
+		{"unplayable extension", `{"extensions": [".txt"]}`, "not a playable format"},
+		{"bad hash mode", `{"hash": "sometimes"}`, "sometimes"},
+		{"volume too high", `{"volume": 101}`, "volume"},
+		{"negative volume", `{"volume": -1}`, "volume"},
+		{"negative crossfade", `{"crossfade": "-1s"}`, "crossfade"},
+		{"bad repeat mode", `{"repeat": "twice"}`, "twice"},
+		{"bad output", `{"output": "tape"}`, "tape"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.content)
+			cfg, err := Load(path)
+			if err == nil {
+				t.Fatalf("loaded %+v, want an error", cfg)
+			}
+			if !strings.Contains(err.Error(), tt.err) || !strings.Contains(err.Error(), path) {
+				t.Errorf("error %q does not name %q and the file", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home := isolate(t)
+	tests := []struct{ path, want string }{
+		{"~", home},
+		{"~/Music", filepath.Join(home, "Music")},
+		{"~user/Music", "~user/Music"},
+		{"/srv/~/Music", "/srv/~/Music"},
+		{"Music", "Music"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := expandHome(tt.path); got != tt.want {
+			t.Errorf("expandHome(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
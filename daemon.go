@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"perth/api"
+	"perth/mpd"
+	"perth/web"
+)
+
+// runDaemon plays in the background and serves MPD clients, and
+// optionally the HTTP API and web UI, until it is interrupted. SIGHUP
+// reloads the configuration.
+func runDaemon(opts *options, args []string) int {
+	flags := newFlags("daemon", "daemon [flags]")
+	socket := flags.String("socket", defaultSocketPath(), "Unix socket to listen on")
+	tcp := flags.String("tcp", "", "also listen on this loopback address, e.g. localhost:6600")
+	httpAddr := flags.String("http", "", "serve the HTTP API and web UI on this loopback address, e.g. localhost:8080")
+	remote := flags.Bool("remote", false, "allow -http on addresses other machines can reach")
+	flags.Parse(args)
+
+	// Until the servers own them the listeners are closed here when
+	// starting fails, which also removes the socket file
+	var listeners []net.Listener
+	var httpListener net.Listener
+	started := false
+	defer func() {
+		if started {
+			return
+		}
+		for _, l := range listeners {
+			l.Close()
+		}
+		if httpListener != nil {
+			httpListener.Close()
+		}
+	}()
+
+	l, err := listenUnix(*socket)
+	if err != nil {
+		return fail(err)
+	}
+	listeners = append(listeners, l)
+	if *tcp != "" {
+		l, err := listenLoopback(*tcp)
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, l)
+	}
+	if *httpAddr != "" {
+		if *remote {
+			httpListener, err = net.Listen("tcp", *httpAddr)
+		} else {
+			httpListener, err = listenLoopback(*httpAddr)
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	// Nobody may be there to answer, so the session is resumed without asking
+	ctl, shutdown := newController(opts, false)
+	server := mpd.New(ctl)
+	handler := web.New(api.NewWithOptions(ctl, api.Options{AllowRemote: *remote}))
+	httpServer := &http.Server{Handler: handler}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	failed := make(chan error, len(listeners)+1)
+	for _, l := range listeners {
+		fmt.Printf("🎧 Listening on %s\n", l.Addr())
+		go func() {
+			if err := server.Serve(l); !errors.Is(err, mpd.ErrServerClosed) {
+				failed <- err
+			}
+		}()
+	}
+	if httpListener != nil {
+		fmt.Printf("🌐 Web UI on http://%s/ui/\n", httpListener.Addr())
+		if *remote {
+			fmt.Println("⚠️  Anyone on the network can control playback")
+		}
+		go func() {
+			if err := httpServer.Serve(httpListener); !errors.Is(err, http.ErrServerClosed) {
+				failed <- err
+			}
+		}()
+	}
+	started = true
+
+	// SIGHUP reloads the configuration, as daemons usually do
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("👋 Shutting down")
+			break wait
+		case err = <-failed:
+			fmt.Printf("❌ %v\n", err)
+			break wait
+		case <-hangup:
+			if result, err := reload(context.Background(), opts, ctl, nil); err != nil {
+				fmt.Printf("❌ Reload failed: %v\n", err)
+			} else {
+				fmt.Printf("🔄 Reloaded configuration, %d tracks\n", result.TotalFiles)
+			}
+		}
+	}
+	// Close rather than Shutdown: event streams never finish on their own
+	httpServer.Close()
+	server.Close()
+	shutdown()
+	if err != nil {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// defaultSocketPath returns perth.sock in the user's runtime directory,
+// or a per-user socket in the temporary directory
+func defaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "perth.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("perth-%d.sock", os.Getuid()))
+}
+
+// listenUnix listens on a Unix socket that only the current user can
+// connect to. A socket left behind by a daemon that is no longer running
+// is replaced.
+func listenUnix(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another daemon is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// listenLoopback listens on a TCP address, refusing anything that other
+// machines could connect to
+func listenLoopback(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("refusing to listen on %s: only loopback addresses are allowed", addr)
+		}
+	}
+	return net.Listen("tcp", addr)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDaemonCleansUpWhenListeningFails(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "perth.sock")
+	opts := &options{set: make(map[string]bool)}
+
+	for _, args := range [][]string{
+		{"--socket", socket, "--tcp", "192.0.2.1:6600"},
+		{"--socket", socket, "--tcp", "127.0.0.1:0", "--http", "192.0.2.1:8080"},
+	} {
+		if code := runDaemon(opts, args); code != exitFailure {
+			t.Fatalf("runDaemon %q exited with %d, want %d", args, code, exitFailure)
+		}
+		if _, err := os.Stat(socket); !os.IsNotExist(err) {
+			t.Errorf("runDaemon %q left the socket file behind: %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,715 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"perth/player"
+	"perth/playlist"
+)
+
+// Errors returned by Controller commands
+var (
+	ErrEmptyQueue   = errors.New("no tracks in the queue")
+	ErrEndOfQueue   = errors.New("end of queue")
+	ErrStartOfQueue = errors.New("already at the start of the queue")
+	ErrMissingTrack = errors.New("track is no longer in the library")
+	ErrNoMatch      = errors.New("no tracks match")
+)
+
+// Controller owns the player, the library and the play queue, and is the
+// one place front ends drive playback through. Commands are serialized, and
+// a background goroutine advances through the queue when a track ends, so
+// the REPL, the TUI and remote front ends only send commands and react to
+// events.
+type Controller struct {
+	mu      sync.Mutex // Serializes commands and automatic advances
+	player  *player.Player
+	library *playlist.Scanner
+	queue   *playlist.Queue
+	files   *playlist.Watcher // Watches the library files, nil if they are not watched
+
+	gaplessAlbums bool // Join consecutive tracks of one album without crossfade
+
+	subMu sync.Mutex
+	subs  map[chan Event]struct{}
+
+	wake      chan struct{} // Tells the watcher the current track may have changed
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Status is a snapshot of the playback state
+type Status struct {
+	State         player.State
+	Path          string          // File being played, "" if nothing is loaded
+	Track         *playlist.Track // Library track of Path, nil for files outside the library
+	Next          *playlist.Track // Track that plays when the current one finishes
+	Playing       bool
+	Position      time.Duration
+	Duration      time.Duration
+	Volume        float64 // Linear, 0.0-1.0
+	Repeat        playlist.RepeatMode
+	Shuffle       bool
+	Seed          int64 // Shuffle seed
+	Crossfade     time.Duration
+	GaplessAlbums bool
+	QueueIndex    int // Index of the current queue entry, -1 if none
+	QueueLength   int
+	QueueVersion  uint64 // Changes whenever queue entries change
+}
+
+// QueueEntry is one entry of the play queue
+type QueueEntry struct {
+	ID    string
+	Track *playlist.Track // nil if the track left the library
+}
+
+// New creates a Controller and starts watching for tracks that end
+func New(p *player.Player, library *playlist.Scanner, queue *playlist.Queue) *Controller {
+	c := &Controller{
+		player:  p,
+		library: library,
+		queue:   queue,
+		subs:    make(map[chan Event]struct{}),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	go c.watch()
+	return c
+}
+
+// Close stops the controller and closes the player
+func (c *Controller) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	c.lock()
+	defer c.unlock()
+	if c.files != nil {
+		c.files.Close()
+		c.files = nil
+	}
+	return c.player.Close()
+}
+
+// lock acquires the controller for a command
+func (c *Controller) lock() {
+	c.mu.Lock()
+}
+
+// unlock releases the controller and wakes the watcher, since the command
+// may have changed the track whose end it waits for
+func (c *Controller) unlock() {
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// watch advances through the queue whenever the current track ends
+func (c *Controller) watch() {
+	var handled <-chan struct{}
+	for {
+		ended := c.player.OnEnded()
+		if ended == handled {
+			// Already dealt with; wait for a command to change the track
+			ended = nil
+		}
+
+		select {
+		case <-ended:
+			handled = ended
+			c.mu.Lock()
+			c.advance()
+			c.mu.Unlock()
+		case <-c.wake:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// advance moves on after a track finished. If the next track was preloaded
+// the player has already switched to it and the queue only needs to catch
+// up; otherwise the next queued track is loaded and played. Callers must
+// hold c.mu.
+func (c *Controller) advance() {
+	c.syncCurrentTrack()
+	if !closed(c.player.OnEnded()) {
+		c.emit(Event{Type: EventTrackChanged, Path: c.player.Current(), Track: c.findTrack(c.player.Current()), Auto: true})
+		c.queueUpNext()
+		return
+	}
+
+	c.fillQueue()
+	id, ok := c.queue.Advance()
+	if !ok {
+		c.emit(Event{Type: EventQueueEnded, Auto: true})
+		c.emit(Event{Type: EventPlaybackChanged, Auto: true})
+		return
+	}
+	if err := c.playID(id, true); err != nil {
+		c.emit(Event{Type: EventError, Err: err, Auto: true})
+	}
+}
+
+// Status returns a snapshot of the playback state
+func (c *Controller) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.player.Current()
+	on, seed := c.queue.Shuffle()
+	_, index := c.queue.Current()
+	return Status{
+		State:         c.player.State(),
+		Path:          path,
+		Track:         c.findTrack(path),
+		Next:          c.nextQueued(),
+		Playing:       c.player.Playing(),
+		Position:      c.player.Position(),
+		Duration:      c.player.Duration(),
+		Volume:        c.player.Volume(),
+		Repeat:        c.queue.Repeat(),
+		Shuffle:       on,
+		Seed:          seed,
+		Crossfade:     c.player.Crossfade(),
+		GaplessAlbums: c.gaplessAlbums,
+		QueueIndex:    index,
+		QueueLength:   c.queue.Len(),
+		QueueVersion:  c.queue.Version(),
+	}
+}
+
+// LibraryPaths returns the directories and files that make up the library
+func (c *Controller) LibraryPaths() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.library.ScanPaths()
+}
+
+// Tracks returns the library in scan order
+func (c *Controller) Tracks() []*playlist.Track {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.library.GetTracks()
+}
+
+// Track returns the library track with the given ID, or nil
+func (c *Controller) Track(id string) *playlist.Track {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.library.GetTrackByID(id)
+}
+
+// Queue returns the queue entries in play order
+func (c *Controller) Queue() []QueueEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.syncCurrentTrack()
+	ids := c.queue.IDs()
+	entries := make([]QueueEntry, len(ids))
+	for i, id := range ids {
+		entries[i] = QueueEntry{ID: id, Track: c.library.GetTrackByID(id)}
+	}
+	return entries
+}
+
+// Playback commands
+
+// Load loads a file without starting playback. The file does not have to
+// be part of the library.
+func (c *Controller) Load(path string) error {
+	c.lock()
+	defer c.unlock()
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	if err := c.player.Load(path); err != nil {
+		return err
+	}
+	c.emit(Event{Type: EventTrackChanged, Path: path, Track: c.findTrack(path)})
+	return nil
+}
+
+// Play starts or resumes playback. With nothing loaded it starts the
+// current queue entry, or the first one.
+func (c *Controller) Play() error {
+	c.lock()
+	defer c.unlock()
+	return c.play()
+}
+
+func (c *Controller) play() error {
+	if c.player.Current() == "" {
+		c.fillQueue()
+		id, i := c.queue.Current()
+		if i < 0 {
+			var ok bool
+			if id, ok = c.queue.Next(); !ok {
+				return ErrEmptyQueue
+			}
+		}
+		return c.playID(id, false)
+	}
+
+	if err := c.player.Play(); err != nil {
+		return err
+	}
+	c.emit(Event{Type: EventPlaybackChanged})
+	return nil
+}
+
+// Pause pauses playback
+func (c *Controller) Pause() error {
+	c.lock()
+	defer c.unlock()
+	return c.pause()
+}
+
+func (c *Controller) pause() error {
+	if err := c.player.Pause(); err != nil {
+		return err
+	}
+	c.emit(Event{Type: EventPlaybackChanged})
+	return nil
+}
+
+// Toggle pauses when playing and plays otherwise
+func (c *Controller) Toggle() error {
+	c.lock()
+	defer c.unlock()
+	if c.player.Playing() {
+		return c.pause()
+	}
+	return c.play()
+}
+
+// Stop stops playback and rewinds the current track
+func (c *Controller) Stop() error {
+	c.lock()
+	defer c.unlock()
+	if err := c.player.Stop(); err != nil {
+		return err
+	}
+	c.emit(Event{Type: EventPlaybackChanged})
+	return nil
+}
+
+// Seek jumps to a position in the current track
+func (c *Controller) Seek(pos time.Duration) error {
+	c.lock()
+	defer c.unlock()
+	return c.seek(pos)
+}
+
+// SeekBy moves the position by d, which may be negative
+func (c *Controller) SeekBy(d time.Duration) error {
+	c.lock()
+	defer c.unlock()
+	return c.seek(c.player.Position() + d)
+}
+
+func (c *Controller) seek(pos time.Duration) error {
+	if err := c.player.Seek(max(0, pos)); err != nil {
+		return err
+	}
+	c.emit(Event{Type: EventPlaybackChanged})
+	return nil
+}
+
+// SetVolume sets the linear volume, clamped to 0.0-1.0
+func (c *Controller) SetVolume(linear float64) {
+	c.lock()
+	defer c.unlock()
+	c.player.SetVolume(max(0, min(1, linear)))
+	c.emit(Event{Type: EventVolumeChanged})
+}
+
+// Next plays the following queue entry
+func (c *Controller) Next() error {
+	c.lock()
+	defer c.unlock()
+
+	c.syncCurrentTrack()
+	c.fillQueue()
+	if c.queue.Len() == 0 {
+		return ErrEmptyQueue
+	}
+	id, ok := c.queue.Next()
+	if !ok {
+		return ErrEndOfQueue
+	}
+	return c.playID(id, false)
+}
+
+// Prev plays the previous queue entry
+func (c *Controller) Prev() error {
+	c.lock()
+	defer c.unlock()
+
+	c.syncCurrentTrack()
+	c.fillQueue()
+	if c.queue.Len() == 0 {
+		return ErrEmptyQueue
+	}
+	id, ok := c.queue.Prev()
+	if !ok {
+		return ErrStartOfQueue
+	}
+	return c.playID(id, false)
+}
+
+// PlayTrack plays a library track. It jumps to the track if it is already
+// queued and plays it next otherwise.
+func (c *Controller) PlayTrack(id string) error {
+	c.lock()
+	defer c.unlock()
+
+	if c.library.GetTrackByID(id) == nil {
+		return ErrMissingTrack
+	}
+	c.fillQueue()
+	if pos := c.queuePosition(id); pos >= 0 {
+		_ = c.queue.SetCurrent(pos)
+	} else {
+		c.queue.InsertNext(id)
+		c.queue.Next()
+	}
+	c.emit(Event{Type: EventQueueChanged})
+	return c.playID(id, false)
+}
+
+// PlayQueued plays the queue entry at index i
+func (c *Controller) PlayQueued(i int) error {
+	c.lock()
+	defer c.unlock()
+
+	if err := c.queue.SetCurrent(i); err != nil {
+		return err
+	}
+	id, _ := c.queue.Current()
+	return c.playID(id, false)
+}
+
+// Mode commands
+
+// SetRepeat sets the repeat mode
+func (c *Controller) SetRepeat(mode playlist.RepeatMode) {
+	c.lock()
+	defer c.unlock()
+	c.queue.SetRepeat(mode)
+	c.queueUpNext()
+	c.emit(Event{Type: EventModeChanged})
+}
+
+// SetShuffle turns shuffle on with the given seed, or off
+func (c *Controller) SetShuffle(on bool, seed int64) {
+	c.lock()
+	defer c.unlock()
+	if on {
+		c.syncCurrentTrack()
+		c.fillQueue()
+	}
+	c.queue.SetShuffle(on, seed)
+	c.queueUpNext()
+	c.emit(Event{Type: EventModeChanged})
+}
+
+// SetCrossfade sets the crossfade length; 0 joins tracks gaplessly
+func (c *Controller) SetCrossfade(d time.Duration) {
+	c.lock()
+	defer c.unlock()
+	c.player.SetCrossfade(max(0, d))
+	c.emit(Event{Type: EventModeChanged})
+}
+
+// SetGaplessAlbums controls whether consecutive tracks of the same album
+// skip the crossfade
+func (c *Controller) SetGaplessAlbums(on bool) {
+	c.lock()
+	defer c.unlock()
+	c.gaplessAlbums = on
+	c.queueUpNext()
+	c.emit(Event{Type: EventModeChanged})
+}
+
+// Queue commands
+
+// Enqueue appends library tracks to the queue
+func (c *Controller) Enqueue(ids ...string) {
+	c.lock()
+	defer c.unlock()
+	c.queue.Enqueue(ids...)
+	c.queueChanged()
+}
+
+// EnqueueMatching queues every track whose title, filename, artist or
+// album contains query, and returns them
+func (c *Controller) EnqueueMatching(query string) ([]*playlist.Track, error) {
+	c.lock()
+	defer c.unlock()
+
+	query = strings.ToLower(query)
+	var added []*playlist.Track
+	for _, track := range c.library.GetTracks() {
+		fields := []string{track.DisplayName(), track.Filename, track.Artist(), track.Album()}
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), query) {
+				c.queue.Enqueue(track.ID)
+				added = append(added, track)
+				break
+			}
+		}
+	}
+	if len(added) == 0 {
+		return nil, fmt.Errorf("%w %q", ErrNoMatch, query)
+	}
+	c.queueChanged()
+	return added, nil
+}
+
+// RemoveQueued removes the queue entry at index i
+func (c *Controller) RemoveQueued(i int) error {
+	c.lock()
+	defer c.unlock()
+	if err := c.queue.Remove(i); err != nil {
+		return err
+	}
+	c.queueChanged()
+	return nil
+}
+
+// MoveQueued moves the queue entry at index from to index to
+func (c *Controller) MoveQueued(from, to int) error {
+	c.lock()
+	defer c.unlock()
+	if err := c.queue.Move(from, to); err != nil {
+		return err
+	}
+	c.queueChanged()
+	return nil
+}
+
+// ClearQueue empties the queue
+func (c *Controller) ClearQueue() {
+	c.lock()
+	defer c.unlock()
+	c.queue.Clear()
+	c.queueChanged()
+}
+
+// Rescan rescans the library and drops queue entries whose tracks are
+// gone. It returns the scan result and the number of dropped entries.
+// progress, if not nil, is called as files are scanned. Cancelling ctx
+// stops the scan with ctx.Err().
+//
+// The controller is not locked while files are read, so playback and
+// other commands carry on; the library keeps its own lock.
+func (c *Controller) Rescan(ctx context.Context, progress func(playlist.ScanProgress)) (*playlist.ScanResult, int, error) {
+	result, err := c.library.ScanContext(ctx, progress)
+	c.lock()
+	defer c.unlock()
+	return c.scanned(result, err)
+}
+
+// SetLibrary changes which directories and files make up the library and
+// rescans it, like Rescan
+func (c *Controller) SetLibrary(ctx context.Context, paths []string, opts playlist.ScannerOptions, progress func(playlist.ScanProgress)) (*playlist.ScanResult, int, error) {
+	c.library.Configure(paths, opts)
+	result, err := c.library.ScanContext(ctx, progress)
+	c.lock()
+	defer c.unlock()
+	return c.scanned(result, err)
+}
+
+// WatchLibrary starts or stops keeping the library up to date as files
+// under it are added, changed, renamed or removed. Starting it again after
+// SetLibrary watches the new library.
+func (c *Controller) WatchLibrary(on bool) error {
+	c.lock()
+	defer c.unlock()
+
+	if c.files != nil {
+		c.files.Close()
+		c.files = nil
+	}
+	if !on {
+		return nil
+	}
+	files, err := c.library.Watch()
+	if err != nil {
+		return err
+	}
+	c.files = files
+	go c.followLibrary(files)
+	return nil
+}
+
+// followLibrary applies the changes files reports until it is closed.
+// Like Rescan it reads the files without locking the controller.
+func (c *Controller) followLibrary(files *playlist.Watcher) {
+	for paths := range files.Changes {
+		c.mu.Lock()
+		current := c.files == files
+		c.mu.Unlock()
+		if !current {
+			continue // Replaced while the batch was on its way
+		}
+		c.library.Update(paths)
+		c.lock()
+		c.libraryChanged()
+		c.unlock()
+	}
+}
+
+// Helpers; callers must hold c.mu
+
+// scanned prunes the queue after a scan that returned result and err
+func (c *Controller) scanned(result *playlist.ScanResult, err error) (*playlist.ScanResult, int, error) {
+	if err != nil {
+		// A cancelled scan keeps the tracks it found so far
+		c.emit(Event{Type: EventLibraryChanged})
+		return nil, 0, err
+	}
+	return result, c.libraryChanged(), nil
+}
+
+// libraryChanged prunes the queue after the library changed and announces
+// the change. It returns the number of dropped queue entries.
+func (c *Controller) libraryChanged() int {
+	// Queue entries are track IDs, so only tracks that disappeared are dropped
+	removed := c.queue.Prune(func(id string) bool {
+		return c.library.GetTrackByID(id) != nil
+	})
+	c.emit(Event{Type: EventLibraryChanged})
+	if removed > 0 {
+		c.queueChanged()
+	}
+	return removed
+}
+
+// playID loads and plays the library track with the given ID
+func (c *Controller) playID(id string, auto bool) error {
+	track := c.library.GetTrackByID(id)
+	if track == nil {
+		return ErrMissingTrack
+	}
+	if err := c.player.Load(track.Path); err != nil {
+		return fmt.Errorf("loading %s: %w", track.Filename, err)
+	}
+	if err := c.player.Play(); err != nil {
+		return err
+	}
+	c.emit(Event{Type: EventTrackChanged, Path: track.Path, Track: track, Auto: auto})
+	c.emit(Event{Type: EventPlaybackChanged, Auto: auto})
+	c.queueUpNext()
+	return nil
+}
+
+// queueChanged preloads the possibly different next track and announces the change
+func (c *Controller) queueChanged() {
+	c.queueUpNext()
+	c.emit(Event{Type: EventQueueChanged})
+}
+
+// fillQueue puts the whole library into an empty queue, so next/prev walk
+// the library in order until the user builds a queue of their own
+func (c *Controller) fillQueue() {
+	if c.queue.Len() > 0 {
+		return
+	}
+	for _, track := range c.library.GetTracks() {
+		c.queue.Enqueue(track.ID)
+	}
+}
+
+// queuePosition returns the position of a track in the queue, or -1
+func (c *Controller) queuePosition(id string) int {
+	for i, queued := range c.queue.IDs() {
+		if queued == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextQueued returns the track the queue will play when the current one
+// finishes, or nil if playback stops there
+func (c *Controller) nextQueued() *playlist.Track {
+	id, ok := c.queue.PeekNext()
+	if !ok {
+		return nil
+	}
+	return c.library.GetTrackByID(id)
+}
+
+// queueUpNext preloads the track after the current one so playback
+// continues without a gap when the current track finishes
+func (c *Controller) queueUpNext() {
+	if c.player.Current() == "" {
+		return
+	}
+	next := c.nextQueued()
+	switch {
+	case next == nil:
+		c.player.ClearNext()
+	case c.gaplessAlbums && c.sameAlbum(c.player.Current(), next.Path):
+		// Consecutive tracks of one album are joined without crossfade
+		c.player.SetNextJoined(next.Path)
+	default:
+		c.player.SetNext(next.Path)
+	}
+}
+
+// syncCurrentTrack follows the player across gapless transitions so that
+// the queue points at the track that is actually playing
+func (c *Controller) syncCurrentTrack() {
+	playing := c.findTrack(c.player.Current())
+	if playing == nil {
+		return
+	}
+	if id, _ := c.queue.Current(); id == playing.ID {
+		return
+	}
+	if next := c.nextQueued(); next != nil && next.ID == playing.ID {
+		c.queue.Advance()
+	}
+}
+
+// findTrack returns the library track for the file at path, or nil
+func (c *Controller) findTrack(path string) *playlist.Track {
+	return c.library.GetTrackByPath(path)
+}
+
+// sameAlbum reports whether both files are known tracks tagged with the same album
+func (c *Controller) sameAlbum(a, b string) bool {
+	ta, tb := c.findTrack(a), c.findTrack(b)
+	if ta == nil || tb == nil {
+		return false
+	}
+	album := ta.Album()
+	return album != "" && album == tb.Album()
+}
+
+// closed reports whether ch has been closed
+func closed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
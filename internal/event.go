@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"time"
+
+	"perth/player"
+	"perth/playlist"
+)
+
+// EventType identifies what changed
+type EventType int
+
+const (
+	EventTrackChanged    EventType = iota // A different track was loaded or became current
+	EventPlaybackChanged                  // Playback started, paused, stopped or seeked
+	EventVolumeChanged
+	EventModeChanged    // Repeat, shuffle or crossfade settings changed
+	EventQueueChanged   // Queue entries were added, removed or reordered
+	EventLibraryChanged // The library was rescanned, or its files changed
+	EventQueueEnded     // The last queue entry finished and nothing follows
+	EventError          // A failure outside of any command, e.g. while advancing
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventTrackChanged:
+		return "track"
+	case EventPlaybackChanged:
+		return "playback"
+	case EventVolumeChanged:
+		return "volume"
+	case EventModeChanged:
+		return "mode"
+	case EventQueueChanged:
+		return "queue"
+	case EventLibraryChanged:
+		return "library"
+	case EventQueueEnded:
+		return "queue-ended"
+	case EventError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Event describes a state change. Front ends read the new state through
+// Controller.Status rather than from the event itself.
+type Event struct {
+	Type  EventType
+	Path  string          // EventTrackChanged: the file now current
+	Track *playlist.Track // EventTrackChanged: its library track, nil for other files
+	Auto  bool            // The controller acted on its own, e.g. advanced after a track ended
+	Err   error           // EventError
+}
+
+// eventBuffer is the number of events a subscriber may fall behind by
+// before further events are dropped for it
+const eventBuffer = 64
+
+// Subscribe returns a channel of events and a function that ends the
+// subscription and closes the channel. Events are never waited on: a
+// subscriber that falls behind misses events rather than stalling playback.
+func (c *Controller) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	c.subMu.Lock()
+	c.subs[ch] = struct{}{}
+	c.subMu.Unlock()
+
+	cancel := func() {
+		c.subMu.Lock()
+		defer c.subMu.Unlock()
+		if _, ok := c.subs[ch]; ok {
+			delete(c.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// SubscribePlayer subscribes to the events of the player itself. With
+// interval > 0 they include an EventPosition every interval while playing.
+// They come straight from the player, so a busy controller does not hold
+// them up, and reading them takes no controller lock.
+func (c *Controller) SubscribePlayer(interval time.Duration) (<-chan player.Event, func()) {
+	return c.player.Subscribe(interval)
+}
+
+// emit delivers an event to every subscriber without blocking
+func (c *Controller) emit(e Event) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	for ch := range c.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"perth/playlist"
+)
+
+// Session is the playback state kept between runs: what was loaded and
+// where, the volume, the queue and its modes
+type Session struct {
+	Path       string        `json:"path,omitempty"` // File that was loaded, "" if none
+	Position   time.Duration `json:"position"`
+	Volume     float64       `json:"volume"`
+	Queue      []string      `json:"queue"`       // Track IDs in play order
+	QueueIndex int           `json:"queue_index"` // Current entry, -1 if none
+	Repeat     string        `json:"repeat"`
+	Shuffle    bool          `json:"shuffle"`
+	Seed       int64         `json:"seed,omitempty"`
+	Order      []int         `json:"order,omitempty"` // Shuffled play order as Queue indexes, history first
+	OrderPos   int           `json:"order_pos"`       // Current entry's position in Order, -1 if none
+}
+
+// Session returns the current session
+func (c *Controller) Session() Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.syncCurrentTrack()
+	path := c.player.Current()
+	if abs, err := filepath.Abs(path); err == nil && path != "" {
+		// The next run may start in another directory
+		path = abs
+	}
+	on, seed := c.queue.Shuffle()
+	order, pos := c.queue.ShuffleOrder()
+	_, index := c.queue.Current()
+	return Session{
+		Path:       path,
+		Position:   c.player.Position(),
+		Volume:     c.player.Volume(),
+		Queue:      c.queue.IDs(),
+		QueueIndex: index,
+		Repeat:     c.queue.Repeat().String(),
+		Shuffle:    on,
+		Seed:       seed,
+		Order:      order,
+		OrderPos:   pos,
+	}
+}
+
+// Restore brings back a saved session. The track that was playing is
+// loaded at the saved position and paused, so the user decides when to
+// carry on. Queue entries whose tracks left the library are dropped. A
+// saved shuffle order resumes with its history; without one shuffle starts
+// a new pass from the current entry.
+func (c *Controller) Restore(s Session) error {
+	c.lock()
+	defer c.unlock()
+
+	c.player.SetVolume(max(0, min(1, s.Volume)))
+	if mode, err := playlist.ParseRepeatMode(s.Repeat); err == nil {
+		c.queue.SetRepeat(mode)
+	}
+
+	c.queue.Clear()
+	current := -1
+	index := make([]int, len(s.Queue)) // Saved entry to restored entry, -1 if dropped
+	for i, id := range s.Queue {
+		index[i] = -1
+		if c.library.GetTrackByID(id) == nil {
+			continue
+		}
+		if i == s.QueueIndex {
+			current = c.queue.Len()
+		}
+		index[i] = c.queue.Len()
+		c.queue.Enqueue(id)
+	}
+	if current >= 0 {
+		_ = c.queue.SetCurrent(current) // In range, it was just added
+	}
+	if !s.Shuffle || s.Order == nil || c.resumeShuffle(s, index) != nil {
+		c.queue.SetShuffle(s.Shuffle, s.Seed)
+	}
+	c.emit(Event{Type: EventVolumeChanged})
+	c.emit(Event{Type: EventModeChanged})
+	c.queueChanged()
+
+	if s.Path == "" {
+		return nil
+	}
+	if err := c.player.Load(s.Path); err != nil {
+		return fmt.Errorf("loading %s: %w", filepath.Base(s.Path), err)
+	}
+	if err := c.player.Seek(s.Position); err != nil {
+		return err
+	}
+	if err := c.player.Pause(); err != nil {
+		return err
+	}
+	c.emit(Event{Type: EventTrackChanged, Path: s.Path, Track: c.findTrack(s.Path)})
+	c.queueUpNext()
+	return nil
+}
+
+// resumeShuffle restores the saved shuffle order onto the rebuilt queue,
+// leaving out dropped entries. index maps each saved entry to its restored
+// one. Callers must hold c.mu.
+func (c *Controller) resumeShuffle(s Session, index []int) error {
+	order := make([]int, 0, len(s.Order))
+	pos := s.OrderPos
+	for i, saved := range s.Order {
+		if saved >= 0 && saved < len(index) && index[saved] >= 0 {
+			order = append(order, index[saved])
+			continue
+		}
+		if i <= s.OrderPos {
+			pos--
+		}
+	}
+	return c.queue.ResumeShuffle(s.Seed, order, pos)
+}
+
+// LoadSession reads a session saved by SaveSession. It returns nil without
+// an error when there is none.
+func LoadSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse session: %w", err)
+	}
+	return &s, nil
+}
+
+// SaveSession writes a session to path. The file is replaced in one step,
+// so a crash while saving leaves the previous session intact.
+func SaveSession(path string, s Session) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".session-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	return nil
+}
@@ -1,444 +1,278 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
-	"unicode/utf8"
 
+	"perth/config"
+	"perth/internal"
 	"perth/player"
 	"perth/playlist"
+	"perth/ui"
 )
 
-func main() {
-	p := player.New()
-	defer p.Close()
-
-	// Initialize playlist scanner
-	playlistScanner := playlist.NewScanner([]string{"assets"})
-
-	// Perform initial scan
-	fmt.Println("🎵 Perth Music Player")
-	fmt.Println("📁 Scanning for audio files...")
-	result, err := playlistScanner.Scan()
-	if err != nil {
-		fmt.Printf("⚠️  Warning: Failed to scan audio files: %v\n", err)
-	} else {
-		fmt.Printf("✅ Found %d audio tracks\n", result.TotalFiles)
-	}
-	fmt.Println()
-
-	fmt.Println("Commands:")
-	fmt.Println("  load <file>     - Load an audio file")
-	fmt.Println("  play            - Start/Resume playback")
-	fmt.Println("  pause           - Pause playback")
-	fmt.Println("  stop            - Stop and reset to beginning")
-	fmt.Println("  seek <seconds>  - Seek to position (in seconds)")
-	fmt.Println("  volume <0-100>  - Set volume (0-100)")
-	fmt.Println("  status          - Show current status")
-	fmt.Println("  ls              - List available audio files")
-	fmt.Println("  list            - Show playlist tracks")
-	fmt.Println("  next            - Play next track in playlist")
-	fmt.Println("  prev            - Play previous track in playlist")
-	fmt.Println("  goto <index>    - Jump to track by index")
-	fmt.Println("  rescan          - Rescan audio files")
-	fmt.Println("  quit            - Exit the player")
-	fmt.Println()
-
-	scanner := bufio.NewScanner(os.Stdin)
-	for {
-		fmt.Print("> ")
-		if !scanner.Scan() {
-			break
-		}
-
-		input := strings.TrimSpace(scanner.Text())
-		if input == "" {
-			continue
-		}
-
-		// Validate UTF-8 encoding
-		if !utf8.ValidString(input) {
-			fmt.Println("❌ Invalid character encoding. Please ensure your terminal supports UTF-8.")
-			continue
-		}
-
-		command, args := parseCommand(input)
-
-		switch command {
-		case "load":
-			if len(args) < 1 {
-				fmt.Println("Usage: load <file>")
-				continue
-			}
-			loadFile(p, args[0])
-
-		case "play":
-			if err := p.Play(); err != nil {
-				fmt.Printf("Error playing: %v\n", err)
-			} else {
-				fmt.Println("▶️  Playing")
-			}
-
-		case "pause":
-			p.Pause()
-			fmt.Println("⏸️  Paused")
-
-		case "stop":
-			p.Stop()
-			fmt.Println("⏹️  Stopped")
-
-		case "seek":
-			if len(args) < 1 {
-				fmt.Println("Usage: seek <seconds>")
-				continue
-			}
-			seekTo(p, args[0])
-
-		case "volume":
-			if len(args) < 1 {
-				fmt.Println("Usage: volume <0-100>")
-				continue
-			}
-			setVolume(p, args[0])
-
-		case "status":
-			showStatus(p)
-
-		case "ls":
-			listAudioFiles()
-
-		case "list":
-			listPlaylistTracks(playlistScanner)
-
-		case "next":
-			playNextTrack(p, playlistScanner)
-
-		case "prev":
-			playPreviousTrack(p, playlistScanner)
-
-		case "goto":
-			if len(args) < 1 {
-				fmt.Println("Usage: goto <index>")
-				continue
-			}
-			gotoTrack(p, playlistScanner, args[0])
-
-		case "rescan":
-			rescanAudioFiles(playlistScanner)
-
-		case "quit", "exit":
-			fmt.Println("👋 Goodbye!")
-			return
+// Exit codes, so scripts can tell what went wrong
+const (
+	exitOK          = 0
+	exitFailure     = 1   // The command failed
+	exitUsage       = 2   // Bad flags or arguments, as the flag package exits with
+	exitPartial     = 3   // The command finished but some files could not be read
+	exitInterrupted = 130 // Stopped by a signal, as shells report it
+)
 
-		default:
-			fmt.Printf("Unknown command: %s\n", command)
-		}
-	}
+// options holds the global flags shared by every command and the
+// configuration they override
+type options struct {
+	configPath string   // Config file, "" for the default location
+	library    pathList // Directories, and files, that make up the library
+	cache      string
+	volume     float64 // 0-100
+	shuffle    bool
+	repeat     string
+	resume     bool
+	watch      bool
+	output     string
+	set        map[string]bool // Flags given on the command line
+
+	cfg *config.Config // Configuration with the flags applied
 }
 
-// parseCommand parses user input with proper Unicode support
-func parseCommand(input string) (string, []string) {
-	// Split by whitespace while preserving Unicode characters
-	parts := strings.Fields(input)
-	if len(parts) == 0 {
-		return "", nil
-	}
+// pathList is a flag that can be given several times
+type pathList []string
 
-	command := strings.ToLower(parts[0])
-	var args []string
+func (l *pathList) String() string { return strings.Join(*l, ",") }
 
-	if len(parts) > 1 {
-		switch command {
-		case "load":
-			// For load command, join all remaining parts to handle filenames with spaces
-			// This preserves Unicode characters in filenames
-			filename := strings.Join(parts[1:], " ")
-			// Clean the filename and validate it exists
-			filename = strings.TrimSpace(filename)
-			args = []string{filename}
-		case "seek", "volume":
-			// These commands expect a single numeric argument
-			args = []string{parts[1]}
-		default:
-			args = parts[1:]
-		}
-	}
-
-	return command, args
+func (l *pathList) Set(path string) error {
+	*l = append(*l, path)
+	return nil
 }
 
-func loadFile(p *player.Player, filePath string) {
-	// Validate UTF-8 in filename
-	if !utf8.ValidString(filePath) {
-		fmt.Println("❌ Invalid character encoding in filename. Please ensure your terminal supports UTF-8.")
-		return
+// load reads the config file and applies the flags given on the command
+// line over it. It is called again when the configuration is reloaded.
+func (o *options) load() (*config.Config, error) {
+	cfg, err := config.Load(o.configPath)
+	if err != nil {
+		return nil, err
 	}
-
-	// Clean and normalize the file path
-	filePath = strings.TrimSpace(filePath)
-
-	// Expand relative paths
-	if !filepath.IsAbs(filePath) {
-		abs, err := filepath.Abs(filePath)
-		if err == nil {
-			filePath = abs
-		}
+	if o.set["library"] {
+		cfg.Library = o.library
 	}
-
-	// Check if file exists before attempting to load
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		fmt.Printf("❌ File not found: %s\n", filePath)
-		fmt.Println("💡 Tip: Use 'ls assets/' to see available files")
-		return
+	if o.set["cache"] {
+		cfg.Cache = o.cache
 	}
-
-	fmt.Printf("🎵 Loading: %s\n", filepath.Base(filePath))
-	if err := p.Load(filePath); err != nil {
-		fmt.Printf("❌ Error loading file: %v\n", err)
-		return
+	if o.set["volume"] {
+		cfg.Volume = o.volume
 	}
-
-	duration := p.Duration()
-	if duration > 0 {
-		fmt.Printf("✅ Loaded successfully (Duration: %s)\n", formatDuration(duration))
-	} else {
-		fmt.Println("✅ Loaded successfully")
+	if o.set["shuffle"] {
+		cfg.Shuffle = o.shuffle
 	}
-}
-
-func seekTo(p *player.Player, secondsStr string) {
-	seconds, err := strconv.ParseFloat(secondsStr, 64)
-	if err != nil {
-		fmt.Printf("Invalid time: %s\n", secondsStr)
-		return
+	if o.set["repeat"] {
+		cfg.Repeat = o.repeat
 	}
-
-	pos := time.Duration(seconds * float64(time.Second))
-	if err := p.Seek(pos); err != nil {
-		fmt.Printf("Error seeking: %v\n", err)
-		return
+	if o.set["resume"] {
+		cfg.Resume = o.resume
 	}
-
-	fmt.Printf("⏩ Seeked to %s\n", formatDuration(pos))
-}
-
-func setVolume(p *player.Player, volumeStr string) {
-	volume, err := strconv.ParseFloat(volumeStr, 64)
-	if err != nil {
-		fmt.Printf("Invalid volume: %s\n", volumeStr)
-		return
+	if o.set["watch"] {
+		cfg.Watch = o.watch
 	}
-
-	if volume < 0 || volume > 100 {
-		fmt.Println("Volume must be between 0 and 100")
-		return
+	if o.set["output"] {
+		cfg.Output = o.output
 	}
-
-	// Convert percentage to linear volume (0.0 to 1.0)
-	linearVolume := volume / 100.0
-	p.SetVolume(linearVolume)
-	fmt.Printf("🔊 Volume set to %.0f%%\n", volume)
-}
-
-func showStatus(p *player.Player) {
-	position := p.Position()
-	duration := p.Duration()
-
-	fmt.Printf("📊 Status:\n")
-	fmt.Printf("  Position: %s\n", formatDuration(position))
-	if duration > 0 {
-		fmt.Printf("  Duration: %s\n", formatDuration(duration))
-		progress := float64(position) / float64(duration) * 100
-		fmt.Printf("  Progress: %.1f%%\n", progress)
+	// Keys only matter to the TUI, but a config with bad keys is bad for everyone
+	if err := ui.CheckKeys(cfg.Keys); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
 	}
+	return cfg, nil
 }
 
-func listAudioFiles() {
-	fmt.Println("🎵 Available audio files:")
+const usage = `Usage: perth [flags] [command] [args]
 
-	// List files in assets directory
-	entries, err := os.ReadDir("assets")
-	if err != nil {
-		fmt.Printf("❌ Cannot read assets directory: %v\n", err)
-		return
-	}
+Commands:
+  (none)                 Start the full-screen interface
+  play <file|dir>...     Play files and directories in order, then exit
+  scan [flags] [dir]...  Scan the library, or the given directories
+  ls [--format f]        List library tracks; f is text, json or a Go template
+  info [--json] <file>   Show the duration and tags of an audio file
+  repl                   Start the line-based interface
+  daemon [flags]         Serve MPD clients and the HTTP API; see perth daemon -h
 
-	audioExtensions := map[string]bool{
-		".mp3":  true,
-		".wav":  true,
-		".flac": true,
-	}
+Settings are read from the config file; flags override them.
 
-	found := false
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
+Exit status is 0 on success, 1 on failure, 2 on bad usage, 3 when some
+files could not be read and 130 when interrupted.
 
-		ext := strings.ToLower(filepath.Ext(entry.Name()))
-		if audioExtensions[ext] {
-			// Display the full relative path that users should type
-			fmt.Printf("  assets/%s\n", entry.Name())
-			found = true
-		}
-	}
+Flags:
+`
 
-	if !found {
-		fmt.Println("  No audio files found in assets/ directory")
-	} else {
-		fmt.Println("\n💡 Copy and paste the full path (including 'assets/') to load a file")
-	}
-}
-
-func formatDuration(d time.Duration) string {
-	minutes := int(d.Minutes())
-	seconds := int(d.Seconds()) % 60
-	return fmt.Sprintf("%02d:%02d", minutes, seconds)
+func main() {
+	opts, args := parseOptions(os.Args[1:])
+	cfg, err := opts.load()
+	if err != nil {
+		os.Exit(fail(err))
+	}
+	opts.cfg = cfg
+
+	command := ""
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	code := exitOK
+	switch command {
+	case "":
+		code = runTUI(opts)
+	case "play":
+		code = runPlay(opts, args)
+	case "scan":
+		code = runScan(opts, args)
+	case "ls":
+		code = runList(opts, args)
+	case "info":
+		code = runInfo(args)
+	case "repl":
+		// The line-based interface, e.g. for terminals without
+		// full-screen support
+		code = runREPL(opts)
+	case "daemon":
+		code = runDaemon(opts, args)
+	default:
+		fmt.Fprintf(os.Stderr, "❌ Unknown command: %s\n\n%s", command, usage)
+		code = exitUsage
+	}
+	os.Exit(code)
 }
 
-// Playlist management functions
-
-var currentTrackIndex = -1
-
-func listPlaylistTracks(scanner *playlist.Scanner) {
-	tracks := scanner.GetTracks()
-	if len(tracks) == 0 {
-		fmt.Println("📭 No tracks found in playlist")
-		return
-	}
-
-	fmt.Printf("🎵 Playlist (%d tracks):\n", len(tracks))
-	for i, track := range tracks {
-		marker := "  "
-		if i == currentTrackIndex {
-			marker = "▶️ "
-		}
-		fmt.Printf("%s%d. %s\n", marker, i+1, track.String())
-
-		// Show metadata for current track
-		if i == currentTrackIndex && track.HasMetadata() {
-			artist := track.Artist()
-			album := track.Album()
-			if artist != "" {
-				fmt.Printf("     Artist: %s\n", artist)
-			}
-			if album != "" {
-				fmt.Printf("     Album: %s\n", album)
-			}
+// parseOptions parses the global flags that come before the command
+func parseOptions(args []string) (*options, []string) {
+	opts := &options{set: make(map[string]bool)}
+	flags := flag.NewFlagSet("perth", flag.ExitOnError)
+	flags.StringVar(&opts.configPath, "config", "", "config file (default "+config.Path()+")")
+	flags.Var(&opts.library, "library", "library directory; repeat for several (default assets)")
+	flags.StringVar(&opts.cache, "cache", "", "library cache file (default "+playlist.DefaultCachePath()+")")
+	flags.Float64Var(&opts.volume, "volume", 0, "start at this volume, 0-100 (default 100)")
+	flags.BoolVar(&opts.shuffle, "shuffle", false, "start with shuffle on")
+	flags.StringVar(&opts.repeat, "repeat", "", "repeat mode: off, one or all (default all, off for play)")
+	flags.BoolVar(&opts.resume, "resume", true, "resume the last session, paused")
+	flags.BoolVar(&opts.watch, "watch", true, "keep the library up to date as its files change")
+	flags.StringVar(&opts.output, "output", "", "play to speaker, null, or wav:<file> to record what plays (default speaker)")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	flags.Visit(func(f *flag.Flag) { opts.set[f.Name] = true })
+
+	if opts.volume < 0 || opts.volume > 100 {
+		usageError(flags, "volume must be between 0 and 100")
+	}
+	if opts.set["repeat"] {
+		if _, err := playlist.ParseRepeatMode(opts.repeat); err != nil {
+			usageError(flags, err.Error())
 		}
 	}
-
-	if currentTrackIndex >= 0 {
-		fmt.Printf("\n💡 Current track: %d\n", currentTrackIndex+1)
+	if opts.set["output"] {
+		if _, err := player.ParseOutput(opts.output); err != nil {
+			usageError(flags, err.Error())
+		}
 	}
+	return opts, flags.Args()
 }
 
-func playNextTrack(p *player.Player, scanner *playlist.Scanner) {
-	tracks := scanner.GetTracks()
-	if len(tracks) == 0 {
-		fmt.Println("📭 No tracks in playlist")
-		return
-	}
-
-	if currentTrackIndex < 0 {
-		currentTrackIndex = 0
-	} else {
-		currentTrackIndex = (currentTrackIndex + 1) % len(tracks)
-	}
-
-	track := tracks[currentTrackIndex]
-	fmt.Printf("⏭️  Next track: %s\n", track.String())
-
-	loadFile(p, track.Path)
+// usageError reports bad arguments and exits like the flag package does
+func usageError(flags *flag.FlagSet, message string) {
+	fmt.Fprintf(flags.Output(), "❌ %s\n", message)
+	flags.Usage()
+	os.Exit(exitUsage)
+}
 
-	if err := p.Play(); err != nil {
-		fmt.Printf("❌ Failed to play track: %v\n", err)
+// newController scans the library and wires it to a new player and an
+// empty queue set up as configured, watches the library files and resumes
+// the last session, after asking if ask is set. The returned function
+// saves the session and closes the controller.
+func newController(opts *options, ask bool) (*internal.Controller, func()) {
+	cfg := opts.cfg
+	library := playlist.NewScannerWithOptions(cfg.Library, cfg.ScannerOptions())
+	fmt.Println("📁 Scanning for audio files...")
+	if result, err := library.Scan(); err != nil {
+		fmt.Printf("⚠️  Warning: Failed to scan audio files: %v\n", err)
 	} else {
-		fmt.Println("▶️  Playing next track")
+		fmt.Printf("✅ Found %d audio tracks\n", result.TotalFiles)
 	}
-}
-
-func playPreviousTrack(p *player.Player, scanner *playlist.Scanner) {
-	tracks := scanner.GetTracks()
-	if len(tracks) == 0 {
-		fmt.Println("📭 No tracks in playlist")
-		return
+	ctl := setUp(cfg, internal.New(player.NewWithOptions(cfg.PlayerOptions()), library, playlist.NewQueue()))
+	if err := ctl.WatchLibrary(cfg.Watch); err != nil {
+		fmt.Printf("⚠️  Warning: %v; use rescan to pick up changes\n", err)
 	}
-
-	if currentTrackIndex < 0 {
-		currentTrackIndex = 0
-	} else {
-		currentTrackIndex = (currentTrackIndex - 1 + len(tracks)) % len(tracks)
+	session := keepSession(opts, ctl, ask)
+	return ctl, func() {
+		session.stop()
+		ctl.Close()
 	}
+}
 
-	track := tracks[currentTrackIndex]
-	fmt.Printf("⏮️  Previous track: %s\n", track.String())
-
-	loadFile(p, track.Path)
-
-	if err := p.Play(); err != nil {
-		fmt.Printf("❌ Failed to play track: %v\n", err)
-	} else {
-		fmt.Println("▶️  Playing previous track")
+// setUp applies the playback settings of cfg to ctl
+func setUp(cfg *config.Config, ctl *internal.Controller) *internal.Controller {
+	ctl.SetVolume(cfg.Volume / 100)
+	ctl.SetRepeat(cfg.RepeatMode())
+	if cfg.Shuffle {
+		ctl.SetShuffle(true, time.Now().UnixNano())
 	}
+	ctl.SetCrossfade(time.Duration(cfg.Crossfade))
+	ctl.SetGaplessAlbums(cfg.GaplessAlbums)
+	return ctl
 }
 
-func gotoTrack(p *player.Player, scanner *playlist.Scanner, indexStr string) {
-	index, err := strconv.Atoi(indexStr)
+// reload reads the configuration again and applies what can change while
+// running: the library, which is rescanned and watched anew, and the
+// crossfade settings. progress and ctx are passed on to the rescan; a
+// stopped rescan still leaves the new configuration in place.
+// Volume, repeat and shuffle are only starting values and stay as they are,
+// and the output stays open.
+func reload(ctx context.Context, opts *options, ctl *internal.Controller, progress func(playlist.ScanProgress)) (*playlist.ScanResult, error) {
+	cfg, err := opts.load()
 	if err != nil {
-		fmt.Printf("❌ Invalid index: %s\n", indexStr)
-		return
+		return nil, err
 	}
-
-	tracks := scanner.GetTracks()
-	if index < 1 || index > len(tracks) {
-		fmt.Printf("❌ Index out of range (1-%d)\n", len(tracks))
-		return
+	opts.cfg = cfg
+	ctl.SetCrossfade(time.Duration(cfg.Crossfade))
+	ctl.SetGaplessAlbums(cfg.GaplessAlbums)
+	result, _, scanErr := ctl.SetLibrary(ctx, cfg.Library, cfg.ScannerOptions(), progress)
+	if scanErr != nil && !errors.Is(scanErr, context.Canceled) {
+		return nil, scanErr
 	}
-
-	currentTrackIndex = index - 1
-	track := tracks[currentTrackIndex]
-	fmt.Printf("🎯 Jumping to track %d: %s\n", index, track.String())
-
-	loadFile(p, track.Path)
-
-	if err := p.Play(); err != nil {
-		fmt.Printf("❌ Failed to play track: %v\n", err)
-	} else {
-		fmt.Println("▶️  Playing selected track")
+	if err := ctl.WatchLibrary(cfg.Watch); err != nil {
+		return nil, err
 	}
+	return result, scanErr
 }
 
-func rescanAudioFiles(scanner *playlist.Scanner) {
-	fmt.Println("🔄 Rescanning audio files...")
-	result, err := scanner.Scan()
+// runTUI starts the full-screen interface
+func runTUI(opts *options) int {
+	ctl, shutdown := newController(opts, true)
+	err := ui.RunWithOptions(ctl, ui.Options{
+		Keys: opts.cfg.Keys,
+		Reload: func(ctx context.Context, progress func(playlist.ScanProgress)) (map[string][]string, error) {
+			_, err := reload(ctx, opts, ctl, progress)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				return nil, err
+			}
+			return opts.cfg.Keys, err
+		},
+	})
+	shutdown()
 	if err != nil {
-		fmt.Printf("❌ Rescan failed: %v\n", err)
-		return
-	}
-
-	fmt.Printf("✅ Rescan completed!\n")
-	fmt.Printf("📊 Results:\n")
-	fmt.Printf("  Total tracks: %d\n", result.TotalFiles)
-	fmt.Printf("  New tracks: %d\n", result.NewTracks)
-	fmt.Printf("  Updated tracks: %d\n", result.UpdatedTracks)
-	fmt.Printf("  Removed tracks: %d\n", result.RemovedTracks)
-
-	if len(result.Errors) > 0 {
-		fmt.Printf("⚠️  Errors encountered:\n")
-		for _, err := range result.Errors {
-			fmt.Printf("    - %s\n", err)
-		}
+		fmt.Printf("❌ %v\n", err)
+		return exitFailure
 	}
+	return exitOK
+}
 
-	// Reset current track index if it's out of bounds
-	tracks := scanner.GetTracks()
-	if currentTrackIndex >= len(tracks) {
-		currentTrackIndex = -1
-	}
+// fail reports an error on stderr and returns the failure exit code
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+	return exitFailure
 }
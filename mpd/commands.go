@@ -0,0 +1,388 @@
+package mpd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"perth/internal"
+	"perth/player"
+	"perth/playlist"
+)
+
+// command is one protocol command with the number of arguments it accepts
+type command struct {
+	min, max int
+	run      func(ctl *internal.Controller, args []string, r *response) error
+}
+
+// commands maps command names to their handlers. idle, noidle, close and
+// the command list commands change the connection state and are handled
+// by the client instead.
+var commands = map[string]command{
+	"ping":         {0, 0, func(*internal.Controller, []string, *response) error { return nil }},
+	"status":       {0, 0, status},
+	"currentsong":  {0, 0, currentSong},
+	"play":         {0, 1, play},
+	"pause":        {0, 1, pause},
+	"stop":         {0, 0, stop},
+	"next":         {0, 0, next},
+	"previous":     {0, 0, previous},
+	"seekcur":      {1, 1, seekCur},
+	"setvol":       {1, 1, setVol},
+	"playlistinfo": {0, 1, playlistInfo},
+	"add":          {1, 1, add},
+	"clear":        {0, 0, clearQueue},
+}
+
+func init() {
+	// listCommands reads the table, so it cannot be part of its initializer
+	commands["commands"] = command{0, 0, listCommands}
+}
+
+// run looks up and runs a command
+func run(ctl *internal.Controller, name string, args []string, r *response) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return errorf(ackUnknown, "unknown command %q", name)
+	}
+	if len(args) < cmd.min || len(args) > cmd.max {
+		return errorf(ackArg, "wrong number of arguments for %q", name)
+	}
+	return cmd.run(ctl, args, r)
+}
+
+func listCommands(_ *internal.Controller, _ []string, r *response) error {
+	names := []string{"close", "command_list_begin", "command_list_end", "command_list_ok_begin", "idle", "noidle"}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.field("command", name)
+	}
+	return nil
+}
+
+func status(ctl *internal.Controller, _ []string, r *response) error {
+	st := ctl.Status()
+
+	repeat, single := 0, 0
+	switch st.Repeat {
+	case playlist.RepeatAll:
+		repeat = 1
+	case playlist.RepeatOne:
+		repeat, single = 1, 1
+	}
+
+	r.field("volume", int(math.Round(st.Volume*100)))
+	r.field("repeat", repeat)
+	r.field("random", boolInt(st.Shuffle))
+	r.field("single", single)
+	r.field("consume", 0)
+	r.field("playlist", st.QueueVersion)
+	r.field("playlistlength", st.QueueLength)
+	r.field("xfade", int(st.Crossfade.Seconds()))
+
+	state := stateName(st.State)
+	r.field("state", state)
+	if st.Track != nil && st.QueueIndex >= 0 {
+		r.field("song", st.QueueIndex)
+		r.field("songid", st.QueueIndex)
+	}
+	if state != "stop" {
+		r.field("time", fmt.Sprintf("%d:%d", int(st.Position.Seconds()), int(st.Duration.Seconds())))
+		r.field("elapsed", seconds(st.Position))
+		r.field("duration", seconds(st.Duration))
+	}
+	return nil
+}
+
+func currentSong(ctl *internal.Controller, _ []string, r *response) error {
+	st := ctl.Status()
+	switch {
+	case st.Track != nil:
+		writeSong(r, newURIs(ctl), st.Track, st.QueueIndex)
+	case st.Path != "":
+		// A file loaded from outside the library
+		r.field("file", st.Path)
+	}
+	return nil
+}
+
+func play(ctl *internal.Controller, args []string, _ *response) error {
+	if len(args) == 0 {
+		return fail(ctl.Play())
+	}
+	pos, err := songIndex(args[0])
+	if err != nil {
+		return err
+	}
+	return fail(ctl.PlayQueued(pos))
+}
+
+// pause toggles without an argument; "1" pauses and "0" resumes
+func pause(ctl *internal.Controller, args []string, _ *response) error {
+	var err error
+	switch {
+	case len(args) == 0:
+		err = ctl.Toggle()
+	case args[0] == "1":
+		err = ctl.Pause()
+	case args[0] == "0":
+		err = ctl.Play()
+	default:
+		return errorf(ackArg, "boolean (0/1) expected: %s", args[0])
+	}
+	// Like MPD, pausing while stopped is not an error
+	var transition *player.TransitionError
+	if errors.As(err, &transition) && len(args) > 0 && args[0] == "1" {
+		return nil
+	}
+	return fail(err)
+}
+
+func stop(ctl *internal.Controller, _ []string, _ *response) error {
+	var transition *player.TransitionError
+	if err := ctl.Stop(); err != nil && !errors.As(err, &transition) {
+		return fail(err)
+	}
+	return nil
+}
+
+// next and previous do nothing at either end of the queue, as in MPD
+func next(ctl *internal.Controller, _ []string, _ *response) error {
+	err := ctl.Next()
+	if errors.Is(err, internal.ErrEndOfQueue) || errors.Is(err, internal.ErrEmptyQueue) {
+		return nil
+	}
+	return fail(err)
+}
+
+func previous(ctl *internal.Controller, _ []string, _ *response) error {
+	err := ctl.Prev()
+	if errors.Is(err, internal.ErrStartOfQueue) || errors.Is(err, internal.ErrEmptyQueue) {
+		return nil
+	}
+	return fail(err)
+}
+
+// seekCur seeks to an absolute time in seconds, or relative to the
+// current position when prefixed with + or -
+func seekCur(ctl *internal.Controller, args []string, _ *response) error {
+	value, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return errorf(ackArg, "Number expected: %s", args[0])
+	}
+	d := time.Duration(value * float64(time.Second))
+	if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+		return fail(ctl.SeekBy(d))
+	}
+	return fail(ctl.Seek(d))
+}
+
+func setVol(ctl *internal.Controller, args []string, _ *response) error {
+	volume, err := strconv.Atoi(args[0])
+	if err != nil || volume < 0 || volume > 100 {
+		return errorf(ackArg, "Invalid volume value: %s", args[0])
+	}
+	ctl.SetVolume(float64(volume) / 100)
+	return nil
+}
+
+// playlistInfo lists the whole queue, one entry, or a START:END range
+func playlistInfo(ctl *internal.Controller, args []string, r *response) error {
+	entries := ctl.Queue()
+	uris := newURIs(ctl)
+	start, end := 0, len(entries)
+	if len(args) == 1 {
+		var err error
+		if start, end, err = songRange(args[0], len(entries)); err != nil {
+			return err
+		}
+	}
+	for i := start; i < end; i++ {
+		if track := entries[i].Track; track != nil {
+			writeSong(r, uris, track, i)
+		}
+	}
+	return nil
+}
+
+// add queues the library track at uri, or every track below uri if it
+// names a directory. The empty uri adds the whole library. Absolute paths
+// are accepted too, as older clients send them.
+func add(ctl *internal.Controller, args []string, _ *response) error {
+	uri := args[0]
+	if uri != "" {
+		uri = path.Clean(uri)
+	}
+	if uri == "." || uri == "/" {
+		uri = ""
+	}
+	below := func(file string) bool {
+		return uri == "" || file == uri || strings.HasPrefix(file, uri+"/")
+	}
+
+	uris := newURIs(ctl)
+	var ids []string
+	for _, track := range ctl.Tracks() {
+		if below(uris.of(track)) || path.IsAbs(uri) && below(absSlash(track.Path)) {
+			ids = append(ids, track.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return errorf(ackNoExist, "No such song: %s", args[0])
+	}
+	ctl.Enqueue(ids...)
+	return nil
+}
+
+func clearQueue(ctl *internal.Controller, _ []string, _ *response) error {
+	ctl.ClearQueue()
+	return nil
+}
+
+// uris maps tracks to the URIs clients know them by: their paths relative
+// to the library directory they are in, as MPD does with its music
+// directory. With several library directories the URIs start with the
+// directory name, so they stay apart.
+type uris struct {
+	roots []string // Absolute library paths, with slashes
+	named bool     // Prefix URIs with the name of their root
+}
+
+func newURIs(ctl *internal.Controller) uris {
+	paths := ctl.LibraryPaths()
+	u := uris{named: len(paths) > 1}
+	for _, root := range paths {
+		u.roots = append(u.roots, absSlash(root))
+	}
+	return u
+}
+
+// of returns the URI of a track
+func (u uris) of(track *playlist.Track) string {
+	file := absSlash(track.Path)
+	for _, root := range u.roots {
+		if file == root {
+			// A single file in the library
+			return path.Base(file)
+		}
+		if rel, ok := strings.CutPrefix(file, strings.TrimSuffix(root, "/")+"/"); ok {
+			if u.named {
+				return path.Join(path.Base(root), rel)
+			}
+			return rel
+		}
+	}
+	return file
+}
+
+// absSlash returns the absolute form of a file path, with slashes
+func absSlash(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	return filepath.ToSlash(file)
+}
+
+// writeSong writes the tags of a queue entry
+func writeSong(r *response, uris uris, track *playlist.Track, pos int) {
+	r.field("file", uris.of(track))
+	r.field("Last-Modified", track.Modified.UTC().Format(time.RFC3339))
+	if artist := track.Artist(); artist != "" {
+		r.field("Artist", artist)
+	}
+	if album := track.Album(); album != "" {
+		r.field("Album", album)
+	}
+	r.field("Title", track.DisplayName())
+	if genre := track.Genre(); genre != "" {
+		r.field("Genre", genre)
+	}
+	if year := track.Year(); year > 0 {
+		r.field("Date", year)
+	}
+	r.field("Time", int(track.Duration.Seconds()))
+	r.field("duration", seconds(track.Duration))
+	if pos >= 0 {
+		// Entries have no identity apart from their position
+		r.field("Pos", pos)
+		r.field("Id", pos)
+	}
+}
+
+// fail maps controller errors to ACK errors
+func fail(err error) error {
+	var transition *player.TransitionError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &transition):
+		return errorf(ackPlayerSync, "%v", err)
+	case errors.Is(err, internal.ErrMissingTrack):
+		return errorf(ackNoExist, "%v", err)
+	case errors.Is(err, internal.ErrEmptyQueue):
+		return errorf(ackNoExist, "%v", err)
+	}
+	return err
+}
+
+func songIndex(s string) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return 0, errorf(ackArg, "Bad song index: %s", s)
+	}
+	return i, nil
+}
+
+// songRange parses SONGPOS or START:END (END exclusive and optional)
+// against a queue of n entries
+func songRange(s string, n int) (int, int, error) {
+	first, last, isRange := strings.Cut(s, ":")
+	start, err := songIndex(first)
+	if err != nil {
+		return 0, 0, err
+	}
+	end := start + 1
+	if isRange {
+		end = n
+		if last != "" {
+			if end, err = songIndex(last); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if start > n || (!isRange && start == n) || end < start {
+		return 0, 0, errorf(ackArg, "Bad song index: %s", s)
+	}
+	return start, min(end, n), nil
+}
+
+func stateName(s player.State) string {
+	switch s {
+	case player.StatePlaying:
+		return "play"
+	case player.StatePaused:
+		return "pause"
+	}
+	return "stop"
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
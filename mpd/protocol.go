@@ -0,0 +1,127 @@
+package mpd
+
+import (
+	"fmt"
+	"strings"
+
+	"perth/internal"
+)
+
+// protocolVersion is announced in the greeting. Clients use it to decide
+// which commands they may send; Perth implements a subset of 0.23.
+const protocolVersion = "0.23.0"
+
+// ACK error codes from the MPD protocol
+const (
+	ackNotList    = 1
+	ackArg        = 2
+	ackUnknown    = 5
+	ackNoExist    = 50
+	ackSystem     = 52
+	ackPlayerSync = 55
+)
+
+// ackError is a failed command, written to the client as an ACK line
+type ackError struct {
+	code    int
+	message string
+}
+
+func (e *ackError) Error() string {
+	return e.message
+}
+
+func errorf(code int, format string, args ...any) error {
+	return &ackError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// ack formats err as the ACK line for the command at index i of a
+// command list (0 outside of lists)
+func ack(err error, i int, command string) string {
+	code := ackSystem
+	if e, ok := err.(*ackError); ok {
+		code = e.code
+	}
+	return fmt.Sprintf("ACK [%d@%d] {%s} %s\n", code, i, command, err.Error())
+}
+
+// parseLine splits a request line into the command and its arguments.
+// Arguments are separated by spaces and may be double-quoted, with
+// backslash escaping quotes and backslashes inside quotes.
+func parseLine(line string) (string, []string, error) {
+	var words []string
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t':
+			i++
+			continue
+		case '"':
+			var b strings.Builder
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return "", nil, errorf(ackArg, "missing closing '\"'")
+			}
+			i++
+			words = append(words, b.String())
+		default:
+			end := strings.IndexAny(line[i:], " \t")
+			if end < 0 {
+				end = len(line) - i
+			}
+			words = append(words, line[i:i+end])
+			i += end
+		}
+	}
+	if len(words) == 0 {
+		return "", nil, errorf(ackUnknown, "No command given")
+	}
+	return words[0], words[1:], nil
+}
+
+// response collects the "key: value" lines of a command's reply
+type response struct {
+	b strings.Builder
+}
+
+func (r *response) field(key string, value any) {
+	fmt.Fprintf(&r.b, "%s: %v\n", key, value)
+}
+
+// subsystem names an idle subsystem
+type subsystem string
+
+const (
+	subsystemDatabase subsystem = "database"
+	subsystemPlaylist subsystem = "playlist"
+	subsystemPlayer   subsystem = "player"
+	subsystemMixer    subsystem = "mixer"
+	subsystemOptions  subsystem = "options"
+)
+
+// subsystems lists the subsystems Perth reports, in the order changes are
+// written
+var subsystems = []subsystem{subsystemDatabase, subsystemPlaylist, subsystemPlayer, subsystemMixer, subsystemOptions}
+
+// subsystemOf returns the idle subsystem a controller event belongs to,
+// or "" if clients are not told about it
+func subsystemOf(e internal.Event) subsystem {
+	switch e.Type {
+	case internal.EventTrackChanged, internal.EventPlaybackChanged, internal.EventQueueEnded:
+		return subsystemPlayer
+	case internal.EventVolumeChanged:
+		return subsystemMixer
+	case internal.EventModeChanged:
+		return subsystemOptions
+	case internal.EventQueueChanged:
+		return subsystemPlaylist
+	case internal.EventLibraryChanged:
+		return subsystemDatabase
+	}
+	return ""
+}
@@ -0,0 +1,304 @@
+// Package mpd serves a subset of the Music Player Daemon protocol on top
+// of a Controller, so Perth can be driven by scripts and existing MPD
+// clients.
+package mpd
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"perth/internal"
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("mpd: server closed")
+
+// maxLine is the longest request line accepted from a client
+const maxLine = 64 * 1024
+
+// Server accepts MPD clients on any number of listeners
+type Server struct {
+	ctl *internal.Controller
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New creates a Server that drives ctl
+func New(ctl *internal.Controller) *Server {
+	return &Server{
+		ctl:       ctl,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts clients on l until the server is closed, then returns
+// ErrServerClosed
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops all listeners, disconnects all clients and waits for their
+// connections to finish
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// client is the state of one connection
+type client struct {
+	ctl *internal.Controller
+	w   *bufio.Writer
+
+	pending map[subsystem]bool // Changes not yet reported by idle
+	idle    map[subsystem]bool // Subsystems waited for, nil when not idle
+
+	list   []string // Commands collected since command_list_begin
+	inList bool
+	listOK bool // command_list_ok_begin: acknowledge every command
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	// Subscribe before greeting so no change after the first command is missed
+	events, cancel := s.ctl.Subscribe()
+	defer cancel()
+
+	c := &client{
+		ctl:     s.ctl,
+		w:       bufio.NewWriter(conn),
+		pending: make(map[subsystem]bool),
+	}
+	done := make(chan struct{})
+	defer close(done)
+	lines := readLines(conn, done)
+
+	c.w.WriteString("OK MPD " + protocolVersion + "\n")
+	for {
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case line, ok := <-lines:
+			if !ok || !c.handle(line) {
+				c.w.Flush()
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if sub := subsystemOf(e); sub != "" {
+				c.pending[sub] = true
+				c.notify(false)
+			}
+		}
+	}
+}
+
+// readLines delivers the lines read from r until it fails or done is closed
+func readLines(r io.Reader, done <-chan struct{}) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 4096), maxLine)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// handle processes one request line and reports whether the connection
+// stays open
+func (c *client) handle(line string) bool {
+	if c.idle != nil {
+		// Only noidle may interrupt idle; anything else ends the connection
+		if line != "noidle" {
+			return false
+		}
+		c.notify(true)
+		return true
+	}
+
+	if c.inList {
+		if line != "command_list_end" {
+			c.list = append(c.list, line)
+			return true
+		}
+		c.runList()
+		return true
+	}
+
+	name, args, err := parseLine(line)
+	if err != nil {
+		c.w.WriteString(ack(err, 0, name))
+		return true
+	}
+	switch name {
+	case "close":
+		return false
+	case "noidle":
+		// Not idle; nothing to interrupt
+	case "idle":
+		if err := c.startIdle(args); err != nil {
+			c.w.WriteString(ack(err, 0, name))
+		}
+	case "command_list_begin", "command_list_ok_begin":
+		c.inList, c.listOK, c.list = true, name == "command_list_ok_begin", nil
+	case "command_list_end":
+		c.w.WriteString(ack(errorf(ackNotList, "not in command list"), 0, name))
+	default:
+		var r response
+		if err := run(c.ctl, name, args, &r); err != nil {
+			c.w.WriteString(ack(err, 0, name))
+			return true
+		}
+		c.w.WriteString(r.b.String())
+		c.w.WriteString("OK\n")
+	}
+	return true
+}
+
+// runList runs the collected command list, stopping at the first error
+func (c *client) runList() {
+	list := c.list
+	c.inList, c.list = false, nil
+
+	for i, line := range list {
+		name, args, err := parseLine(line)
+		if err == nil {
+			switch name {
+			case "idle", "noidle", "close", "command_list_begin", "command_list_ok_begin":
+				err = errorf(ackArg, "%q is not allowed in command lists", name)
+			}
+		}
+		var r response
+		if err == nil {
+			err = run(c.ctl, name, args, &r)
+		}
+		if err != nil {
+			c.w.WriteString(ack(err, i, name))
+			return
+		}
+		c.w.WriteString(r.b.String())
+		if c.listOK {
+			c.w.WriteString("list_OK\n")
+		}
+	}
+	c.w.WriteString("OK\n")
+}
+
+// startIdle waits for changes to the given subsystems, or all of them
+func (c *client) startIdle(names []string) error {
+	idle := make(map[subsystem]bool)
+	for _, name := range names {
+		sub := subsystem(name)
+		known := false
+		for _, s := range subsystems {
+			known = known || s == sub
+		}
+		if !known {
+			return errorf(ackArg, "Unrecognized idle event: %s", name)
+		}
+		idle[sub] = true
+	}
+	if len(idle) == 0 {
+		for _, s := range subsystems {
+			idle[s] = true
+		}
+	}
+	c.idle = idle
+	c.notify(false)
+	return nil
+}
+
+// notify ends an idle command by reporting the pending changes it waits
+// for. Unless force is set it keeps waiting while there are none.
+func (c *client) notify(force bool) {
+	if c.idle == nil {
+		return
+	}
+	var changed []subsystem
+	for _, sub := range subsystems {
+		if c.idle[sub] && c.pending[sub] {
+			changed = append(changed, sub)
+		}
+	}
+	if len(changed) == 0 && !force {
+		return
+	}
+	for _, sub := range changed {
+		c.w.WriteString("changed: " + string(sub) + "\n")
+		delete(c.pending, sub)
+	}
+	c.w.WriteString("OK\n")
+	c.idle = nil
+}
@@ -0,0 +1,269 @@
+package mpd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"perth/internal"
+	"perth/player"
+	"perth/playlist"
+)
+
+// writeSilence writes a short 16-bit stereo WAV file of silence
+func writeSilence(t *testing.T, path string) {
+	t.Helper()
+	const rate, frames = 8000, 800
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 36+frames*4)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1)
+	binary.LittleEndian.PutUint16(h[22:], 2)
+	binary.LittleEndian.PutUint32(h[24:], rate)
+	binary.LittleEndian.PutUint32(h[28:], rate*4)
+	binary.LittleEndian.PutUint16(h[32:], 4)
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], frames*4)
+	if err := os.WriteFile(path, append(h, make([]byte, frames*4)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testClient is the client end of a connection to a server
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// connect serves a connection over a pipe on a controller whose library
+// holds files with the given names, and reads the greeting
+func connect(t *testing.T, files ...string) (*testClient, *internal.Controller) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range files {
+		writeSilence(t, filepath.Join(dir, name))
+	}
+	library := playlist.NewScannerWithOptions([]string{dir}, playlist.ScannerOptions{NoCache: true})
+	if _, err := library.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	ctl := internal.New(player.NewWithOptions(player.Options{Output: player.NewNullOutput()}), library, playlist.NewQueue())
+
+	server, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		New(ctl).serveConn(server)
+	}()
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+		ctl.Close()
+	})
+
+	c := &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	if greeting := c.line(); greeting != "OK MPD "+protocolVersion {
+		t.Fatalf("greeting = %q", greeting)
+	}
+	return c, ctl
+}
+
+// send writes a request line
+func (c *testClient) send(line string) {
+	c.t.Helper()
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		c.t.Fatalf("sending %q: %v", line, err)
+	}
+}
+
+// line reads a response line
+func (c *testClient) line() string {
+	c.t.Helper()
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("reading a response: %v", err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+// response reads the lines up to and including the final OK or ACK line
+func (c *testClient) response() []string {
+	c.t.Helper()
+	var lines []string
+	for {
+		line := c.line()
+		lines = append(lines, line)
+		if line == "OK" || strings.HasPrefix(line, "ACK ") {
+			return lines
+		}
+	}
+}
+
+// do sends a request and returns its response
+func (c *testClient) do(line string) []string {
+	c.t.Helper()
+	c.send(line)
+	return c.response()
+}
+
+// field returns the value of the first key line in lines
+func field(lines []string, key string) string {
+	for _, line := range lines {
+		if value, ok := strings.CutPrefix(line, key+": "); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+		args []string
+	}{
+		{"status", "status", nil},
+		{"  setvol\t50 ", "setvol", []string{"50"}},
+		{`add "with spaces.wav"`, "add", []string{"with spaces.wav"}},
+		{`add "say \"hi\".wav"`, "add", []string{`say "hi".wav`}},
+		{`add "back\\slash.wav"`, "add", []string{`back\slash.wav`}},
+		{`add ""`, "add", []string{""}},
+		{`playlistinfo "1:2" 3`, "playlistinfo", []string{"1:2", "3"}},
+		{`"quoted command"`, "quoted command", nil},
+	}
+	for _, tt := range tests {
+		name, args, err := parseLine(tt.line)
+		if err != nil {
+			t.Errorf("parseLine(%q): %v", tt.line, err)
+			continue
+		}
+		if name != tt.name || !slices.Equal(args, tt.args) {
+			t.Errorf("parseLine(%q) = %q, %q, want %q, %q", tt.line, name, args, tt.name, tt.args)
+		}
+	}
+
+	for _, line := range []string{"", "   ", `add "unterminated`, `add "escaped end\"`} {
+		if _, _, err := parseLine(line); err == nil {
+			t.Errorf("parseLine(%q) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestQuotedArguments(t *testing.T) {
+	c, _ := connect(t, `say "hi".wav`, `back\slash.wav`)
+
+	for _, line := range []string{`add "say \"hi\".wav"`, `add "back\\slash.wav"`} {
+		if got := c.do(line); !slices.Equal(got, []string{"OK"}) {
+			t.Fatalf("%s: %q", line, got)
+		}
+	}
+	var files []string
+	for _, line := range c.do("playlistinfo") {
+		if file, ok := strings.CutPrefix(line, "file: "); ok {
+			files = append(files, file)
+		}
+	}
+	if want := []string{`say "hi".wav`, `back\slash.wav`}; !slices.Equal(files, want) {
+		t.Errorf("queued %q, want %q", files, want)
+	}
+
+	if got := c.do(`add "say`); len(got) != 1 || !strings.HasPrefix(got[0], "ACK [2@0]") {
+		t.Errorf("unterminated quote: %q, want an argument ACK", got)
+	}
+}
+
+func TestCommandLists(t *testing.T) {
+	c, _ := connect(t)
+
+	c.send("command_list_begin")
+	c.send("setvol 40")
+	c.send("ping")
+	if got := c.do("command_list_end"); !slices.Equal(got, []string{"OK"}) {
+		t.Errorf("command list: %q, want only OK", got)
+	}
+
+	c.send("command_list_ok_begin")
+	c.send("setvol 60")
+	c.send("status")
+	got := c.do("command_list_end")
+	if n := len(got); n < 3 || got[n-2] != "list_OK" || got[n-1] != "OK" || got[0] != "list_OK" {
+		t.Errorf("command_list_ok_begin: %q, want list_OK after each command, then OK", got)
+	}
+	if v := field(got, "volume"); v != "60" {
+		t.Errorf("status in the list reports volume %q, want 60", v)
+	}
+
+	if got := c.do("command_list_end"); len(got) != 1 || !strings.HasPrefix(got[0], "ACK [1@0] {command_list_end}") {
+		t.Errorf("command_list_end outside a list: %q", got)
+	}
+}
+
+func TestCommandListStopsAtTheFirstError(t *testing.T) {
+	c, _ := connect(t)
+
+	c.send("command_list_ok_begin")
+	c.send("setvol 30")
+	c.send("ping")
+	c.send("nosuchcommand")
+	c.send("setvol 70")
+	got := c.do("command_list_end")
+	want := []string{"list_OK", "list_OK"}
+	if len(got) != 3 || !slices.Equal(got[:2], want) || !strings.HasPrefix(got[2], "ACK [5@2] {nosuchcommand}") {
+		t.Fatalf("list with an error: %q, want two list_OK and an ACK for entry 2", got)
+	}
+	if v := field(c.do("status"), "volume"); v != "30" {
+		t.Errorf("volume = %s, want 30: the list ran past its error", v)
+	}
+
+	c.send("command_list_begin")
+	c.send("ping")
+	c.send("idle")
+	if got := c.do("command_list_end"); len(got) != 1 || !strings.HasPrefix(got[0], "ACK [2@1] {idle}") {
+		t.Errorf("idle in a list: %q, want an ACK for entry 1", got)
+	}
+}
+
+func TestIdleInterruptedByNoidle(t *testing.T) {
+	c, _ := connect(t)
+
+	c.send("idle")
+	c.send("noidle")
+	if got := c.response(); !slices.Equal(got, []string{"OK"}) {
+		t.Errorf("noidle: %q, want OK without changes", got)
+	}
+	// The connection is usable again
+	if got := c.do("ping"); !slices.Equal(got, []string{"OK"}) {
+		t.Errorf("ping after noidle: %q", got)
+	}
+}
+
+func TestIdleInterruptedByPlayerEvent(t *testing.T) {
+	c, ctl := connect(t, "a.wav")
+	if got := c.do(`add "a.wav"`); !slices.Equal(got, []string{"OK"}) {
+		t.Fatalf("add: %q", got)
+	}
+	// Changes before idle are reported by it; drain the one from add
+	if got := c.do("idle playlist"); !slices.Equal(got, []string{"changed: playlist", "OK"}) {
+		t.Fatalf("idle playlist: %q", got)
+	}
+
+	c.send("idle player")
+	if err := ctl.Play(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.response(); !slices.Equal(got, []string{"changed: player", "OK"}) {
+		t.Errorf("idle player: %q, want the player change", got)
+	}
+}
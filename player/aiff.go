@@ -0,0 +1,227 @@
+package player
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/faiface/beep"
+)
+
+// aiffDecoder 解码未压缩的 AIFF/AIFC（NONE、twos、sowt）PCM 数据
+type aiffDecoder struct {
+	r            io.ReadSeeker
+	format       beep.Format
+	dataStart    int64 // SSND 中第一帧的文件偏移
+	frames       int
+	frameSize    int
+	littleEndian bool
+	pos          int
+	buf          []byte
+	err          error
+}
+
+func decodeAIFF(r io.ReadSeeker) (beep.StreamSeekCloser, beep.Format, error) {
+	d, err := newAIFFDecoder(r)
+	if err != nil {
+		return nil, beep.Format{}, fmt.Errorf("aiff: %w", err)
+	}
+	return d, d.format, nil
+}
+
+func newAIFFDecoder(r io.ReadSeeker) (*aiffDecoder, error) {
+	var form [12]byte
+	if _, err := io.ReadFull(r, form[:]); err != nil {
+		return nil, err
+	}
+	if string(form[0:4]) != "FORM" {
+		return nil, errors.New("missing FORM header")
+	}
+	aifc := false
+	switch string(form[8:12]) {
+	case "AIFF":
+	case "AIFC":
+		aifc = true
+	default:
+		return nil, fmt.Errorf("unknown form type %q", form[8:12])
+	}
+
+	d := &aiffDecoder{r: r}
+	var haveComm, haveData bool
+	var bits int
+
+	for !haveComm || !haveData {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+		id := string(hdr[0:4])
+		size := int64(binary.BigEndian.Uint32(hdr[4:8]))
+		start, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case "COMM":
+			// 只读需要的字段，其余部分与填充字节一起跳过；长度来自文件，不能用来分配内存
+			need := int64(18)
+			if aifc {
+				need = 22
+			}
+			if size < need {
+				return nil, fmt.Errorf("short COMM chunk: %d bytes", size)
+			}
+			comm := make([]byte, need)
+			if _, err := io.ReadFull(r, comm); err != nil {
+				return nil, err
+			}
+			d.format.NumChannels = int(binary.BigEndian.Uint16(comm[0:2]))
+			d.frames = int(binary.BigEndian.Uint32(comm[2:6]))
+			bits = int(binary.BigEndian.Uint16(comm[6:8]))
+			d.format.SampleRate = beep.SampleRate(extendedToFloat(comm[8:18]))
+			if aifc {
+				switch compression := string(comm[18:22]); compression {
+				case "NONE", "twos":
+				case "sowt":
+					d.littleEndian = true
+				default:
+					return nil, fmt.Errorf("unsupported compression %q", compression)
+				}
+			}
+			haveComm = true
+
+		case "SSND":
+			// offset 与 blockSize 两个字段之后才是采样数据
+			if size < 8 {
+				return nil, fmt.Errorf("short SSND chunk: %d bytes", size)
+			}
+			var ssnd [8]byte
+			if _, err := io.ReadFull(r, ssnd[:]); err != nil {
+				return nil, err
+			}
+			d.dataStart = start + 8 + int64(binary.BigEndian.Uint32(ssnd[0:4]))
+			haveData = true
+		}
+
+		// 块长度为奇数时有一个填充字节
+		next := start + size + size%2
+		if _, err := r.Seek(next, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	if !haveComm || !haveData {
+		return nil, errors.New("missing COMM or SSND chunk")
+	}
+	if d.format.NumChannels < 1 {
+		return nil, errors.New("no channels")
+	}
+	if bits < 1 || bits > 32 {
+		return nil, fmt.Errorf("unsupported sample size: %d bits", bits)
+	}
+	if d.format.SampleRate <= 0 {
+		return nil, errors.New("invalid sample rate")
+	}
+
+	d.format.Precision = (bits + 7) / 8
+	d.frameSize = d.format.NumChannels * d.format.Precision
+	if _, err := r.Seek(d.dataStart, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// extendedToFloat 把 80 位 IEEE 扩展精度浮点数（AIFF 采样率字段）转换为 float64
+func extendedToFloat(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b[0:2]) & 0x7fff)
+	mant := binary.BigEndian.Uint64(b[2:10])
+	if exp == 0 && mant == 0 {
+		return 0
+	}
+	f := math.Ldexp(float64(mant), exp-16383-63)
+	if b[0]&0x80 != 0 {
+		f = -f
+	}
+	return f
+}
+
+func (d *aiffDecoder) Stream(samples [][2]float64) (n int, ok bool) {
+	if d.err != nil || d.pos >= d.frames {
+		return 0, false
+	}
+	want := len(samples)
+	if left := d.frames - d.pos; want > left {
+		want = left
+	}
+	if len(d.buf) < want*d.frameSize {
+		d.buf = make([]byte, want*d.frameSize)
+	}
+
+	read, err := io.ReadFull(d.r, d.buf[:want*d.frameSize])
+	for n = 0; n < read/d.frameSize; n++ {
+		frame := d.buf[n*d.frameSize:]
+		left := d.sample(frame)
+		right := left
+		if d.format.NumChannels > 1 {
+			right = d.sample(frame[d.format.Precision:])
+		}
+		samples[n] = [2]float64{left, right}
+	}
+	d.pos += n
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		d.err = err
+	}
+	if n < want {
+		// 文件比 COMM 中声明的短
+		d.frames = d.pos
+	}
+	return n, n > 0
+}
+
+// sample 解码一个有符号 PCM 采样并归一化到 [-1, 1)
+func (d *aiffDecoder) sample(b []byte) float64 {
+	width := d.format.Precision
+	var v uint32
+	for i := 0; i < width; i++ {
+		idx := i
+		if d.littleEndian {
+			idx = width - 1 - i
+		}
+		v = v<<8 | uint32(b[idx])
+	}
+	shift := uint(32 - width*8)
+	return float64(int32(v<<shift)) / (1 << 31)
+}
+
+func (d *aiffDecoder) Err() error {
+	return d.err
+}
+
+func (d *aiffDecoder) Len() int {
+	return d.frames
+}
+
+func (d *aiffDecoder) Position() int {
+	return d.pos
+}
+
+func (d *aiffDecoder) Seek(p int) error {
+	if p < 0 || p > d.frames {
+		return fmt.Errorf("aiff: seek position %d out of range [0, %d]", p, d.frames)
+	}
+	if _, err := d.r.Seek(d.dataStart+int64(p*d.frameSize), io.SeekStart); err != nil {
+		return fmt.Errorf("aiff: %w", err)
+	}
+	d.pos = p
+	return nil
+}
+
+func (d *aiffDecoder) Close() error {
+	return nil
+}
@@ -0,0 +1,173 @@
+package player
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"testing"
+)
+
+// chunk 组装一个 IFF 块，长度为奇数时补一个填充字节；size 为负时按内容长度填写
+func chunk(id string, size int64, body []byte) []byte {
+	if size < 0 {
+		size = int64(len(body))
+	}
+	b := make([]byte, 8, 8+len(body)+1)
+	copy(b, id)
+	binary.BigEndian.PutUint32(b[4:], uint32(size))
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// form 用给定的块组装一个 FORM 文件
+func form(kind string, chunks ...[]byte) []byte {
+	body := []byte(kind)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	b := make([]byte, 8, 8+len(body))
+	copy(b, "FORM")
+	binary.BigEndian.PutUint32(b[4:], uint32(len(body)))
+	return append(b, body...)
+}
+
+// comm 生成 COMM 块内容；compression 非空时追加 AIFC 的压缩类型
+func comm(channels, frames, sampleBits int, rate uint64, compression string) []byte {
+	b := make([]byte, 18)
+	binary.BigEndian.PutUint16(b[0:], uint16(channels))
+	binary.BigEndian.PutUint32(b[2:], uint32(frames))
+	binary.BigEndian.PutUint16(b[6:], uint16(sampleBits))
+	// 80 位扩展精度：最高位为整数位
+	shift := bits.LeadingZeros64(rate)
+	binary.BigEndian.PutUint16(b[8:], uint16(16383+63-shift))
+	binary.BigEndian.PutUint64(b[10:], rate<<shift)
+	if compression != "" {
+		b = append(b, compression...)
+	}
+	return b
+}
+
+// ssnd 生成 SSND 块内容，offset 与 blockSize 为 0
+func ssnd(data []byte) []byte {
+	return append(make([]byte, 8), data...)
+}
+
+// stereo16 生成两帧 16 位立体声采样 (0.5, -0.5), (-0.25, 0.25)
+func stereo16(littleEndian bool) []byte {
+	order := binary.AppendByteOrder(binary.BigEndian)
+	if littleEndian {
+		order = binary.LittleEndian
+	}
+	var b []byte
+	for _, v := range []int16{0x4000, -0x4000, -0x2000, 0x2000} {
+		b = order.AppendUint16(b, uint16(v))
+	}
+	return b
+}
+
+func TestAIFFDecoder(t *testing.T) {
+	want := [][2]float64{{0.5, -0.5}, {-0.25, 0.25}}
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"aiff", form("AIFF", chunk("COMM", -1, comm(2, 2, 16, 44100, "")), chunk("SSND", -1, ssnd(stereo16(false))))},
+		{"aifc none", form("AIFC", chunk("COMM", -1, comm(2, 2, 16, 44100, "NONE")), chunk("SSND", -1, ssnd(stereo16(false))))},
+		{"aifc twos", form("AIFC", chunk("COMM", -1, comm(2, 2, 16, 44100, "twos")), chunk("SSND", -1, ssnd(stereo16(false))))},
+		{"aifc sowt", form("AIFC", chunk("COMM", -1, comm(2, 2, 16, 44100, "sowt")), chunk("SSND", -1, ssnd(stereo16(true))))},
+		{
+			// AIFC 的 COMM 之后还有压缩名称，奇数长度的块带填充字节
+			"odd chunks",
+			form("AIFC",
+				chunk("NAME", -1, []byte("abc")),
+				chunk("COMM", -1, append(comm(2, 2, 16, 44100, "sowt"), 4, 's', 'o', 'w', 't')),
+				chunk("SSND", -1, ssnd(stereo16(true)))),
+		},
+		{"ssnd first", form("AIFF", chunk("SSND", -1, ssnd(stereo16(false))), chunk("COMM", -1, comm(2, 2, 16, 44100, "")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, format, err := decodeAIFF(bytes.NewReader(tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format.SampleRate != 44100 || format.NumChannels != 2 || format.Precision != 2 {
+				t.Fatalf("format = %+v, want 44100 Hz, 2 channels, 2 bytes", format)
+			}
+			if s.Len() != len(want) {
+				t.Fatalf("Len = %d, want %d", s.Len(), len(want))
+			}
+			samples := make([][2]float64, 4)
+			n, ok := s.Stream(samples)
+			if !ok || n != len(want) {
+				t.Fatalf("Stream = %d, %v, want %d frames", n, ok, len(want))
+			}
+			for i := range want {
+				if samples[i] != want[i] {
+					t.Errorf("frame %d = %v, want %v", i, samples[i], want[i])
+				}
+			}
+			if n, ok := s.Stream(samples); n != 0 || ok {
+				t.Errorf("Stream after the end = %d, %v", n, ok)
+			}
+		})
+	}
+}
+
+func TestAIFFDecoderTruncatedData(t *testing.T) {
+	// COMM 声明的帧数多于 SSND 中实际的数据
+	file := form("AIFF", chunk("COMM", -1, comm(2, 100, 16, 44100, "")), chunk("SSND", -1, ssnd(stereo16(false))))
+	s, _, err := decodeAIFF(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make([][2]float64, 100)
+	if n, _ := s.Stream(samples); n != 2 {
+		t.Fatalf("Stream = %d frames, want the 2 in the file", n)
+	}
+	if s.Len() != 2 {
+		t.Errorf("Len = %d after reaching the end of the data, want 2", s.Len())
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err = %v, want nil for a short file", err)
+	}
+}
+
+func TestAIFFDecoderRejectsBadFiles(t *testing.T) {
+	good := chunk("SSND", -1, ssnd(stereo16(false)))
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"empty", nil},
+		{"truncated form header", []byte("FORM\x00\x00")},
+		{"not a form", append([]byte("RIFF\x00\x00\x00\x04"), "WAVE"...)},
+		{"unknown form type", form("8SVX", good)},
+		{"no chunks", form("AIFF")},
+		{"missing ssnd", form("AIFF", chunk("COMM", -1, comm(2, 2, 16, 44100, "")))},
+		{"missing comm", form("AIFF", good)},
+		{"short comm", form("AIFF", chunk("COMM", -1, comm(2, 2, 16, 44100, "")[:10]), good)},
+		{"short aifc comm", form("AIFC", chunk("COMM", -1, comm(2, 2, 16, 44100, "")), good)},
+		// 声明的长度远超文件大小：只读需要的字段，然后在文件末尾找不到 SSND
+		{"oversized comm", form("AIFF", chunk("COMM", 0xfffffff0, comm(2, 2, 16, 44100, "")))},
+		{"oversized aifc comm", form("AIFC", chunk("COMM", 0xfffffff0, comm(2, 2, 16, 44100, "NONE")), good)},
+		{"truncated comm", form("AIFF", chunk("COMM", 18, comm(2, 2, 16, 44100, "")[:12]))},
+		{"short ssnd", form("AIFF", chunk("COMM", -1, comm(2, 2, 16, 44100, "")), chunk("SSND", -1, make([]byte, 4)))},
+		{"truncated ssnd", form("AIFF", chunk("COMM", -1, comm(2, 2, 16, 44100, "")), chunk("SSND", 8, make([]byte, 4)))},
+		{"unsupported compression", form("AIFC", chunk("COMM", -1, comm(2, 2, 16, 44100, "fl32")), good)},
+		{"no channels", form("AIFF", chunk("COMM", -1, comm(0, 2, 16, 44100, "")), good)},
+		{"no sample size", form("AIFF", chunk("COMM", -1, comm(2, 2, 0, 44100, "")), good)},
+		{"oversized samples", form("AIFF", chunk("COMM", -1, comm(2, 2, 64, 44100, "")), good)},
+		{"no sample rate", form("AIFF", chunk("COMM", -1, comm(2, 2, 16, 0, "")), good)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeAIFF(bytes.NewReader(tt.file)); err == nil {
+				t.Error("decoded without an error")
+			}
+		})
+	}
+}
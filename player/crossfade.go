@@ -0,0 +1,104 @@
+package player
+
+import (
+	"math"
+	"time"
+)
+
+// SetCrossfade 设置交叉淡入淡出时长：当前音轨最后 d 时间内下一首淡入、当前音轨淡出。
+// d <= 0 时关闭，换轨退化为无缝衔接。可在播放过程中随时调整。
+func (p *Player) SetCrossfade(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.out.Lock()
+	p.seq.fade = d
+	p.out.Unlock()
+}
+
+// Crossfade 返回当前的交叉淡入淡出时长
+func (p *Player) Crossfade() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.out.Lock()
+	defer p.out.Unlock()
+	return p.seq.fade
+}
+
+// fadeStartIn 返回距离淡入淡出开始还有多少个输出采样；不需要淡入淡出时 ok 为 false
+func (s *sequencer) fadeStartIn() (n int, ok bool) {
+	if s.fade <= 0 || s.next == nil || !s.next.fadeIn || s.cur.Len() <= 0 {
+		return 0, false
+	}
+	return s.remaining() - s.rate.N(s.fade), true
+}
+
+// remaining 返回当前音轨剩余的输出采样数
+func (s *sequencer) remaining() int {
+	left := s.cur.Len() - s.cur.Position()
+	return s.rate.N(s.cur.format.SampleRate.D(left))
+}
+
+func (s *sequencer) startFade() {
+	total := s.rate.N(s.fade)
+	if left := s.remaining(); left < total {
+		total = left
+	}
+	if total < 1 {
+		total = 1
+	}
+	s.fading = true
+	s.fadePos = 0
+	s.fadeTotal = total
+}
+
+// cancelFade 在定位/停止时中止淡入淡出，并把已开始播放的下一首倒回开头
+func (s *sequencer) cancelFade() {
+	if !s.fading {
+		return
+	}
+	s.fading = false
+	if s.next != nil {
+		_ = s.next.Seek(0)
+	}
+}
+
+// streamFade 把当前音轨与下一首按等功率曲线混合，curDone 表示当前音轨已播放完
+func (s *sequencer) streamFade(samples [][2]float64) (n int, curDone bool) {
+	if len(s.fadeBuf) < len(samples) {
+		s.fadeBuf = make([][2]float64, len(samples))
+	}
+	in := s.fadeBuf[:len(samples)]
+
+	cn, cok := s.cur.out.Stream(samples)
+	nn, _ := s.next.out.Stream(in)
+
+	n = cn
+	if nn > n {
+		n = nn
+	}
+	for i := 0; i < n; i++ {
+		if i >= cn {
+			// 当前音轨提前结束，剩余部分只有下一首
+			samples[i] = in[i]
+			continue
+		}
+		if i >= nn {
+			in[i] = [2]float64{}
+		}
+		x := float64(s.fadePos+i) / float64(s.fadeTotal)
+		if x > 1 {
+			x = 1
+		}
+		out := math.Cos(x * math.Pi / 2)
+		gain := math.Sin(x * math.Pi / 2)
+		for c := range samples[i] {
+			samples[i][c] = samples[i][c]*out + in[i][c]*gain
+		}
+	}
+	s.fadePos += n
+
+	return n, !cok || cn < len(samples)
+}
@@ -0,0 +1,142 @@
+package player
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+// constStream 是 n 帧只在一个声道上为 1 的合成音轨
+type constStream struct {
+	n, pos  int
+	channel int
+}
+
+func (s *constStream) Stream(samples [][2]float64) (int, bool) {
+	n := min(len(samples), s.n-s.pos)
+	for i := range n {
+		samples[i] = [2]float64{}
+		samples[i][s.channel] = 1
+	}
+	s.pos += n
+	return n, n > 0
+}
+
+func (s *constStream) Err() error    { return nil }
+func (s *constStream) Len() int      { return s.n }
+func (s *constStream) Position() int { return s.pos }
+func (s *constStream) Close() error  { return nil }
+
+func (s *constStream) Seek(p int) error {
+	s.pos = p
+	return nil
+}
+
+func TestCrossfadeMix(t *testing.T) {
+	// 每毫秒一帧，便于换算
+	const rate = beep.SampleRate(1000)
+	tests := []struct {
+		name        string
+		cur, next   int // 帧数
+		fade        time.Duration
+		fadeIn      bool
+		wantOverlap int
+	}{
+		{"crossfade", 500, 400, 100 * time.Millisecond, true, 100},
+		{"fade longer than the current track", 50, 400, 100 * time.Millisecond, true, 50},
+		{"next shorter than the fade", 500, 40, 100 * time.Millisecond, true, 100},
+		{"joined album track", 500, 400, 100 * time.Millisecond, false, 0},
+		{"crossfade off", 500, 400, 0, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 当前音轨在左声道，下一首在右声道，两条增益曲线分开可见
+			cur := newTrackStream("cur", &constStream{n: tt.cur, channel: 0}, beep.Format{SampleRate: rate, NumChannels: 2}, rate, 4)
+			next := newTrackStream("next", &constStream{n: tt.next, channel: 1}, beep.Format{SampleRate: rate, NumChannels: 2}, rate, 4)
+			next.fadeIn = tt.fadeIn
+			var ends []string
+			s := &sequencer{cur: cur, next: next, rate: rate, fade: tt.fade}
+			s.onEnd = func(prev, next *trackStream) {
+				ends = append(ends, prev.path)
+			}
+
+			var out [][2]float64
+			buf := make([][2]float64, 64)
+			for {
+				n, ok := s.Stream(buf)
+				out = append(out, buf[:n]...)
+				if !ok {
+					break
+				}
+			}
+
+			// 重叠部分按等功率曲线混合：当前音轨 cos 淡出，下一首 sin 淡入
+			start := tt.cur - tt.wantOverlap
+			want := func(i int) [2]float64 {
+				switch {
+				case i < start:
+					return [2]float64{1, 0}
+				case i < tt.cur:
+					x := float64(i-start) / float64(tt.wantOverlap) * math.Pi / 2
+					if i-start >= tt.next {
+						return [2]float64{math.Cos(x), 0} // 下一首已经播完
+					}
+					return [2]float64{math.Cos(x), math.Sin(x)}
+				default:
+					return [2]float64{0, 1}
+				}
+			}
+			wantLen := tt.cur + max(0, tt.next-tt.wantOverlap)
+			if len(out) != wantLen {
+				t.Fatalf("mixed %d frames, want %d", len(out), wantLen)
+			}
+			for i, got := range out {
+				w := want(i)
+				if math.Abs(got[0]-w[0]) > 1e-9 || math.Abs(got[1]-w[1]) > 1e-9 {
+					t.Fatalf("frame %d = %v, want %v", i, got, w)
+				}
+				if i >= start && i < tt.cur && i-start < tt.next {
+					if power := got[0]*got[0] + got[1]*got[1]; math.Abs(power-1) > 1e-9 {
+						t.Fatalf("frame %d has power %v, want 1", i, power)
+					}
+				}
+			}
+
+			if len(ends) != 2 || ends[0] != "cur" || ends[1] != "next" {
+				t.Errorf("tracks ended in order %q, want cur then next", ends)
+			}
+		})
+	}
+}
+
+func TestCrossfadeAdvance(t *testing.T) {
+	dir := t.TempDir()
+	first := writeTone(t, dir, "first.wav", DefaultSampleRate, 300*time.Millisecond)
+	second := writeTone(t, dir, "second.wav", DefaultSampleRate, 300*time.Millisecond)
+
+	p := newNullPlayer(t)
+	p.SetCrossfade(200 * time.Millisecond)
+	if err := p.Load(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-p.SetNext(second); err != nil {
+		t.Fatal(err)
+	}
+	ended := p.OnEnded()
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一首结束时第二首已经在淡入中播放了约 200ms
+	waitClosed(t, "the first track to end", ended)
+	pos := p.Position()
+	if got := p.Current(); got != second {
+		t.Fatalf("after the first track Current = %q, want %q", got, second)
+	}
+	if pos < 150*time.Millisecond {
+		t.Errorf("the second track starts at %v, want it to have faded in for about 200ms", pos)
+	}
+	waitFor(t, "the player to end", func() bool { return p.State() == StateEnded })
+}
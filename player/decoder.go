@@ -2,9 +2,12 @@ package player
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/faiface/beep"
 	"github.com/faiface/beep/flac"
@@ -12,59 +15,222 @@ import (
 	"github.com/faiface/beep/wav"
 )
 
-type Decoder func(path string) (beep.StreamSeekCloser, beep.Format, error)
+// Decoder 从 r 解码音频。返回的流不负责关闭 r，出错时也不应关闭 r，
+// 以便 OpenReader 回退到其他解码器重试。
+type Decoder func(r io.ReadSeeker) (beep.StreamSeekCloser, beep.Format, error)
 
-var registry = map[string]Decoder{
-	".mp3":  decodeMP3,
-	".wav":  decodeWAV,
-	".flac": decodeFLAC,
+// decoderFormat 是一个已注册的音频格式
+type decoderFormat struct {
+	name       string
+	extensions []string
+	magic      []Magic
+	decode     Decoder
 }
 
-func Open(path string) (beep.StreamSeekCloser, beep.Format, error) {
-	ext := strings.ToLower(filepath.Ext(path))
-	dec, ok := registry[ext]
-	if !ok {
-		return nil, beep.Format{}, fmt.Errorf("unsupported audio format: %s", ext)
+// Magic 是文件开头的特征字节。Mask 中为 0 的比特不参与比较，
+// 比如用 0x00 跳过 RIFF 头中的长度字段；Mask 为空或比 Bytes 短时，缺少的部分逐字节精确比较。
+type Magic struct {
+	Bytes string
+	Mask  string
+}
+
+// iffMagic 匹配 RIFF、FORM 等 IFF 文件头，跳过其中 4 字节的长度
+func iffMagic(id, kind string) Magic {
+	return Magic{
+		Bytes: id + "\x00\x00\x00\x00" + kind,
+		Mask:  "\xff\xff\xff\xff\x00\x00\x00\x00\xff\xff\xff\xff",
 	}
-	return dec(path)
 }
 
-func decodeMP3(path string) (beep.StreamSeekCloser, beep.Format, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, beep.Format{}, err
+// sniffLen 是嗅探文件头时读取的最大字节数
+const sniffLen = 64
+
+var (
+	registryMu sync.RWMutex
+	registry   []*decoderFormat
+)
+
+func init() {
+	RegisterDecoder("mp3", []string{".mp3"}, []Magic{
+		{Bytes: "ID3"},
+		{Bytes: "\xff\xfb"}, {Bytes: "\xff\xfa"}, {Bytes: "\xff\xf3"},
+		{Bytes: "\xff\xf2"}, {Bytes: "\xff\xe3"}, {Bytes: "\xff\xe2"},
+	}, decodeMP3)
+	RegisterDecoder("wav", []string{".wav"}, []Magic{iffMagic("RIFF", "WAVE")}, decodeWAV)
+	RegisterDecoder("flac", []string{".flac"}, []Magic{{Bytes: "fLaC"}}, decodeFLAC)
+	RegisterDecoder("vorbis", []string{".ogg", ".oga"}, []Magic{{Bytes: "OggS"}}, decodeVorbis)
+	RegisterDecoder("aiff", []string{".aiff", ".aif", ".aifc"}, []Magic{iffMagic("FORM", "AIFF"), iffMagic("FORM", "AIFC")}, decodeAIFF)
+}
+
+// RegisterDecoder 注册一种音频格式。extensions 为带点的小写扩展名，用于无法识别文件头时兜底；
+// magic 为文件开头的特征字节，任一匹配即选用该格式。同名格式会被替换。
+func RegisterDecoder(name string, extensions []string, magic []Magic, dec Decoder) {
+	f := &decoderFormat{
+		name:   name,
+		magic:  magic,
+		decode: dec,
 	}
-	s, format, err := mp3.Decode(f)
-	if err != nil {
-		_ = f.Close()
-		return nil, beep.Format{}, err
+	for _, ext := range extensions {
+		f.extensions = append(f.extensions, strings.ToLower(ext))
 	}
-	// mp3.Decode 已经把文件句柄包进 streamer 里，关闭 streamer 会关文件
-	return s, format, nil
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for i, old := range registry {
+		if old.name == name {
+			registry[i] = f
+			return
+		}
+	}
+	registry = append(registry, f)
+}
+
+// Extensions 返回所有已注册格式的扩展名（已排序）
+func Extensions() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var exts []string
+	for _, f := range registry {
+		exts = append(exts, f.extensions...)
+	}
+	sort.Strings(exts)
+	return exts
 }
 
-func decodeWAV(path string) (beep.StreamSeekCloser, beep.Format, error) {
+// Supported 报告 path 的扩展名是否有已注册的解码器
+func Supported(path string) bool {
+	return byExtension(path) != nil
+}
+
+// Open 打开文件并解码，是 OpenReader 的简单封装
+func Open(path string) (beep.StreamSeekCloser, beep.Format, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, beep.Format{}, err
 	}
-	s, format, err := wav.Decode(f)
+	return OpenReader(path, f)
+}
+
+// OpenReader 先按文件头嗅探格式，识别失败或解码失败时再按 name 的扩展名选择解码器。
+// 它接管 r：返回的流关闭时会关闭 r，出错时 r 会被立即关闭。
+func OpenReader(name string, r io.ReadSeekCloser) (beep.StreamSeekCloser, beep.Format, error) {
+	s, format, err := openReader(name, r)
 	if err != nil {
-		_ = f.Close()
+		_ = r.Close()
 		return nil, beep.Format{}, err
 	}
-	return s, format, nil
+	return &ownedStream{StreamSeekCloser: s, closer: r}, format, nil
 }
 
-func decodeFLAC(path string) (beep.StreamSeekCloser, beep.Format, error) {
-	f, err := os.Open(path)
+func openReader(name string, r io.ReadSeeker) (beep.StreamSeekCloser, beep.Format, error) {
+	byExt := byExtension(name)
+
+	sniffed, err := sniff(r)
 	if err != nil {
 		return nil, beep.Format{}, err
 	}
-	s, format, err := flac.Decode(f)
-	if err != nil {
-		_ = f.Close()
-		return nil, beep.Format{}, err
+	if sniffed != nil {
+		s, format, err := sniffed.decode(r)
+		if err == nil || byExt == nil || byExt == sniffed {
+			return s, format, err
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, beep.Format{}, err
+		}
+	}
+
+	if byExt == nil {
+		return nil, beep.Format{}, fmt.Errorf("unsupported audio format: %s", strings.ToLower(filepath.Ext(name)))
+	}
+	return byExt.decode(r)
+}
+
+// sniff 读取文件头并返回匹配的格式（没有则为 nil），读取后把 r 倒回开头
+func sniff(r io.ReadSeeker) (*decoderFormat, error) {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
 	}
-	return s, format, nil
+	header = header[:n]
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, format := range registry {
+		for _, m := range format.magic {
+			if matchMagic(m, header) {
+				return format, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// ownedStream 关闭解码流时一并关闭其底层 reader
+type ownedStream struct {
+	beep.StreamSeekCloser
+	closer io.Closer
+}
+
+func (s *ownedStream) Close() error {
+	err := s.StreamSeekCloser.Close()
+	if cerr := s.closer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// nopCloser 给 ReadSeeker 加上空的 Close，供要求 ReadCloser 的 beep 解码器使用，
+// 使它们出错时不会关闭调用方的 reader
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// matchMagic 判断 header 是否以 magic 开头，只比较 Mask 中置位的比特
+func matchMagic(magic Magic, header []byte) bool {
+	if len(magic.Bytes) == 0 || len(header) < len(magic.Bytes) {
+		return false
+	}
+	for i := 0; i < len(magic.Bytes); i++ {
+		mask := byte(0xff)
+		if i < len(magic.Mask) {
+			mask = magic.Mask[i]
+		}
+		if header[i]&mask != magic.Bytes[i]&mask {
+			return false
+		}
+	}
+	return true
+}
+
+// byExtension 按扩展名查找格式
+func byExtension(path string) *decoderFormat {
+	ext := strings.ToLower(filepath.Ext(path))
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, f := range registry {
+		for _, e := range f.extensions {
+			if e == ext {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+func decodeMP3(r io.ReadSeeker) (beep.StreamSeekCloser, beep.Format, error) {
+	return mp3.Decode(nopCloser{r})
+}
+
+func decodeWAV(r io.ReadSeeker) (beep.StreamSeekCloser, beep.Format, error) {
+	return wav.Decode(nopCloser{r})
+}
+
+func decodeFLAC(r io.ReadSeeker) (beep.StreamSeekCloser, beep.Format, error) {
+	return flac.Decode(nopCloser{r})
 }
@@ -0,0 +1,258 @@
+package player
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+// memFile 是内存中的 ReadSeekCloser，记录是否被关闭
+type memFile struct {
+	*bytes.Reader
+	closed bool
+}
+
+func newMemFile(data []byte) *memFile {
+	return &memFile{Reader: bytes.NewReader(data)}
+}
+
+func (f *memFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+// mp3Frames 生成 n 个静音的 MPEG-1 Layer III 帧（128 kbps，44100 Hz，立体声，无 ID3 头）
+func mp3Frames(n int) []byte {
+	const frameLen = 144 * 128000 / 44100
+	var b []byte
+	for range n {
+		frame := make([]byte, frameLen)
+		copy(frame, "\xff\xfb\x90\x00")
+		b = append(b, frame...)
+	}
+	return b
+}
+
+// flacStream 生成只有 STREAMINFO 的 FLAC 流（44100 Hz，立体声，16 位，没有音频帧）
+func flacStream() []byte {
+	info := make([]byte, 34)
+	info[0], info[2] = 0x10, 0x10 // 最小、最大块长 4096
+	// 采样率 20 位，声道数减一 3 位，位深减一 5 位，总帧数 36 位
+	v := uint64(44100)<<44 | 1<<41 | 15<<36
+	for i := range 8 {
+		info[10+i] = byte(v >> (56 - 8*i))
+	}
+	return append([]byte("fLaC\x80\x00\x00\x22"), info...)
+}
+
+// aiffTone 生成两帧 16 位立体声 AIFF
+func aiffTone() []byte {
+	return form("AIFF", chunk("COMM", -1, comm(2, 2, 16, 44100, "")), chunk("SSND", -1, ssnd(stereo16(false))))
+}
+
+// registerTestDecoder 注册一个测试用格式，测试结束时恢复原来的注册表
+func registerTestDecoder(t *testing.T, name string, extensions []string, magic []Magic, dec Decoder) {
+	t.Helper()
+	registryMu.RLock()
+	saved := append([]*decoderFormat(nil), registry...)
+	registryMu.RUnlock()
+	t.Cleanup(func() {
+		registryMu.Lock()
+		registry = saved
+		registryMu.Unlock()
+	})
+	RegisterDecoder(name, extensions, magic, dec)
+}
+
+func TestMatchMagic(t *testing.T) {
+	tests := []struct {
+		name   string
+		magic  Magic
+		header string
+		want   bool
+	}{
+		{"exact", Magic{Bytes: "fLaC"}, "fLaC\x00", true},
+		{"exact mismatch", Magic{Bytes: "fLaC"}, "fLaX\x00", false},
+		{"short header", Magic{Bytes: "fLaC"}, "fLa", false},
+		{"empty magic", Magic{}, "fLaC", false},
+		{"masked length", iffMagic("RIFF", "WAVE"), "RIFF\x12\x34\x56\x78WAVEfmt ", true},
+		{"masked length wrong kind", iffMagic("RIFF", "WAVE"), "RIFF\x12\x34\x56\x78AVI ", false},
+		{"partial bit mask", Magic{Bytes: "\xff\xe0", Mask: "\xff\xe0"}, "\xff\xfb", true},
+		{"partial bit mask mismatch", Magic{Bytes: "\xff\xe0", Mask: "\xff\xe0"}, "\xff\x1b", false},
+		{"short mask compares the rest exactly", Magic{Bytes: "\x00?", Mask: "\x00"}, "Z?", true},
+		// '?' 是普通字节，不是通配符
+		{"literal question mark", Magic{Bytes: "W?V"}, "W?V", true},
+		{"question mark is not a wildcard", Magic{Bytes: "W?V"}, "WAV", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchMagic(tt.magic, []byte(tt.header)); got != tt.want {
+				t.Errorf("matchMagic(%q, %q) = %v, want %v", tt.magic, tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSniffIgnoresTheExtension(t *testing.T) {
+	wav := append(wavHeader(44100, 8), make([]byte, 8)...)
+	tests := []struct {
+		name   string
+		file   []byte
+		format string
+	}{
+		{"mp3.wav", mp3Frames(10), "mp3"},
+		{"tagged mp3.flac", append([]byte("ID3\x03\x00\x00\x00\x00\x00\x00"), mp3Frames(10)...), "mp3"},
+		{"flac.mp3", flacStream(), "flac"},
+		{"wav.mp3", wav, "wav"},
+		{"aiff.wav", aiffTone(), "aiff"},
+		{"wav without extension", wav, "wav"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newMemFile(tt.file)
+			sniffed, err := sniff(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sniffed == nil || sniffed.name != tt.format {
+				t.Fatalf("sniffed %+v, want %s", sniffed, tt.format)
+			}
+			if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+				t.Errorf("sniff left the reader at %d", pos)
+			}
+
+			s, format, err := OpenReader(tt.name, f)
+			if err != nil {
+				t.Fatalf("OpenReader: %v", err)
+			}
+			defer s.Close()
+			if format.SampleRate != 44100 || format.NumChannels != 2 {
+				t.Errorf("format = %+v, want 44100 Hz stereo", format)
+			}
+		})
+	}
+}
+
+func TestOpenReaderFromMemory(t *testing.T) {
+	data := toneWAV(DefaultSampleRate, 50*time.Millisecond)
+	f := newMemFile(data)
+	s, format, err := OpenReader("memory.wav", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.SampleRate != DefaultSampleRate || format.NumChannels != 2 {
+		t.Errorf("format = %+v, want %d Hz stereo", format, DefaultSampleRate)
+	}
+	want := DefaultSampleRate.N(50 * time.Millisecond)
+	if s.Len() != want {
+		t.Errorf("Len = %d, want %d", s.Len(), want)
+	}
+	samples := make([][2]float64, want+1)
+	if n, _ := s.Stream(samples); n != want {
+		t.Errorf("Stream = %d frames, want %d", n, want)
+	}
+	if !slices.ContainsFunc(samples[:want], func(frame [2]float64) bool { return frame[0] != 0 }) {
+		t.Error("decoded only silence from a tone")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !f.closed {
+		t.Error("closing the stream leaves the reader open")
+	}
+}
+
+func TestExtensionFallback(t *testing.T) {
+	// 开头的垃圾数据让嗅探失败，MP3 解码器会跳过它找到帧同步
+	junk := append(make([]byte, 16), mp3Frames(10)...)
+
+	f := newMemFile(junk)
+	if sniffed, err := sniff(f); err != nil || sniffed != nil {
+		t.Fatalf("sniff = %+v, %v, want no match", sniffed, err)
+	}
+	s, _, err := OpenReader("junk.mp3", f)
+	if err != nil {
+		t.Fatalf("OpenReader by extension: %v", err)
+	}
+	s.Close()
+
+	f = newMemFile(junk)
+	if _, _, err := OpenReader("junk.xyz", f); err == nil || !strings.Contains(err.Error(), "unsupported audio format: .xyz") {
+		t.Errorf("OpenReader with an unknown extension = %v, want an unsupported format error", err)
+	}
+	if !f.closed {
+		t.Error("the reader is left open after an error")
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	var calls []string
+	probe := func(name string, fail bool) Decoder {
+		return func(r io.ReadSeeker) (beep.StreamSeekCloser, beep.Format, error) {
+			calls = append(calls, name)
+			if fail {
+				return nil, beep.Format{}, errors.New(name + " failed")
+			}
+			return wavStream(r)
+		}
+	}
+	wav := append(wavHeader(44100, 8), make([]byte, 8)...)
+
+	// 特征字节中的 '?' 按原样匹配
+	registerTestDecoder(t, "probe", []string{".PRB"}, []Magic{{Bytes: "PR?B"}}, probe("probe", false))
+	for _, tt := range []struct {
+		name string
+		file []byte
+		want []string
+	}{
+		{"magic.wav", append([]byte("PR?B"), wav...), []string{"probe"}},
+		{"no magic.prb", wav, nil}, // RIFF 头由 wav 解码器处理
+		{"near miss.prb", append([]byte("PRxB"), wav...), []string{"probe"}},
+	} {
+		calls = nil
+		s, _, err := OpenReader(tt.name, newMemFile(tt.file))
+		if err != nil && tt.want != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if s != nil {
+			s.Close()
+		}
+		if !slices.Equal(calls, tt.want) {
+			t.Errorf("%s: decoders called %q, want %q", tt.name, calls, tt.want)
+		}
+	}
+
+	// 嗅探到的解码器失败时按扩展名重试；同名注册替换原有格式
+	registerTestDecoder(t, "probe", []string{".prb"}, []Magic{{Bytes: "PR?B"}}, probe("probe", true))
+	registerTestDecoder(t, "fallback", []string{".fbk"}, nil, probe("fallback", false))
+	calls = nil
+	s, _, err := OpenReader("magic.fbk", newMemFile(append([]byte("PR?B"), wav...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if want := []string{"probe", "fallback"}; !slices.Equal(calls, want) {
+		t.Errorf("decoders called %q, want %q", calls, want)
+	}
+	if exts := Extensions(); strings.Count(strings.Join(exts, " "), ".prb") != 1 {
+		t.Errorf("Extensions = %q, want .prb once", exts)
+	}
+}
+
+// wavStream 跳过 4 字节的测试特征后按 WAV 解码；没有特征时直接解码
+func wavStream(r io.ReadSeeker) (beep.StreamSeekCloser, beep.Format, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, beep.Format{}, err
+	}
+	if i := bytes.Index(data, []byte("RIFF")); i > 0 {
+		data = data[i:]
+	}
+	return decodeWAV(bytes.NewReader(data))
+}
@@ -0,0 +1,152 @@
+package player
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType 表示播放器事件的种类
+type EventType int
+
+const (
+	EventLoaded        EventType = iota // 音轨已加载（尚未播放）
+	EventPlaying                        // 开始或恢复播放，无缝衔接到下一首时也会发出
+	EventPaused                         // 已暂停
+	EventStopped                        // 已停止并回到开头
+	EventSeeked                         // 已定位，Position 为新位置
+	EventVolumeChanged                  // 音量已改变，Volume 为新的线性音量
+	EventEnded                          // 音轨播放完毕，Path 为结束的音轨
+	EventError                          // 加载、预加载或解码出错
+	EventPosition                       // 播放中按订阅时指定的间隔周期性发出
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventLoaded:
+		return "loaded"
+	case EventPlaying:
+		return "playing"
+	case EventPaused:
+		return "paused"
+	case EventStopped:
+		return "stopped"
+	case EventSeeked:
+		return "seeked"
+	case EventVolumeChanged:
+		return "volume"
+	case EventEnded:
+		return "ended"
+	case EventError:
+		return "error"
+	case EventPosition:
+		return "position"
+	}
+	return "unknown"
+}
+
+// Event 是一次播放器状态变化。未用到的字段为零值。
+type Event struct {
+	Type     EventType
+	Path     string        // 相关音轨
+	Position time.Duration // EventSeeked、EventPosition
+	Duration time.Duration // EventLoaded、EventPlaying、EventPosition，解码器无法得知时为 0
+	Volume   float64       // EventVolumeChanged
+	Err      error         // EventError，EventEnded 时为解码错误（若有）
+}
+
+// subscriberBuffer 是每个订阅者最多积压的事件数，超出后新事件被丢弃
+const subscriberBuffer = 64
+
+type subscriber struct {
+	ch   chan Event
+	done chan struct{}
+}
+
+// events 管理订阅者。它使用独立的锁且从不阻塞，因此可以在持有 p.mu
+// 或输出锁时（包括音频线程中）发出事件。
+type events struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+// Subscribe 订阅播放器事件，返回事件通道与取消函数；取消后通道被关闭。
+// interval > 0 时，播放期间每隔 interval 额外发出一个 EventPosition。
+// 事件发送从不等待接收方：处理不及时的订阅者会丢失事件，而不会拖慢播放。
+func (p *Player) Subscribe(interval time.Duration) (<-chan Event, func()) {
+	s := &subscriber{
+		ch:   make(chan Event, subscriberBuffer),
+		done: make(chan struct{}),
+	}
+	p.events.mu.Lock()
+	p.events.subs[s] = struct{}{}
+	p.events.mu.Unlock()
+
+	if interval > 0 {
+		go p.tickPosition(s, interval)
+	}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			p.events.mu.Lock()
+			defer p.events.mu.Unlock()
+			delete(p.events.subs, s)
+			close(s.done)
+			close(s.ch)
+		})
+	}
+	return s.ch, cancel
+}
+
+// tickPosition 在播放期间周期性地向 s 发送当前位置，直到订阅被取消
+func (p *Player) tickPosition(s *subscriber, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		if p.State() != StatePlaying {
+			continue
+		}
+		e := Event{Type: EventPosition, Path: p.Current(), Position: p.Position(), Duration: p.Duration()}
+
+		p.events.mu.Lock()
+		if _, ok := p.events.subs[s]; ok {
+			send(s.ch, e)
+		}
+		p.events.mu.Unlock()
+	}
+}
+
+// emit 把事件发给所有订阅者，不会阻塞
+func (p *Player) emit(e Event) {
+	p.events.mu.Lock()
+	defer p.events.mu.Unlock()
+	for s := range p.events.subs {
+		send(s.ch, e)
+	}
+}
+
+func send(ch chan Event, e Event) {
+	select {
+	case ch <- e:
+	default:
+	}
+}
+
+// trackEnded 由 sequencer 在音频线程中调用（持有输出锁）：prev 播放完毕，
+// next 为无缝衔接上的下一首，没有则为 nil
+func (p *Player) trackEnded(prev, next *trackStream) {
+	err := prev.Err()
+	p.emit(Event{Type: EventEnded, Path: prev.path, Err: err})
+	if err != nil {
+		p.emit(Event{Type: EventError, Path: prev.path, Err: err})
+	}
+	if next != nil {
+		p.emit(Event{Type: EventPlaying, Path: next.path, Duration: next.duration()})
+	}
+}
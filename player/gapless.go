@@ -0,0 +1,281 @@
+package player
+
+import (
+	"errors"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+// preloadDuration 是预加载下一首时在后台提前解码的时长
+const preloadDuration = 2 * time.Second
+
+// trackStream 是一条已打开的音轨。head 中保存后台预解码的开头采样，
+// 播放时先消费 head 再继续从解码器读取，以免衔接时卡顿。
+type trackStream struct {
+	path     string
+	stream   beep.StreamSeekCloser
+	format   beep.Format
+	out      beep.Streamer // 按输出采样率重采样后的流
+	rate     beep.SampleRate
+	quality  int
+	head     [][2]float64
+	ended    chan struct{}
+	finished bool
+	fadeIn   bool // 允许与上一首交叉淡入淡出
+}
+
+// newTrackStream 包装解码后的流，采样率与输出不同时经过重采样器。
+// 无缝衔接与交叉淡入淡出都只处理重采样后的 out，因此不同采样率的音轨可以直接拼接。
+func newTrackStream(path string, s beep.StreamSeekCloser, format beep.Format, rate beep.SampleRate, quality int) *trackStream {
+	t := &trackStream{
+		path:    path,
+		stream:  s,
+		format:  format,
+		rate:    rate,
+		quality: quality,
+		ended:   make(chan struct{}),
+	}
+	t.resetOut()
+	return t
+}
+
+// resetOut 重建重采样器，丢弃其内部缓冲的旧采样
+func (t *trackStream) resetOut() {
+	t.out = t
+	if t.rate != t.format.SampleRate {
+		t.out = beep.Resample(t.quality, t.format.SampleRate, t.rate, t)
+	}
+}
+
+// prefetch 预先解码最多 n 个采样
+func (t *trackStream) prefetch(n int) {
+	buf := make([][2]float64, n)
+	read, _ := t.stream.Stream(buf)
+	t.head = buf[:read]
+}
+
+func (t *trackStream) Stream(samples [][2]float64) (n int, ok bool) {
+	if len(t.head) > 0 {
+		n = copy(samples, t.head)
+		t.head = t.head[n:]
+		if n == len(samples) {
+			return n, true
+		}
+	}
+	sn, sok := t.stream.Stream(samples[n:])
+	n += sn
+	return n, sok || n > 0
+}
+
+func (t *trackStream) Err() error {
+	return t.stream.Err()
+}
+
+// Position 返回以音轨自身采样率计的位置，扣除尚未播放的预解码部分
+func (t *trackStream) Position() int {
+	return t.stream.Position() - len(t.head)
+}
+
+func (t *trackStream) Len() int {
+	return t.stream.Len()
+}
+
+// duration 返回音轨总时长，解码器无法得知时为 0
+func (t *trackStream) duration() time.Duration {
+	if t.Len() <= 0 {
+		return 0
+	}
+	return t.format.SampleRate.D(t.Len())
+}
+
+// Seek 定位并丢弃预解码内容；已结束的音轨会重新获得一个 ended 通道
+func (t *trackStream) Seek(p int) error {
+	if err := t.stream.Seek(p); err != nil {
+		return err
+	}
+	t.head = nil
+	t.resetOut()
+	if t.finished {
+		t.finished = false
+		t.ended = make(chan struct{})
+	}
+	return nil
+}
+
+func (t *trackStream) Close() error {
+	return t.stream.Close()
+}
+
+// sequencer 依次播放当前音轨与预加载的下一首，在采样边界上无缝衔接，
+// 或在设置了交叉淡入淡出时于当前音轨末尾与下一首混合。
+// 它运行在音频线程中，所有字段都由输出锁保护。
+type sequencer struct {
+	cur  *trackStream
+	next *trackStream
+	rate beep.SampleRate // 输出采样率
+
+	fade      time.Duration // 交叉淡入淡出时长，0 表示关闭
+	fading    bool
+	fadePos   int
+	fadeTotal int
+	fadeBuf   [][2]float64
+
+	onEnd func(prev, next *trackStream) // 每首音轨结束时调用，next 为衔接上的下一首
+}
+
+func (s *sequencer) Stream(samples [][2]float64) (n int, ok bool) {
+	for n < len(samples) && s.cur != nil && !s.cur.finished {
+		rest := samples[n:]
+
+		if s.fading && s.next == nil {
+			// 下一首在淡入淡出过程中被取消
+			s.fading = false
+		}
+		if s.fading {
+			sn, curDone := s.streamFade(rest)
+			n += sn
+			if curDone {
+				s.advance()
+			}
+			continue
+		}
+
+		// 到达淡入淡出起点前只播放当前音轨
+		limit := len(rest)
+		if until, ok := s.fadeStartIn(); ok {
+			if until <= 0 {
+				s.startFade()
+				continue
+			}
+			if until < limit {
+				limit = until
+			}
+		}
+
+		sn, sok := s.cur.out.Stream(rest[:limit])
+		n += sn
+		if sok && sn == limit {
+			continue
+		}
+		s.advance()
+	}
+	return n, n > 0
+}
+
+func (s *sequencer) Err() error {
+	if s.cur == nil {
+		return nil
+	}
+	return s.cur.Err()
+}
+
+// advance 结束当前音轨并切换到下一首（若已预加载）
+func (s *sequencer) advance() {
+	prev := s.cur
+	close(prev.ended)
+	if s.next == nil {
+		prev.finished = true
+		s.ended(prev, nil)
+		return
+	}
+	s.cur, s.next = s.next, nil
+	s.fading = false
+	s.ended(prev, s.cur)
+	// 关闭文件不必占用音频线程
+	go prev.Close()
+}
+
+func (s *sequencer) ended(prev, next *trackStream) {
+	if s.onEnd != nil {
+		s.onEnd(prev, next)
+	}
+}
+
+// SetNext 指定下一首音轨。解码在后台进行，完成后当前音轨结束时会在采样边界上直接衔接，
+// 不经过 Load/Play。返回的通道在预加载完成（或失败）时收到结果。
+func (p *Player) SetNext(path string) <-chan error {
+	return p.setNext(path, true)
+}
+
+// SetNextJoined 与 SetNext 相同，但即使开启了交叉淡入淡出，两首之间也直接衔接
+// （例如同一专辑的连续曲目）
+func (p *Player) SetNextJoined(path string) <-chan error {
+	return p.setNext(path, false)
+}
+
+func (p *Player) setNext(path string, fadeIn bool) <-chan error {
+	done := make(chan error, 1)
+
+	p.mu.Lock()
+	p.nextGen++
+	gen := p.nextGen
+	p.mu.Unlock()
+
+	go func() {
+		done <- p.preload(path, gen, fadeIn)
+	}()
+	return done
+}
+
+// ClearNext 取消预加载的下一首
+func (p *Player) ClearNext() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextGen++
+
+	p.out.Lock()
+	next := p.seq.next
+	p.seq.next = nil
+	p.out.Unlock()
+
+	if next != nil {
+		_ = next.Close()
+	}
+}
+
+// Next 返回已预加载的下一首路径（没有则为空）
+func (p *Player) Next() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.out.Lock()
+	defer p.out.Unlock()
+	if p.seq.next == nil {
+		return ""
+	}
+	return p.seq.next.path
+}
+
+func (p *Player) preload(path string, gen int, fadeIn bool) error {
+	p.mu.Lock()
+	rate, quality := p.rate, p.quality
+	p.mu.Unlock()
+
+	s, format, err := Open(path)
+	if err != nil {
+		p.emit(Event{Type: EventError, Path: path, Err: err})
+		return err
+	}
+	t := newTrackStream(path, s, format, rate, quality)
+	t.fadeIn = fadeIn
+	t.prefetch(format.SampleRate.N(preloadDuration))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 期间发生了新的 Load/SetNext
+	if gen != p.nextGen {
+		_ = t.Close()
+		return errors.New("next track superseded")
+	}
+
+	p.out.Lock()
+	old := p.seq.next
+	p.seq.next = t
+	p.out.Unlock()
+
+	if old != nil {
+		_ = old.Close()
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package player
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/speaker"
+)
+
+// Output 是音频输出后端。Player 把整条播放链交给它混音输出，
+// 并在修改音频线程可见的状态时通过 Lock/Unlock 与其同步。
+type Output interface {
+	// Init 以给定采样率和缓冲采样数打开输出，只会被调用一次
+	Init(rate beep.SampleRate, bufferSize int) error
+	// Play 把流加入混音器
+	Play(s ...beep.Streamer)
+	// Clear 移除所有正在播放的流
+	Clear()
+	// Lock 阻止音频线程读取流，期间可以安全地修改它们
+	Lock()
+	Unlock()
+	// Close 停止输出并释放资源
+	Close() error
+}
+
+// ParseOutput 按描述创建输出后端："speaker"（或空串）为系统声卡，"null" 丢弃所有采样，
+// "wav:<文件>" 把混音结果写入 WAV 文件。文件在播放器首次加载音轨时才会创建。
+func ParseOutput(spec string) (Output, error) {
+	switch {
+	case spec == "" || spec == "speaker":
+		return NewSpeakerOutput(), nil
+	case spec == "null":
+		return NewNullOutput(), nil
+	case strings.HasPrefix(spec, "wav:") && len(spec) > len("wav:"):
+		return NewWAVOutput(strings.TrimPrefix(spec, "wav:")), nil
+	}
+	return nil, fmt.Errorf("unknown output %q (want speaker, null or wav:<file>)", spec)
+}
+
+// speakerOutput 通过 beep/speaker 输出到系统声卡
+type speakerOutput struct{}
+
+// NewSpeakerOutput 返回默认的声卡输出
+func NewSpeakerOutput() Output {
+	return speakerOutput{}
+}
+
+func (speakerOutput) Init(rate beep.SampleRate, bufferSize int) error {
+	return speaker.Init(rate, bufferSize)
+}
+
+func (speakerOutput) Play(s ...beep.Streamer) { speaker.Play(s...) }
+func (speakerOutput) Clear()                  { speaker.Clear() }
+func (speakerOutput) Lock()                   { speaker.Lock() }
+func (speakerOutput) Unlock()                 { speaker.Unlock() }
+
+func (speakerOutput) Close() error {
+	speaker.Close()
+	return nil
+}
+
+// clockOutput 按墙上时钟节奏自行混音，每个缓冲周期把混音结果交给 sink。
+// 它不依赖声卡，可以在无音频设备的环境中运行。
+type clockOutput struct {
+	mu      sync.Mutex
+	mixer   beep.Mixer
+	samples [][2]float64
+	done    chan struct{}
+	stopped chan struct{}
+	sink    func(samples [][2]float64) error
+	close   func() error
+	err     error
+}
+
+// NewNullOutput 返回一个丢弃所有采样的输出，按实时速率消费数据
+func NewNullOutput() Output {
+	return &clockOutput{}
+}
+
+func (o *clockOutput) Init(rate beep.SampleRate, bufferSize int) error {
+	o.samples = make([][2]float64, bufferSize)
+	o.done = make(chan struct{})
+	o.stopped = make(chan struct{})
+	go o.run(rate.D(bufferSize))
+	return nil
+}
+
+func (o *clockOutput) run(period time.Duration) {
+	defer close(o.stopped)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+		}
+
+		o.mu.Lock()
+		o.mixer.Stream(o.samples)
+		o.mu.Unlock()
+
+		if o.sink != nil && o.err == nil {
+			o.err = o.sink(o.samples)
+		}
+	}
+}
+
+func (o *clockOutput) Play(s ...beep.Streamer) {
+	o.mu.Lock()
+	o.mixer.Add(s...)
+	o.mu.Unlock()
+}
+
+func (o *clockOutput) Clear() {
+	o.mu.Lock()
+	o.mixer.Clear()
+	o.mu.Unlock()
+}
+
+func (o *clockOutput) Lock()   { o.mu.Lock() }
+func (o *clockOutput) Unlock() { o.mu.Unlock() }
+
+func (o *clockOutput) Close() error {
+	if o.done != nil {
+		close(o.done)
+		<-o.stopped
+		o.done = nil
+	}
+	err := o.err
+	if o.close != nil {
+		if cerr := o.close(); err == nil {
+			err = cerr
+		}
+		o.close = nil
+	}
+	return err
+}
+
+// NewWAVOutput 返回一个按实时速率把混音结果写入 WAV 文件（16 位立体声 PCM）的输出，
+// 文件在 Init 时创建，Close 时补全头部长度。
+func NewWAVOutput(path string) Output {
+	return &wavOutput{path: path}
+}
+
+type wavOutput struct {
+	clockOutput
+	path    string
+	file    *os.File
+	written uint32
+	buf     []byte
+}
+
+func (o *wavOutput) Init(rate beep.SampleRate, bufferSize int) error {
+	f, err := os.Create(o.path)
+	if err != nil {
+		return fmt.Errorf("create wav output: %w", err)
+	}
+	if _, err := f.Write(wavHeader(rate, 0)); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write wav header: %w", err)
+	}
+	o.file = f
+	o.buf = make([]byte, bufferSize*4)
+	o.sink = o.write
+	o.close = o.finish
+	return o.clockOutput.Init(rate, bufferSize)
+}
+
+func (o *wavOutput) write(samples [][2]float64) error {
+	buf := o.buf[:len(samples)*4]
+	for i, s := range samples {
+		for c := range s {
+			v := int16(math.Max(-1, math.Min(1, s[c])) * math.MaxInt16)
+			binary.LittleEndian.PutUint16(buf[i*4+c*2:], uint16(v))
+		}
+	}
+	n, err := o.file.Write(buf)
+	o.written += uint32(n)
+	return err
+}
+
+// finish 回填 RIFF 与 data 块长度并关闭文件
+func (o *wavOutput) finish() error {
+	header := wavHeader(0, o.written)
+	if _, err := o.file.WriteAt(header[4:8], 4); err != nil {
+		_ = o.file.Close()
+		return err
+	}
+	if _, err := o.file.WriteAt(header[40:44], 40); err != nil {
+		_ = o.file.Close()
+		return err
+	}
+	return o.file.Close()
+}
+
+// wavHeader 生成 44 字节的 PCM WAV 头
+func wavHeader(rate beep.SampleRate, dataLen uint32) []byte {
+	const channels, bits = 2, 16
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 36+dataLen)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(h[22:], channels)
+	binary.LittleEndian.PutUint32(h[24:], uint32(rate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(rate)*channels*bits/8)
+	binary.LittleEndian.PutUint16(h[32:], channels*bits/8)
+	binary.LittleEndian.PutUint16(h[34:], bits)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], dataLen)
+	return h
+}
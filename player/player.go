@@ -1,203 +1,349 @@
 package player
 
 import (
-	"errors"
 	"fmt"
+	"io"
 	"math"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/faiface/beep"
 	"github.com/faiface/beep/effects"
-	"github.com/faiface/beep/speaker"
 )
 
+// 默认输出参数
+const (
+	DefaultSampleRate beep.SampleRate = 44100
+	DefaultQuality                    = 4
+)
+
+// Options 配置播放器的输出
+type Options struct {
+	SampleRate beep.SampleRate // 输出设备采样率，所有音轨都会重采样到该采样率
+	Quality    int             // 重采样质量（1~64），越高越耗 CPU
+	Output     Output          // 输出后端，默认为系统声卡
+}
+
 type Player struct {
 	mu      sync.Mutex
-	stream  beep.StreamSeekCloser
-	format  beep.Format
+	seq     *sequencer // 当前音轨与预加载的下一首（由输出锁保护）
+	out     Output
+	rate    beep.SampleRate // 输出采样率，输出只按它初始化一次
+	quality int             // 重采样质量
 	ctrl    *beep.Ctrl
 	vol     *effects.Volume
-	playing bool
-	endedCh chan struct{}
+	state   State
 	inited  bool
+	playGen int // 每次 Play 递增，用于忽略过期的结束回调
+	nextGen int // 每次 Load/SetNext 递增，用于丢弃过期的预加载
+
+	events events
 }
 
-// New 返回一个未加载音轨的播放器
+// New 返回一个使用默认输出参数、未加载音轨的播放器
 func New() *Player {
-	return &Player{
-		endedCh: make(chan struct{}),
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions 按指定输出参数创建播放器，零值字段使用默认值
+func NewWithOptions(opts Options) *Player {
+	if opts.SampleRate <= 0 {
+		opts.SampleRate = DefaultSampleRate
+	}
+	if opts.Quality < 1 || opts.Quality > 64 {
+		opts.Quality = DefaultQuality
+	}
+	if opts.Output == nil {
+		opts.Output = NewSpeakerOutput()
+	}
+
+	p := &Player{
+		seq:     &sequencer{rate: opts.SampleRate},
+		out:     opts.Output,
+		rate:    opts.SampleRate,
+		quality: opts.Quality,
+	}
+	p.events.subs = make(map[*subscriber]struct{})
+	p.seq.onEnd = p.trackEnded
+	// 整条链在播放器生命周期内保持不变，换轨只替换 sequencer 中的音轨
+	p.ctrl = &beep.Ctrl{Streamer: p.seq, Paused: true}
+	p.vol = &effects.Volume{
+		Streamer: p.ctrl,
+		Base:     2,   // 对数底数
+		Volume:   0.0, // dB，0 表示 1.0 倍
+		Silent:   false,
 	}
+	return p
 }
 
-// Load 加载音轨但不自动播放。会根据轨道采样率初始化/重建 speaker。
-func (p *Player) Load(path string) error {
+// SampleRate 返回输出采样率
+func (p *Player) SampleRate() beep.SampleRate {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.rate
+}
 
-	// 关闭旧流
-	if p.stream != nil {
-		_ = p.stream.Close()
-		p.stream = nil
+// SetResampleQuality 设置重采样质量，对之后加载的音轨生效
+func (p *Player) SetResampleQuality(quality int) error {
+	if quality < 1 || quality > 64 {
+		return fmt.Errorf("invalid resample quality: %d (must be 1-64)", quality)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.quality = quality
+	return nil
+}
+
+// Load 加载音轨但不自动播放。音轨会被重采样到输出采样率，输出只在首次加载时初始化。
+func (p *Player) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
+	return p.LoadReader(path, f)
+}
 
-	s, format, err := Open(path)
+// LoadReader 从任意可定位的数据源加载音轨（内存、压缩包中的条目等）。
+// name 用于格式兜底判断与 Current 的返回值；播放器接管 r 并负责关闭它。
+func (p *Player) LoadReader(name string, r io.ReadSeekCloser) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 丢弃尚未完成的预加载
+	p.nextGen++
+
+	s, format, err := OpenReader(name, r)
 	if err != nil {
+		p.emit(Event{Type: EventError, Path: name, Err: err})
 		return err
 	}
 
-	// 需要按轨道采样率初始化 speaker
-	if !p.inited || p.format.SampleRate != format.SampleRate {
+	if !p.inited {
 		// 100ms 缓冲
-		if err := speaker.Init(format.SampleRate, format.SampleRate.N(time.Second/10)); err != nil {
+		if err := p.out.Init(p.rate, p.rate.N(time.Second/10)); err != nil {
 			_ = s.Close()
-			return fmt.Errorf("speaker init: %w", err)
+			p.state = StateError
+			err = fmt.Errorf("output init: %w", err)
+			p.emit(Event{Type: EventError, Path: name, Err: err})
+			return err
 		}
 		p.inited = true
 	}
-	p.format = format
 
-	// 包装控制与音量
-	p.ctrl = &beep.Ctrl{Streamer: s, Paused: true}
-	p.vol = &effects.Volume{
-		Streamer: p.ctrl,
-		Base:     2,   // 对数底数
-		Volume:   0.0, // dB，0 表示 1.0 倍
-		Silent:   false,
-	}
-	p.stream = s
+	t := newTrackStream(name, s, format, p.rate, p.quality)
+
+	p.out.Lock()
+	old, oldNext := p.seq.cur, p.seq.next
+	p.seq.cur, p.seq.next = t, nil
+	p.seq.fading = false
+	p.ctrl.Paused = true
+	p.out.Unlock()
 
-	// 重置 ended 通知通道
-	p.endedCh = make(chan struct{})
+	// 关闭旧流
+	for _, o := range []*trackStream{old, oldNext} {
+		if o != nil {
+			_ = o.Close()
+		}
+	}
 
-	p.playing = false
+	p.state = StateLoaded
+	p.emit(Event{Type: EventLoaded, Path: name, Duration: t.duration()})
 	return nil
 }
 
-// Play 从当前位置开始播放
+// Play 从当前位置开始播放，播放完毕后再次调用会从头开始
 func (p *Player) Play() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.stream == nil {
-		return errors.New("no track loaded")
+	return p.play()
+}
+
+// play 切换到 StatePlaying，调用方需持有 p.mu
+func (p *Player) play() error {
+	if err := p.check("play", playFrom); err != nil {
+		return err
 	}
-	if p.playing {
+	if p.state == StatePlaying {
 		return nil
 	}
+	if p.state == StateEnded {
+		p.out.Lock()
+		err := p.seq.cur.Seek(0)
+		p.out.Unlock()
+		if err != nil {
+			return err
+		}
+	}
 
 	// Clear any existing streams before adding new one
-	speaker.Clear()
+	p.out.Clear()
 
 	p.ctrl.Paused = false
-	p.playing = true
-
-	// 组合回调：播放结束时发信号
-	speaker.Play(beep.Seq(p.vol, beep.Callback(func() {
-		p.mu.Lock()
-		defer p.mu.Unlock()
-		if p.playing { // 防止 Stop 后重复发送
-			p.playing = false
-			select {
-			case <-p.endedCh:
-				// 已关闭则不重复
-			default:
-				close(p.endedCh)
-			}
-		}
+	p.state = StatePlaying
+	p.playGen++
+	gen := p.playGen
+
+	// 组合回调：整个序列播放结束时更新状态。
+	// 回调运行在音频线程且持有输出锁，这里异步获取 p.mu 以免与 Pause 等方法互相等待。
+	p.out.Play(beep.Seq(p.vol, beep.Callback(func() {
+		go p.drained(gen)
 	})))
+	p.emitState(EventPlaying)
 	return nil
 }
 
-// Pause 暂停播放
-func (p *Player) Pause() {
+// drained 在序列播放完毕后调用
+func (p *Player) drained(gen int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.ctrl != nil {
-		speaker.Lock()
-		p.ctrl.Paused = true
-		speaker.Unlock()
-		p.playing = false
+	if gen != p.playGen || p.state != StatePlaying { // 防止 Stop/重新 Play 后误改状态
+		return
+	}
+	p.out.Lock()
+	err := p.seq.Err()
+	p.out.Unlock()
+	if err != nil {
+		p.state = StateError
+		return
 	}
+	p.state = StateEnded
 }
 
-// Toggle 切换播放/暂停
-func (p *Player) Toggle() error {
+// Pause 暂停播放；对刚加载的音轨调用时直接进入 StatePaused
+func (p *Player) Pause() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.ctrl == nil {
-		return errors.New("no track loaded")
+	return p.pause()
+}
+
+// pause 切换到 StatePaused，调用方需持有 p.mu
+func (p *Player) pause() error {
+	if err := p.check("pause", pauseFrom); err != nil {
+		return err
 	}
-	speaker.Lock()
-	p.ctrl.Paused = !p.ctrl.Paused
-	p.playing = !p.ctrl.Paused
-	speaker.Unlock()
+	if p.state == StatePaused {
+		return nil
+	}
+	p.out.Lock()
+	p.ctrl.Paused = true
+	p.out.Unlock()
+	p.state = StatePaused
+	p.emitState(EventPaused)
 	return nil
 }
 
+// Toggle 正在播放时暂停，否则开始播放（包括停止或播放完毕之后）
+func (p *Player) Toggle() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == StatePlaying {
+		return p.pause()
+	}
+	return p.play()
+}
+
 // Stop 停止播放并回到开头
-func (p *Player) Stop() {
+func (p *Player) Stop() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.ctrl == nil || p.stream == nil {
-		return
+	if err := p.check("stop", stopFrom); err != nil {
+		return err
 	}
-	speaker.Lock()
+	p.out.Lock()
 	p.ctrl.Paused = true
-	_ = p.stream.Seek(0)
-	speaker.Unlock()
-	p.playing = false
+	p.seq.cancelFade()
+	_ = p.seq.cur.Seek(0)
+	p.out.Unlock()
+	p.state = StateStopped
+	p.playGen++
 
-	// Clear the speaker to stop any ongoing playback
-	speaker.Clear()
+	// Clear the output to stop any ongoing playback
+	p.out.Clear()
+	p.emitState(EventStopped)
+	return nil
 }
 
 // Seek 定位到指定时间（超界会被截断）
 func (p *Player) Seek(pos time.Duration) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.stream == nil {
-		return errors.New("no track loaded")
+	if err := p.check("seek", seekFrom); err != nil {
+		return err
 	}
-	samples := p.format.SampleRate.N(pos)
-	speaker.Lock()
-	err := p.stream.Seek(samples)
-	speaker.Unlock()
-	return err
+	p.out.Lock()
+	cur := p.seq.cur
+	samples := cur.format.SampleRate.N(pos)
+	if samples < 0 {
+		samples = 0
+	}
+	if samples > cur.Len() {
+		samples = cur.Len()
+	}
+	p.seq.cancelFade()
+	err := cur.Seek(samples)
+	p.out.Unlock()
+	if err != nil {
+		return err
+	}
+	if p.state == StateEnded {
+		// 输出已排空，之后需要重新 Play
+		p.state = StatePaused
+	}
+	p.emit(Event{Type: EventSeeked, Path: cur.path, Position: cur.format.SampleRate.D(samples)})
+	return nil
 }
 
 // Position 返回当前播放位置
 func (p *Player) Position() time.Duration {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.stream == nil {
+	p.out.Lock()
+	defer p.out.Unlock()
+	cur := p.seq.cur
+	if cur == nil {
 		return 0
 	}
-	// StreamSeekCloser 的 Position() 不是接口方法，但多数解码器都实现了
-	type posLen interface {
-		Position() int
-		Len() int
-	}
-	if s, ok := p.stream.(posLen); ok {
-		return p.format.SampleRate.D(s.Position())
-	}
-	return 0
+	// 无缝衔接后当前音轨可能与 Load 时不同，按音轨自身采样率换算
+	return cur.format.SampleRate.D(cur.Position())
 }
 
 // Duration 返回音轨总时长（可能为 0，取决于解码器是否可得）
 func (p *Player) Duration() time.Duration {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.stream == nil {
+	p.out.Lock()
+	defer p.out.Unlock()
+	if p.seq.cur == nil {
 		return 0
 	}
-	type posLen interface {
-		Position() int
-		Len() int
+	return p.seq.cur.duration()
+}
+
+// Current 返回正在播放（或已加载）的音轨路径，无缝衔接后会随之变化
+func (p *Player) Current() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.out.Lock()
+	defer p.out.Unlock()
+	if p.seq.cur == nil {
+		return ""
 	}
-	if s, ok := p.stream.(posLen); ok && s.Len() > 0 {
-		return p.format.SampleRate.D(s.Len())
+	return p.seq.cur.path
+}
+
+// emitState 发出带当前音轨信息的状态事件，调用方需持有 p.mu
+func (p *Player) emitState(t EventType) {
+	p.out.Lock()
+	cur := p.seq.cur
+	e := Event{Type: t}
+	if cur != nil {
+		e.Path = cur.path
+		e.Position = cur.format.SampleRate.D(cur.Position())
+		e.Duration = cur.duration()
 	}
-	return 0
+	p.out.Unlock()
+	p.emit(e)
 }
 
 // SetVolume 设置线性音量（0.0~1.0；>1.0 也可但可能失真）
@@ -207,32 +353,76 @@ func (p *Player) SetVolume(linear float64) {
 	if p.vol == nil {
 		return
 	}
+	p.out.Lock()
 	if linear <= 0 {
+		linear = 0
 		p.vol.Silent = true
 		p.vol.Volume = 0
-		return
+	} else {
+		p.vol.Silent = false
+		// 把线性增益映射成 dB：vol(volume in dB) 满足 Base^Volume = linear
+		// 以 Base=2：Volume = log2(linear) = ln(l)/ln(2)
+		p.vol.Volume = math.Log(linear) / math.Log(p.vol.Base)
+	}
+	p.out.Unlock()
+	p.emit(Event{Type: EventVolumeChanged, Volume: linear})
+}
+
+// Volume 返回当前线性音量，静音时为 0
+func (p *Player) Volume() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.out.Lock()
+	defer p.out.Unlock()
+	if p.vol.Silent {
+		return 0
 	}
-	p.vol.Silent = false
-	// 把线性增益映射成 dB：vol(volume in dB) 满足 Base^Volume = linear
-	// 以 Base=2：Volume = log2(linear) = ln(l)/ln(2)
-	p.vol.Volume = math.Log(linear) / math.Log(p.vol.Base)
+	return math.Pow(p.vol.Base, p.vol.Volume)
 }
 
-// OnEnded 返回一个只读通道，曲目播放完毕会关闭该通道
+// Playing 报告是否正在播放，即 State() == StatePlaying
+func (p *Player) Playing() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state == StatePlaying
+}
+
+// OnEnded 返回一个只读通道，当前曲目播放完毕会关闭该通道。
+// 每条音轨各有一个通道，无缝衔接到下一首后应重新获取；未加载时返回 nil。
 func (p *Player) OnEnded() <-chan struct{} {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.endedCh
+	p.out.Lock()
+	defer p.out.Unlock()
+	if p.seq.cur == nil {
+		return nil
+	}
+	return p.seq.cur.ended
 }
 
-// Close 释放当前流
+// Close 释放当前流并关闭输出
 func (p *Player) Close() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.stream != nil {
-		err := p.stream.Close()
-		p.stream = nil
-		return err
+	p.nextGen++
+
+	var err error
+	if p.inited {
+		err = p.out.Close()
+		p.inited = false
 	}
-	return nil
+	p.state = StateEmpty
+
+	cur, next := p.seq.cur, p.seq.next
+	p.seq.cur, p.seq.next = nil, nil
+
+	if next != nil {
+		_ = next.Close()
+	}
+	if cur != nil {
+		if cerr := cur.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
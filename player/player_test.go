@@ -0,0 +1,363 @@
+package player
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+// toneWAV 生成一段 16 位立体声正弦波 WAV 文件的内容
+func toneWAV(rate beep.SampleRate, d time.Duration) []byte {
+	n := rate.N(d)
+	data := make([]byte, n*4)
+	for i := range n {
+		v := int16(0.5 * math.MaxInt16 * math.Sin(2*math.Pi*440*float64(i)/float64(rate)))
+		binary.LittleEndian.PutUint16(data[i*4:], uint16(v))
+		binary.LittleEndian.PutUint16(data[i*4+2:], uint16(v))
+	}
+	return append(wavHeader(rate, uint32(len(data))), data...)
+}
+
+// writeTone 在 dir 下写入一段 16 位立体声正弦波 WAV 文件并返回路径
+func writeTone(t *testing.T, dir, name string, rate beep.SampleRate, d time.Duration) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, toneWAV(rate, d), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newNullPlayer 返回一个输出到空设备的播放器，测试结束时关闭
+func newNullPlayer(t *testing.T) *Player {
+	t.Helper()
+	p := NewWithOptions(Options{Output: NewNullOutput()})
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// waitFor 等待 cond 成立，超时则失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitClosed 等待通道被关闭，超时则失败
+func waitClosed(t *testing.T, what string, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestStateTransitions(t *testing.T) {
+	path := writeTone(t, t.TempDir(), "tone.wav", DefaultSampleRate, 300*time.Millisecond)
+	p := newNullPlayer(t)
+
+	var te *TransitionError
+	if err := p.Play(); !errors.As(err, &te) || te.From != StateEmpty {
+		t.Fatalf("Play without a track = %v, want a TransitionError from empty", err)
+	}
+	if err := p.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if s := p.State(); s != StateLoaded {
+		t.Fatalf("after Load state = %s, want loaded", s)
+	}
+
+	steps := []struct {
+		name string
+		op   func() error
+		want State
+	}{
+		// 恢复会话时加载后直接暂停
+		{"pause", p.Pause, StatePaused},
+		{"seek", func() error { return p.Seek(100 * time.Millisecond) }, StatePaused},
+		{"play", p.Play, StatePlaying},
+		{"pause", p.Pause, StatePaused},
+		{"seek", func() error { return p.Seek(100 * time.Millisecond) }, StatePaused},
+		{"play", p.Play, StatePlaying},
+		{"stop", p.Stop, StateStopped},
+		{"seek", func() error { return p.Seek(100 * time.Millisecond) }, StateStopped},
+		{"toggle", p.Toggle, StatePlaying},
+	}
+	for _, step := range steps {
+		if err := step.op(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if s := p.State(); s != step.want {
+			t.Fatalf("after %s state = %s, want %s", step.name, s, step.want)
+		}
+	}
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pause(); !errors.As(err, &te) || te.From != StateStopped {
+		t.Fatalf("Pause when stopped = %v, want a TransitionError from stopped", err)
+	}
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the track to end", func() bool { return p.State() == StateEnded })
+	if err := p.Seek(0); err != nil {
+		t.Fatal(err)
+	}
+	if s := p.State(); s != StatePaused {
+		t.Fatalf("after seeking an ended track state = %s, want paused", s)
+	}
+}
+
+func TestGaplessAdvance(t *testing.T) {
+	dir := t.TempDir()
+	first := writeTone(t, dir, "first.wav", DefaultSampleRate, 300*time.Millisecond)
+	// 采样率不同的下一首需要重采样后再衔接
+	second := writeTone(t, dir, "second.wav", 22050, 300*time.Millisecond)
+
+	p := newNullPlayer(t)
+	events, cancel := p.Subscribe(0)
+	defer cancel()
+
+	if err := p.Load(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-p.SetNext(second); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Next(); got != second {
+		t.Fatalf("Next = %q, want %q", got, second)
+	}
+	ended := p.OnEnded()
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitClosed(t, "the first track to end", ended)
+	if got := p.Current(); got != second {
+		t.Fatalf("after the first track Current = %q, want %q", got, second)
+	}
+	if s := p.State(); s != StatePlaying {
+		t.Fatalf("after a gapless advance state = %s, want playing", s)
+	}
+	if got := p.Next(); got != "" {
+		t.Fatalf("after the advance Next = %q, want none", got)
+	}
+
+	waitClosed(t, "the second track to end", p.OnEnded())
+	waitFor(t, "the player to end", func() bool { return p.State() == StateEnded })
+
+	// 第一首结束时紧接着发出第二首的 EventPlaying，而不是经过 Load
+	var seen []Event
+	for len(events) > 0 {
+		seen = append(seen, <-events)
+	}
+	var endedFirst, playingSecond bool
+	for _, e := range seen {
+		switch {
+		case e.Type == EventEnded && e.Path == first:
+			endedFirst = true
+		case e.Type == EventPlaying && e.Path == second && endedFirst:
+			playingSecond = true
+		case e.Type == EventLoaded && e.Path == second:
+			t.Fatalf("the second track was loaded instead of joined: %v", seen)
+		}
+	}
+	if !playingSecond {
+		t.Fatalf("events %v do not play the second track after the first ended", seen)
+	}
+}
+
+func TestLoadReader(t *testing.T) {
+	p := newNullPlayer(t)
+	f := newMemFile(toneWAV(DefaultSampleRate, 200*time.Millisecond))
+	if err := p.LoadReader("memory.wav", f); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Current(); got != "memory.wav" {
+		t.Errorf("Current = %q, want memory.wav", got)
+	}
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the track to end", func() bool { return p.State() == StateEnded })
+	if f.closed {
+		t.Fatal("the reader is closed while its track is loaded")
+	}
+
+	// 解码失败时立即关闭 reader，当前音轨不受影响
+	bad := newMemFile([]byte("not audio at all"))
+	if err := p.LoadReader("bad.wav", bad); err == nil {
+		t.Fatal("loading a file that is not audio succeeded")
+	}
+	if !bad.closed {
+		t.Error("the reader is left open after decoding failed")
+	}
+	if got, s := p.Current(), p.State(); got != "memory.wav" || s != StateEnded {
+		t.Errorf("after a failed load current = %q in state %s, want memory.wav ended", got, s)
+	}
+
+	// 换下的音轨释放它的 reader
+	next := newMemFile(toneWAV(DefaultSampleRate, 100*time.Millisecond))
+	if err := p.LoadReader("next.wav", next); err != nil {
+		t.Fatal(err)
+	}
+	if !f.closed {
+		t.Error("the replaced track's reader is still open")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !next.closed {
+		t.Error("closing the player leaves the reader open")
+	}
+}
+
+// failingOutput 是无法打开的输出
+type failingOutput struct {
+	Output
+}
+
+func (failingOutput) Init(beep.SampleRate, int) error { return errors.New("no device") }
+
+func TestLoadReaderReleasesTheReaderWhenTheOutputFails(t *testing.T) {
+	p := NewWithOptions(Options{Output: failingOutput{NewNullOutput()}})
+	defer p.Close()
+	f := newMemFile(toneWAV(DefaultSampleRate, 100*time.Millisecond))
+	if err := p.LoadReader("memory.wav", f); err == nil {
+		t.Fatal("loading succeeded without an output")
+	}
+	if !f.closed {
+		t.Error("the reader is left open after the output failed")
+	}
+}
+
+func TestWAVOutput(t *testing.T) {
+	dir := t.TempDir()
+	track := writeTone(t, dir, "tone.wav", 22050, 200*time.Millisecond)
+	out := filepath.Join(dir, "out.wav")
+
+	p := NewWithOptions(Options{Output: NewWAVOutput(out)})
+	if err := p.Load(track); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the track to end", func() bool { return p.State() == StateEnded })
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 44 || string(data[0:4]) != "RIFF" || string(data[8:16]) != "WAVEfmt " || string(data[36:40]) != "data" {
+		t.Fatalf("output does not start with a WAV header: % x", data[:min(len(data), 44)])
+	}
+	if got := binary.LittleEndian.Uint32(data[24:]); got != uint32(DefaultSampleRate) {
+		t.Errorf("sample rate = %d, want the output rate %d", got, DefaultSampleRate)
+	}
+	// finish 回填的长度必须与实际写入的数据一致
+	if got, want := binary.LittleEndian.Uint32(data[4:]), uint32(len(data)-8); got != want {
+		t.Errorf("RIFF length = %d, want %d", got, want)
+	}
+	dataLen := binary.LittleEndian.Uint32(data[40:])
+	if want := uint32(len(data) - 44); dataLen != want {
+		t.Errorf("data length = %d, want %d", dataLen, want)
+	}
+	if dataLen%4 != 0 {
+		t.Errorf("data length %d is not a whole number of stereo frames", dataLen)
+	}
+	// 输出按实时速率写入，至少包含整首音轨
+	if min := uint32(DefaultSampleRate.N(200*time.Millisecond)) * 4; dataLen < min {
+		t.Errorf("data length = %d, want at least %d for the whole track", dataLen, min)
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	for spec, want := range map[string]string{
+		"":          "speaker",
+		"speaker":   "speaker",
+		"null":      "null",
+		"wav:x.wav": "wav",
+	} {
+		out, err := ParseOutput(spec)
+		if err != nil {
+			t.Errorf("ParseOutput(%q): %v", spec, err)
+			continue
+		}
+		got := "unknown"
+		switch o := out.(type) {
+		case speakerOutput:
+			got = "speaker"
+		case *clockOutput:
+			got = "null"
+		case *wavOutput:
+			got = "wav"
+			if o.path != "x.wav" {
+				t.Errorf("ParseOutput(%q) writes to %q, want x.wav", spec, o.path)
+			}
+		}
+		if got != want {
+			t.Errorf("ParseOutput(%q) = %s output, want %s", spec, got, want)
+		}
+	}
+	for _, spec := range []string{"wav:", "wav", "alsa"} {
+		if _, err := ParseOutput(spec); err == nil {
+			t.Errorf("ParseOutput(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestPositionTicks(t *testing.T) {
+	path := writeTone(t, t.TempDir(), "tone.wav", DefaultSampleRate, 500*time.Millisecond)
+	p := newNullPlayer(t)
+	events, cancel := p.Subscribe(50 * time.Millisecond)
+	defer cancel()
+
+	if err := p.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	// 未播放时不发出位置事件
+	time.Sleep(150 * time.Millisecond)
+	for len(events) > 0 {
+		if e := <-events; e.Type == EventPosition {
+			t.Fatalf("position event %+v before playing", e)
+		}
+	}
+
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type != EventPosition {
+				continue
+			}
+			if e.Path != path || e.Duration != 500*time.Millisecond {
+				t.Fatalf("position event %+v, want one for %s lasting 500ms", e, path)
+			}
+			if e.Position > 0 {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a position event")
+		}
+	}
+}
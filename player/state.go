@@ -0,0 +1,76 @@
+package player
+
+import (
+	"fmt"
+	"slices"
+)
+
+// State 是播放器的播放状态
+type State int
+
+const (
+	StateEmpty   State = iota // 未加载音轨
+	StateLoaded               // 已加载，尚未播放
+	StatePlaying              // 正在播放
+	StatePaused               // 已暂停，可从当前位置继续
+	StateStopped              // 已停止并回到开头
+	StateEnded                // 播放到结尾（含预加载的后续音轨）
+	StateError                // 输出或解码出错，需要重新加载
+)
+
+func (s State) String() string {
+	switch s {
+	case StateEmpty:
+		return "empty"
+	case StateLoaded:
+		return "loaded"
+	case StatePlaying:
+		return "playing"
+	case StatePaused:
+		return "paused"
+	case StateStopped:
+		return "stopped"
+	case StateEnded:
+		return "ended"
+	case StateError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// TransitionError 表示当前状态下不允许执行的操作
+type TransitionError struct {
+	Op   string // 操作名，如 "play"、"seek"
+	From State  // 执行操作时所处的状态
+}
+
+func (e *TransitionError) Error() string {
+	if e.From == StateEmpty {
+		return fmt.Sprintf("cannot %s: no track loaded", e.Op)
+	}
+	return fmt.Sprintf("cannot %s: player is %s", e.Op, e.From)
+}
+
+// 各操作允许的起始状态。已加载的音轨可以直接暂停，
+// 例如恢复会话时停在保存的位置，等待继续播放。
+var (
+	playFrom  = []State{StateLoaded, StatePlaying, StatePaused, StateStopped, StateEnded}
+	pauseFrom = []State{StateLoaded, StatePlaying, StatePaused}
+	stopFrom  = []State{StateLoaded, StatePlaying, StatePaused, StateStopped, StateEnded}
+	seekFrom  = []State{StateLoaded, StatePlaying, StatePaused, StateStopped, StateEnded}
+)
+
+// State 返回当前播放状态
+func (p *Player) State() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// check 校验 op 能否在当前状态下执行，调用方需持有 p.mu
+func (p *Player) check(op string, allowed []State) error {
+	if slices.Contains(allowed, p.state) {
+		return nil
+	}
+	return &TransitionError{Op: op, From: p.state}
+}
@@ -0,0 +1,87 @@
+package player
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/faiface/beep"
+	"github.com/jfreymuth/oggvorbis"
+)
+
+// vorbisDecoder 解码 Ogg Vorbis。与 beep/vorbis 不同，它按实际声道数拆分采样，
+// 单声道文件会被复制到左右声道而不是被当作立体声读成两倍速。
+type vorbisDecoder struct {
+	r   *oggvorbis.Reader
+	buf []float32
+	err error
+}
+
+func decodeVorbis(in io.ReadSeeker) (beep.StreamSeekCloser, beep.Format, error) {
+	r, err := oggvorbis.NewReader(in)
+	if err != nil {
+		return nil, beep.Format{}, fmt.Errorf("ogg/vorbis: %w", err)
+	}
+	format := beep.Format{
+		SampleRate:  beep.SampleRate(r.SampleRate()),
+		NumChannels: r.Channels(),
+		Precision:   2,
+	}
+	return &vorbisDecoder{r: r}, format, nil
+}
+
+func (d *vorbisDecoder) Stream(samples [][2]float64) (n int, ok bool) {
+	if d.err != nil {
+		return 0, false
+	}
+	channels := d.r.Channels()
+	if want := len(samples) * channels; len(d.buf) < want {
+		d.buf = make([]float32, want)
+	}
+
+	for n < len(samples) {
+		read, err := d.r.Read(d.buf[:(len(samples)-n)*channels])
+		for i := 0; i < read/channels; i++ {
+			frame := d.buf[i*channels:]
+			left, right := float64(frame[0]), float64(frame[0])
+			if channels > 1 {
+				right = float64(frame[1])
+			}
+			samples[n] = [2]float64{left, right}
+			n++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			d.err = fmt.Errorf("ogg/vorbis: %w", err)
+			break
+		}
+		if read == 0 {
+			break
+		}
+	}
+	return n, n > 0
+}
+
+func (d *vorbisDecoder) Err() error {
+	return d.err
+}
+
+func (d *vorbisDecoder) Len() int {
+	return int(d.r.Length())
+}
+
+func (d *vorbisDecoder) Position() int {
+	return int(d.r.Position())
+}
+
+func (d *vorbisDecoder) Seek(p int) error {
+	if err := d.r.SetPosition(int64(p)); err != nil {
+		return fmt.Errorf("ogg/vorbis: %w", err)
+	}
+	return nil
+}
+
+func (d *vorbisDecoder) Close() error {
+	return nil
+}
@@ -0,0 +1,138 @@
+package playlist
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// hashWindow is how much of the start and of the end of a file a partial
+// hash reads. Tags live there, and most edits change the size anyway.
+const hashWindow = 64 << 10
+
+// HashMode is how much of its content the scanner hashes to tell whether
+// a file changed, on top of its size, modification time and inode
+type HashMode int
+
+const (
+	HashOff     HashMode = iota // Trust the file system
+	HashPartial                 // Hash the start and the end of each file
+	HashFull                    // Hash every byte, which is slow on large libraries
+)
+
+func (m HashMode) String() string {
+	switch m {
+	case HashPartial:
+		return "partial"
+	case HashFull:
+		return "full"
+	default:
+		return "off"
+	}
+}
+
+// ParseHashMode parses "off", "partial" or "full"
+func ParseHashMode(s string) (HashMode, error) {
+	switch s {
+	case "off":
+		return HashOff, nil
+	case "partial":
+		return HashPartial, nil
+	case "full":
+		return HashFull, nil
+	}
+	return HashOff, fmt.Errorf("unknown hash mode %q (want off, partial or full)", s)
+}
+
+// fingerprint identifies the version of a file the scanner last read
+type fingerprint struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Inode   uint64    `json:"inode,omitempty"`   // 0 where the file system has none
+	Partial string    `json:"partial,omitempty"` // MD5 of the start and the end
+	Full    string    `json:"full,omitempty"`    // MD5 of the whole file
+}
+
+// takeFingerprint fingerprints a file, hashing as much as mode asks for
+func takeFingerprint(path string, mode HashMode) (fingerprint, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fingerprint{}, err
+	}
+	fp := fingerprint{Size: info.Size(), ModTime: info.ModTime(), Inode: inode(info)}
+
+	switch mode {
+	case HashPartial:
+		fp.Partial, err = partialHash(path, info.Size())
+	case HashFull:
+		fp.Full, err = fullHash(path)
+	}
+	return fp, err
+}
+
+// changedFrom reports whether fp differs from old. A full hash is only
+// taken to verify the content, so without one in old the file counts as
+// changed and is read again. A partial hash only counts when both have
+// one; otherwise fp keeps that of old, or records its own as the baseline
+// for the next comparison.
+func (fp *fingerprint) changedFrom(old fingerprint) bool {
+	if fp.Size != old.Size || !fp.ModTime.Equal(old.ModTime) || fp.Inode != old.Inode {
+		return true
+	}
+	if fp.Partial != "" && old.Partial != "" && fp.Partial != old.Partial {
+		return true
+	}
+	if fp.Full != "" && fp.Full != old.Full {
+		return true
+	}
+	if fp.Partial == "" {
+		fp.Partial = old.Partial
+	}
+	if fp.Full == "" {
+		fp.Full = old.Full
+	}
+	return false
+}
+
+// partialHash hashes the first and last hashWindow bytes of a file, which
+// is all of it when it is small
+func partialHash(path string, size int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if size <= 2*hashWindow {
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+	} else {
+		if _, err := io.CopyN(hash, file, hashWindow); err != nil {
+			return "", err
+		}
+		tail := io.NewSectionReader(file, size-hashWindow, hashWindow)
+		if _, err := io.Copy(hash, tail); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// fullHash hashes a whole file
+func fullHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
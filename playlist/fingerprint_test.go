@@ -0,0 +1,166 @@
+package playlist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFingerprintChangedFrom(t *testing.T) {
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	elsewhere := mtime.In(time.FixedZone("UTC+1", 3600)) // As read back from the cache
+	base := fingerprint{Size: 1000, ModTime: mtime, Inode: 42}
+	with := func(f func(fp *fingerprint)) fingerprint {
+		fp := base
+		f(&fp)
+		return fp
+	}
+
+	tests := []struct {
+		name    string
+		old, fp fingerprint
+		changed bool
+		kept    fingerprint // fp after the comparison, when not changed
+	}{
+		{name: "same", old: base, fp: base, kept: base},
+		{name: "size", old: base, fp: with(func(fp *fingerprint) { fp.Size++ }), changed: true},
+		{name: "inode", old: base, fp: with(func(fp *fingerprint) { fp.Inode = 43 }), changed: true},
+		{
+			// Hashes are not taken to clear a file whose time moved
+			name:    "touched but content unchanged",
+			old:     with(func(fp *fingerprint) { fp.Full = "aaaa" }),
+			fp:      with(func(fp *fingerprint) { fp.ModTime = mtime.Add(time.Second); fp.Full = "aaaa" }),
+			changed: true,
+		},
+		{
+			name: "same time in another zone",
+			old:  base,
+			fp:   with(func(fp *fingerprint) { fp.ModTime = elsewhere }),
+			kept: with(func(fp *fingerprint) { fp.ModTime = elsewhere }),
+		},
+		{
+			name: "partial hash unchanged",
+			old:  with(func(fp *fingerprint) { fp.Partial = "pppp" }),
+			fp:   with(func(fp *fingerprint) { fp.Partial = "pppp" }),
+			kept: with(func(fp *fingerprint) { fp.Partial = "pppp" }),
+		},
+		{
+			name:    "partial hash changed",
+			old:     with(func(fp *fingerprint) { fp.Partial = "pppp" }),
+			fp:      with(func(fp *fingerprint) { fp.Partial = "qqqq" }),
+			changed: true,
+		},
+		{
+			// The first partial hash becomes the baseline
+			name: "partial hash without a baseline",
+			old:  base,
+			fp:   with(func(fp *fingerprint) { fp.Partial = "pppp" }),
+			kept: with(func(fp *fingerprint) { fp.Partial = "pppp" }),
+		},
+		{
+			// With hashing turned off the baselines are carried forward
+			name: "no hashes taken",
+			old:  with(func(fp *fingerprint) { fp.Partial = "pppp"; fp.Full = "ffff" }),
+			fp:   base,
+			kept: with(func(fp *fingerprint) { fp.Partial = "pppp"; fp.Full = "ffff" }),
+		},
+		{
+			name: "full hash unchanged",
+			old:  with(func(fp *fingerprint) { fp.Full = "ffff" }),
+			fp:   with(func(fp *fingerprint) { fp.Full = "ffff" }),
+			kept: with(func(fp *fingerprint) { fp.Full = "ffff" }),
+		},
+		{
+			name:    "same size and mtime but content changed under HashFull",
+			old:     with(func(fp *fingerprint) { fp.Full = "ffff" }),
+			fp:      with(func(fp *fingerprint) { fp.Full = "gggg" }),
+			changed: true,
+		},
+		{
+			// A full hash verifies the content, so without a baseline the
+			// file is read again
+			name:    "full hash without a baseline",
+			old:     with(func(fp *fingerprint) { fp.Partial = "pppp" }),
+			fp:      with(func(fp *fingerprint) { fp.Full = "ffff" }),
+			changed: true,
+		},
+		{
+			// Partial hashes taken under another mode carry on next to full ones
+			name: "full hash keeps the partial baseline",
+			old:  with(func(fp *fingerprint) { fp.Partial = "pppp"; fp.Full = "ffff" }),
+			fp:   with(func(fp *fingerprint) { fp.Full = "ffff" }),
+			kept: with(func(fp *fingerprint) { fp.Partial = "pppp"; fp.Full = "ffff" }),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := tt.fp
+			if got := fp.changedFrom(tt.old); got != tt.changed {
+				t.Fatalf("changedFrom = %v, want %v", got, tt.changed)
+			}
+			if !tt.changed && fp != tt.kept {
+				t.Errorf("fingerprint after the comparison = %+v, want %+v", fp, tt.kept)
+			}
+		})
+	}
+}
+
+func TestTakeFingerprintDetectsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "track.wav")
+	large := make([]byte, 3*hashWindow)
+	write := func(data []byte, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	take := func(mode HashMode) fingerprint {
+		t.Helper()
+		fp, err := takeFingerprint(path, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+
+	tests := []struct {
+		name    string
+		mode    HashMode
+		edit    func(data []byte) // Changes one byte in place, keeping size and time
+		changed bool
+	}{
+		{"off misses an edit", HashOff, func(b []byte) { b[0] = 1 }, false},
+		{"partial sees an edit at the start", HashPartial, func(b []byte) { b[0] = 1 }, true},
+		{"partial sees an edit at the end", HashPartial, func(b []byte) { b[len(b)-1] = 1 }, true},
+		{"partial misses an edit in the middle", HashPartial, func(b []byte) { b[len(b)/2] = 1 }, false},
+		{"full sees an edit in the middle", HashFull, func(b []byte) { b[len(b)/2] = 1 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, len(large))
+			write(data, mtime)
+			old := take(tt.mode)
+
+			tt.edit(data)
+			write(data, mtime)
+			fp := take(tt.mode)
+			if got := fp.changedFrom(old); got != tt.changed {
+				t.Errorf("changedFrom = %v, want %v", got, tt.changed)
+			}
+		})
+	}
+
+	// Writing the same bytes again is still a change when the time moves
+	write(large, mtime)
+	old := take(HashFull)
+	write(large, mtime.Add(time.Minute))
+	fp := take(HashFull)
+	if !fp.changedFrom(old) {
+		t.Error("a touched file is not reported as changed")
+	}
+}
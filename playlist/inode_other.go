@@ -0,0 +1,10 @@
+//go:build !unix
+
+package playlist
+
+import "io/fs"
+
+// inode returns 0 where the file system has no inode numbers
+func inode(info fs.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package playlist
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// inode returns the inode number of a file, which changes when the file
+// is replaced rather than written in place
+func inode(info fs.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
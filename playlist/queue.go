@@ -0,0 +1,526 @@
+package playlist
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+)
+
+// RepeatMode controls what the queue plays after an entry finishes
+type RepeatMode int
+
+const (
+	RepeatOff RepeatMode = iota // Stop after the last entry
+	RepeatOne                   // Play the current entry again
+	RepeatAll                   // Start over after the last entry
+)
+
+func (m RepeatMode) String() string {
+	switch m {
+	case RepeatOne:
+		return "one"
+	case RepeatAll:
+		return "all"
+	default:
+		return "off"
+	}
+}
+
+// ParseRepeatMode parses "off", "one" or "all"
+func ParseRepeatMode(s string) (RepeatMode, error) {
+	switch s {
+	case "off":
+		return RepeatOff, nil
+	case "one":
+		return RepeatOne, nil
+	case "all":
+		return RepeatAll, nil
+	}
+	return RepeatOff, fmt.Errorf("unknown repeat mode %q (want off, one or all)", s)
+}
+
+// Queue is the ordered list of tracks to play, kept separately from the
+// scanned library. It stores track IDs rather than indexes or pointers so
+// entries stay valid when the library is rescanned and reordered.
+//
+// In shuffle mode the queue keeps a play order: the entries played so far
+// followed by the rest of the current pass in shuffled order. Each pass plays
+// every entry once, and Prev walks back through what was actually played.
+type Queue struct {
+	mu      sync.Mutex
+	ids     []string // Track IDs in play order
+	current int      // Index of the current entry, -1 if nothing is current
+	repeat  RepeatMode
+
+	shuffle bool
+	seed    int64      // Seed the shuffle order is derived from
+	rng     *rand.Rand // Source for shuffling, seeded with seed
+	order   []int      // Entry indexes in shuffled play order, including history
+	pos     int        // Position of the current entry in order, -1 if none
+
+	version uint64 // Incremented whenever entries are added, removed or moved
+}
+
+// NewQueue creates an empty Queue that repeats all entries
+func NewQueue() *Queue {
+	return &Queue{current: -1, repeat: RepeatAll, pos: -1}
+}
+
+// Enqueue appends tracks to the end of the queue. When shuffling they are
+// mixed into the unplayed part of the current pass.
+func (q *Queue) Enqueue(ids ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, id := range ids {
+		if q.shuffle {
+			at := q.pos + 1 + q.rng.Intn(len(q.order)-q.pos)
+			q.order = insertAt(q.order, at, len(q.ids))
+		}
+		q.ids = append(q.ids, id)
+	}
+	q.version++
+}
+
+// InsertNext inserts tracks right after the current entry
+func (q *Queue) InsertNext(ids ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	at := q.current + 1
+	rest := append([]string{}, q.ids[at:]...)
+	q.ids = append(append(q.ids[:at], ids...), rest...)
+	q.version++
+
+	if q.shuffle {
+		q.remap(func(i int) int {
+			if i >= at {
+				return i + len(ids)
+			}
+			return i
+		})
+		for k := range ids {
+			q.order = insertAt(q.order, q.pos+1+k, at+k)
+		}
+	}
+}
+
+// Remove removes the entry at index i. Removing the current entry leaves
+// the pointer just before the following entry, so Next continues from there.
+func (q *Queue) Remove(i int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.checkIndex(i); err != nil {
+		return err
+	}
+	q.ids = append(q.ids[:i], q.ids[i+1:]...)
+	q.version++
+	if i <= q.current {
+		q.current--
+	}
+	q.remap(func(j int) int {
+		switch {
+		case j == i:
+			return -1
+		case j > i:
+			return j - 1
+		}
+		return j
+	})
+	return nil
+}
+
+// Move moves the entry at index from to index to, keeping the current
+// pointer on the same track
+func (q *Queue) Move(from, to int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.checkIndex(from); err != nil {
+		return err
+	}
+	if err := q.checkIndex(to); err != nil {
+		return err
+	}
+
+	id := q.ids[from]
+	q.ids = append(q.ids[:from], q.ids[from+1:]...)
+	q.ids = append(q.ids[:to], append([]string{id}, q.ids[to:]...)...)
+	q.version++
+
+	moved := func(i int) int {
+		switch {
+		case i == from:
+			return to
+		case from < i && i <= to:
+			return i - 1
+		case to <= i && i < from:
+			return i + 1
+		}
+		return i
+	}
+	if q.current >= 0 {
+		q.current = moved(q.current)
+	}
+	q.remap(moved)
+	return nil
+}
+
+// Clear removes all entries
+func (q *Queue) Clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ids = nil
+	q.current = -1
+	q.order = nil
+	q.pos = -1
+	q.version++
+}
+
+// Len returns the number of entries
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.ids)
+}
+
+// Version returns a counter that changes whenever entries are added,
+// removed or moved, so callers can tell whether their copy is stale
+func (q *Queue) Version() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.version
+}
+
+// IDs returns a copy of the queued track IDs in play order
+func (q *Queue) IDs() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string{}, q.ids...)
+}
+
+// Current returns the current track ID and its index, or ("", -1)
+func (q *Queue) Current() (string, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current < 0 || q.current >= len(q.ids) {
+		return "", -1
+	}
+	return q.ids[q.current], q.current
+}
+
+// SetCurrent moves the current pointer to index i. When shuffling, the
+// entry is recorded in the play history and won't come up again this pass.
+func (q *Queue) SetCurrent(i int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.checkIndex(i); err != nil {
+		return err
+	}
+	if q.shuffle {
+		for j := q.pos + 1; j < len(q.order); j++ {
+			if q.order[j] == i {
+				q.order = append(q.order[:j], q.order[j+1:]...)
+				break
+			}
+		}
+		q.pos++
+		q.order = insertAt(q.order, q.pos, i)
+	}
+	q.current = i
+	return nil
+}
+
+// Repeat returns the repeat mode
+func (q *Queue) Repeat() RepeatMode {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.repeat
+}
+
+// SetRepeat sets the repeat mode
+func (q *Queue) SetRepeat(m RepeatMode) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.repeat = m
+}
+
+// Shuffle reports whether shuffle is on and the seed it was started with
+func (q *Queue) Shuffle() (bool, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.shuffle, q.seed
+}
+
+// SetShuffle turns shuffle on or off. Turning it on starts a new shuffled
+// pass from the current entry; the same seed and queue give the same order.
+func (q *Queue) SetShuffle(on bool, seed int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.shuffle = on
+	q.order = nil
+	q.pos = -1
+	if !on {
+		return
+	}
+
+	q.seed = seed
+	q.rng = rand.New(rand.NewSource(seed))
+	if q.current >= 0 {
+		// The current entry counts as played in the first pass
+		q.order = append(q.order, q.current)
+		q.pos = 0
+	}
+	var rest []int
+	for i := range q.ids {
+		if i != q.current {
+			rest = append(rest, i)
+		}
+	}
+	q.order = append(q.order, q.shuffled(rest)...)
+}
+
+// ShuffleOrder returns a copy of the shuffled play order, history first,
+// and the position of the current entry in it. It returns nil and -1 when
+// shuffle is off.
+func (q *Queue) ShuffleOrder() ([]int, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.shuffle {
+		return nil, -1
+	}
+	return slices.Clone(q.order), q.pos
+}
+
+// ResumeShuffle turns shuffle on with a play order from ShuffleOrder, so
+// the history and the rest of the pass carry on where they left off. The
+// current entry becomes the one at pos. Later passes are shuffled from
+// seed, so they differ from those of the queue the order was taken from.
+// If the order does not fit the entries the queue is left unchanged.
+func (q *Queue) ResumeShuffle(seed int64, order []int, pos int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if pos < -1 || pos >= len(order) {
+		return fmt.Errorf("shuffle position %d out of range", pos)
+	}
+	for _, i := range order {
+		if err := q.checkIndex(i); err != nil {
+			return err
+		}
+	}
+	q.shuffle = true
+	q.seed = seed
+	q.rng = rand.New(rand.NewSource(seed))
+	q.order = slices.Clone(order)
+	q.pos = pos
+	q.current = -1
+	if pos >= 0 {
+		q.current = order[pos]
+	}
+	return nil
+}
+
+// PeekNext returns the entry that plays when the current one finishes,
+// without moving the pointer. With RepeatOne that is the current entry.
+func (q *Queue) PeekNext() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if id, ok := q.repeatOne(); ok {
+		return id, true
+	}
+	return q.step(false)
+}
+
+// Advance moves to the entry that plays when the current one finishes,
+// as reported by PeekNext
+func (q *Queue) Advance() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if id, ok := q.repeatOne(); ok {
+		return id, true
+	}
+	return q.step(true)
+}
+
+// Next advances to the following entry and returns it, regardless of
+// RepeatOne. At the end of the queue it only wraps around with RepeatAll.
+func (q *Queue) Next() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.step(true)
+}
+
+// Prev steps back to the previous entry and returns it. When shuffling it
+// goes back through the entries in the order they were played.
+func (q *Queue) Prev() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.shuffle {
+		if q.pos <= 0 {
+			return "", false
+		}
+		q.pos--
+		q.current = q.order[q.pos]
+		return q.ids[q.current], true
+	}
+
+	if len(q.ids) == 0 {
+		return "", false
+	}
+	prev := q.current - 1
+	if prev < 0 {
+		if q.repeat != RepeatAll {
+			return "", false
+		}
+		prev = len(q.ids) - 1
+	}
+	q.current = prev
+	return q.ids[prev], true
+}
+
+// Prune drops entries whose tracks no longer exist, e.g. after a rescan,
+// and returns how many were removed
+func (q *Queue) Prune(exists func(id string) bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	kept := q.ids[:0]
+	removed := 0
+	current := q.current
+	index := make([]int, len(q.ids)) // New index of each entry, -1 if dropped
+	for i, id := range q.ids {
+		if exists(id) {
+			index[i] = len(kept)
+			kept = append(kept, id)
+			continue
+		}
+		index[i] = -1
+		removed++
+		if i <= q.current {
+			current--
+		}
+	}
+	q.ids = kept
+	q.current = current
+	q.remap(func(i int) int { return index[i] })
+	if removed > 0 {
+		q.version++
+	}
+	return removed
+}
+
+// repeatOne returns the current entry if it is to be played again;
+// callers must hold q.mu
+func (q *Queue) repeatOne() (string, bool) {
+	if q.repeat != RepeatOne || q.current < 0 || q.current >= len(q.ids) {
+		return "", false
+	}
+	return q.ids[q.current], true
+}
+
+// step returns the entry after the current one and moves to it if move is
+// set. With RepeatAll it wraps around, starting a new pass when shuffling.
+// Callers must hold q.mu.
+func (q *Queue) step(move bool) (string, bool) {
+	if len(q.ids) == 0 {
+		return "", false
+	}
+
+	if q.shuffle {
+		if q.pos+1 >= len(q.order) {
+			if q.repeat != RepeatAll {
+				return "", false
+			}
+			q.order = append(q.order, q.newPass()...)
+		}
+		next := q.order[q.pos+1]
+		if move {
+			q.pos++
+			q.current = next
+		}
+		return q.ids[next], true
+	}
+
+	next := q.current + 1
+	if next >= len(q.ids) {
+		if q.repeat != RepeatAll {
+			return "", false
+		}
+		next = 0
+	}
+	if move {
+		q.current = next
+	}
+	return q.ids[next], true
+}
+
+// newPass returns every entry in a fresh shuffled order, not starting with
+// the entry that was just played; callers must hold q.mu
+func (q *Queue) newPass() []int {
+	pass := make([]int, len(q.ids))
+	for i := range pass {
+		pass[i] = i
+	}
+	pass = q.shuffled(pass)
+	if len(pass) > 1 && pass[0] == q.current {
+		pass[0], pass[len(pass)-1] = pass[len(pass)-1], pass[0]
+	}
+	return pass
+}
+
+// shuffled shuffles idx in place with a Fisher–Yates pass; callers must hold q.mu
+func (q *Queue) shuffled(idx []int) []int {
+	for i := len(idx) - 1; i > 0; i-- {
+		j := q.rng.Intn(i + 1)
+		idx[i], idx[j] = idx[j], idx[i]
+	}
+	return idx
+}
+
+// remap rewrites the shuffled play order after entries were moved or
+// removed. f maps an old entry index to its new one, or -1 if the entry is
+// gone. Callers must hold q.mu.
+func (q *Queue) remap(f func(int) int) {
+	if !q.shuffle {
+		return
+	}
+
+	kept := q.order[:0]
+	pos := q.pos
+	for i, idx := range q.order {
+		if n := f(idx); n >= 0 {
+			kept = append(kept, n)
+			continue
+		}
+		if i <= q.pos {
+			pos--
+		}
+	}
+	q.order = kept
+	q.pos = pos
+	q.current = -1
+	if pos >= 0 {
+		q.current = q.order[pos]
+	}
+}
+
+// insertAt inserts v into s at index i
+func insertAt(s []int, i, v int) []int {
+	s = append(s, 0)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+// checkIndex validates an entry index; callers must hold q.mu
+func (q *Queue) checkIndex(i int) error {
+	if i < 0 || i >= len(q.ids) {
+		return fmt.Errorf("queue index %d out of range (0-%d)", i, len(q.ids)-1)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package playlist
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// newShuffled returns a queue of n entries "a", "b", ... that does not
+// repeat, currently on "a" and shuffled with seed
+func newShuffled(t *testing.T, n int, seed int64) *Queue {
+	t.Helper()
+	q := NewQueue()
+	q.SetRepeat(RepeatOff)
+	for i := range n {
+		q.Enqueue(string(rune('a' + i)))
+	}
+	if err := q.SetCurrent(0); err != nil {
+		t.Fatal(err)
+	}
+	q.SetShuffle(true, seed)
+	return q
+}
+
+// play advances n times and returns the entries played, starting with the
+// current one
+func play(t *testing.T, q *Queue, n int) []string {
+	t.Helper()
+	id, _ := q.Current()
+	played := []string{id}
+	for range n {
+		id, ok := q.Next()
+		if !ok {
+			t.Fatalf("queue ended after %v", played)
+		}
+		played = append(played, id)
+	}
+	return played
+}
+
+// checkPass plays the rest of the pass and checks that, with the entries
+// played so far, it covers every entry exactly once, and that Prev then
+// walks back through all of them
+func checkPass(t *testing.T, q *Queue, played []string) {
+	t.Helper()
+	ids := q.IDs()
+	history := slices.DeleteFunc(slices.Clone(played), func(id string) bool {
+		return !slices.Contains(ids, id)
+	})
+	all := slices.Clone(history)
+	for {
+		id, ok := q.Next()
+		if !ok {
+			break
+		}
+		all = append(all, id)
+	}
+	if got, want := slices.Sorted(slices.Values(all)), slices.Sorted(slices.Values(ids)); !slices.Equal(got, want) {
+		t.Fatalf("pass played %v, want each of %v once", all, ids)
+	}
+
+	for i := len(all) - 2; i >= 0; i-- {
+		id, ok := q.Prev()
+		if !ok || id != all[i] {
+			t.Fatalf("Prev = %q, %v after retracing %v, want %q", id, ok, all[i+1:], all[i])
+		}
+	}
+	if id, ok := q.Prev(); ok {
+		t.Fatalf("Prev = %q at the start of the history", id)
+	}
+}
+
+func TestShuffleSeedIsReproducible(t *testing.T) {
+	for _, seed := range []int64{1, 42, -7} {
+		a := play(t, newShuffled(t, 10, seed), 9)
+		b := play(t, newShuffled(t, 10, seed), 9)
+		if !slices.Equal(a, b) {
+			t.Errorf("seed %d: orders %v and %v differ", seed, a, b)
+		}
+	}
+}
+
+func TestShufflePassesDoNotRepeat(t *testing.T) {
+	const n = 8
+	q := newShuffled(t, n, 3)
+	q.SetRepeat(RepeatAll)
+
+	played := play(t, q, 4*n-1)
+	for pass := range 4 {
+		got := slices.Sorted(slices.Values(played[pass*n : (pass+1)*n]))
+		if want := q.IDs(); !slices.Equal(got, want) {
+			t.Errorf("pass %d played %v, want each of %v once", pass, played[pass*n:(pass+1)*n], want)
+		}
+	}
+	for i := 1; i < len(played); i++ {
+		if played[i] == played[i-1] {
+			t.Errorf("%q played twice in a row at %d: %v", played[i], i, played)
+		}
+	}
+}
+
+func TestShufflePrevRetracesHistory(t *testing.T) {
+	const n = 6
+	q := newShuffled(t, n, 11)
+	q.SetRepeat(RepeatAll)
+
+	// Cross into the second pass so Prev has to walk back over its start
+	played := play(t, q, n+2)
+	for i := len(played) - 2; i >= 0; i-- {
+		id, ok := q.Prev()
+		if !ok || id != played[i] {
+			t.Fatalf("Prev = %q, %v, want %q (history %v)", id, ok, played[i], played)
+		}
+	}
+	if id, ok := q.Prev(); ok {
+		t.Fatalf("Prev = %q before the first entry played", id)
+	}
+
+	// Going forward again replays the same entries
+	if again := play(t, q, len(played)-1); !slices.Equal(again, played) {
+		t.Fatalf("replayed %v, want %v", again, played)
+	}
+}
+
+func TestShuffleEdits(t *testing.T) {
+	tests := []struct {
+		name         string
+		edit         func(t *testing.T, q *Queue, played []string)
+		next         []string // Entries expected to play next
+		keepsCurrent bool
+	}{
+		{
+			name: "remove unplayed",
+			edit: func(t *testing.T, q *Queue, played []string) {
+				for i, id := range q.IDs() {
+					if !slices.Contains(played, id) {
+						mustDo(t, q.Remove(i))
+						return
+					}
+				}
+			},
+			keepsCurrent: true,
+		},
+		{
+			name: "remove played",
+			edit: func(t *testing.T, q *Queue, played []string) {
+				mustDo(t, q.Remove(slices.Index(q.IDs(), played[0])))
+			},
+			keepsCurrent: true,
+		},
+		{
+			name: "remove current",
+			edit: func(t *testing.T, q *Queue, played []string) {
+				_, i := q.Current()
+				mustDo(t, q.Remove(i))
+			},
+		},
+		{
+			name: "move current to the end",
+			edit: func(t *testing.T, q *Queue, played []string) {
+				_, i := q.Current()
+				mustDo(t, q.Move(i, q.Len()-1))
+			},
+			keepsCurrent: true,
+		},
+		{
+			name: "move last to the front",
+			edit: func(t *testing.T, q *Queue, played []string) {
+				mustDo(t, q.Move(q.Len()-1, 0))
+			},
+			keepsCurrent: true,
+		},
+		{
+			name: "insert next",
+			edit: func(t *testing.T, q *Queue, played []string) {
+				q.InsertNext("x", "y")
+			},
+			next:         []string{"x", "y"},
+			keepsCurrent: true,
+		},
+		{
+			name: "enqueue",
+			edit: func(t *testing.T, q *Queue, played []string) {
+				q.Enqueue("x", "y")
+			},
+			keepsCurrent: true,
+		},
+		{
+			name: "prune after a rescan",
+			edit: func(t *testing.T, q *Queue, played []string) {
+				// One entry that was played and one that was not
+				gone := []string{played[1]}
+				for _, id := range q.IDs() {
+					if !slices.Contains(played, id) {
+						gone = append(gone, id)
+						break
+					}
+				}
+				if removed := q.Prune(func(id string) bool { return !slices.Contains(gone, id) }); removed != 2 {
+					t.Fatalf("Prune removed %d entries, want 2", removed)
+				}
+			},
+			keepsCurrent: true,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, seed := range []int64{1, 2, 3, 4, 5} {
+			t.Run(fmt.Sprintf("%s/seed=%d", tt.name, seed), func(t *testing.T) {
+				q := newShuffled(t, 10, seed)
+				played := play(t, q, 3)
+				current, _ := q.Current()
+
+				tt.edit(t, q, played)
+
+				if id, _ := q.Current(); tt.keepsCurrent && id != current {
+					t.Fatalf("current is %q, want %q", id, current)
+				}
+				for _, want := range tt.next {
+					id, ok := q.Next()
+					if !ok || id != want {
+						t.Fatalf("Next = %q, %v, want %q", id, ok, want)
+					}
+					played = append(played, id)
+				}
+				checkPass(t, q, played)
+			})
+		}
+	}
+}
+
+func TestResumeShuffle(t *testing.T) {
+	q := newShuffled(t, 8, 5)
+	played := play(t, q, 3)
+	order, pos := q.ShuffleOrder()
+
+	// A queue rebuilt from the same entries, as when a session is restored
+	r := NewQueue()
+	r.SetRepeat(RepeatOff)
+	r.Enqueue(q.IDs()...)
+	mustDo(t, r.ResumeShuffle(99, order, pos))
+
+	if id, _ := r.Current(); id != played[len(played)-1] {
+		t.Fatalf("current is %q, want %q", id, played[len(played)-1])
+	}
+	var want, got []string
+	for {
+		id, ok := q.Next()
+		if !ok {
+			break
+		}
+		want = append(want, id)
+	}
+	for {
+		id, ok := r.Next()
+		if !ok {
+			break
+		}
+		got = append(got, id)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("resumed pass plays %v, want %v", got, want)
+	}
+	checkPass(t, r, append(played, got...))
+
+	if err := r.ResumeShuffle(1, []int{0, 8}, 0); err == nil {
+		t.Error("ResumeShuffle accepted an entry index out of range")
+	}
+	if err := r.ResumeShuffle(1, []int{0, 1}, 2); err == nil {
+		t.Error("ResumeShuffle accepted a position out of range")
+	}
+	if order, pos := NewQueue().ShuffleOrder(); order != nil || pos != -1 {
+		t.Errorf("ShuffleOrder without shuffle = %v, %d, want nil, -1", order, pos)
+	}
+}
+
+func mustDo(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
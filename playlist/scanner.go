@@ -1,28 +1,43 @@
 package playlist
 
 import (
-	"crypto/md5"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"perth/player"
 )
 
-// Scanner manages the scanning and caching of audio files
+// Scanner manages the scanning and caching of audio files. It is safe
+// for concurrent use: tracks can be looked up while a scan runs, and see
+// the tracks as the scan replaces them.
 type Scanner struct {
-	cachePath  string            // JSON cache file path
-	tracks     []*Track          // In-memory track list
-	lastScan   time.Time         // Last scan timestamp
-	fileHashes map[string]string // Path -> hash for change detection
+	// scanMu is held while the scanner is configured, scans or updates, so
+	// one of them runs at a time. mu guards the tracks and the configuration
+	// against readers; they are only replaced with both held, so the scans
+	// read them without mu.
+	scanMu sync.Mutex
+	mu     sync.RWMutex
+
+	cachePath    string                 // JSON cache file path, "" to keep nothing on disk
+	tracks       []*Track               // In-memory track list, only changed through setTracks
+	byID         map[string]*Track      // Track ID -> track
+	byPath       map[string]*Track      // Absolute path -> track
+	lastScan     time.Time              // Last scan timestamp
+	fingerprints map[string]fingerprint // Path -> fingerprint for change detection
 
 	// Configuration
-	scanPaths  []string        // Directories to scan
+	scanPaths  []string        // Directories and audio files to scan
 	extensions map[string]bool // Supported audio extensions
+	exclude    []string        // Glob patterns of names and paths to skip
+	hash       HashMode        // How much of each file to hash for change detection
+	workers    int             // Files read at once
 }
 
 // ScanResult contains the result of a scan operation
@@ -36,30 +51,101 @@ type ScanResult struct {
 	ScanTime      time.Time `json:"scan_time"`
 }
 
+// ScannerOptions configures a Scanner
+type ScannerOptions struct {
+	CachePath  string   // Cache file, "" for the default location
+	NoCache    bool     // Neither read nor write a cache, e.g. for one-off scans
+	Exclude    []string // Glob patterns matched against file and directory names, and whole paths
+	Extensions []string // Extensions to scan, every playable one if empty
+	Hash       HashMode // Content hashing on top of size, modification time and inode
+	Workers    int      // Files read at once, the number of CPUs if 0
+}
+
+// ScanProgress tells how far a scan is
+type ScanProgress struct {
+	Seen      int    // Audio files found so far
+	Processed int    // Files checked or read so far
+	Errors    int    // Files and directories that could not be read so far
+	Path      string // File processed last
+}
+
 // NewScanner creates a new Scanner instance
 func NewScanner(scanPaths []string) *Scanner {
+	return NewScannerWithOptions(scanPaths, ScannerOptions{})
+}
+
+// NewScannerWithOptions creates a Scanner for the given directories and
+// audio files
+func NewScannerWithOptions(scanPaths []string, opts ScannerOptions) *Scanner {
+	s := &Scanner{
+		fingerprints: make(map[string]fingerprint),
+	}
+	s.setTracks([]*Track{})
+	s.Configure(scanPaths, opts)
+	return s
+}
+
+// Configure changes what the scanner scans and where it keeps its cache.
+// The tracks are brought in line with the next scan.
+func (s *Scanner) Configure(scanPaths []string, opts ScannerOptions) {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
 	if len(scanPaths) == 0 {
 		scanPaths = []string{"assets"}
 	}
 
-	// Determine cache path (global vs local)
-	cachePath := getCachePath()
+	cachePath := opts.CachePath
+	if opts.NoCache {
+		cachePath = ""
+	} else if cachePath == "" {
+		cachePath = DefaultCachePath()
+	}
+
+	// Supported extensions come from the player's decoder registry
+	extensions := make(map[string]bool)
+	for _, ext := range player.Extensions() {
+		extensions[ext] = len(opts.Extensions) == 0
+	}
+	for _, ext := range opts.Extensions {
+		ext = strings.ToLower(ext)
+		if _, ok := extensions[ext]; ok {
+			extensions[ext] = true
+		}
+	}
 
-	return &Scanner{
-		cachePath:  cachePath,
-		tracks:     []*Track{},
-		fileHashes: make(map[string]string),
-		scanPaths:  scanPaths,
-		extensions: map[string]bool{
-			".mp3":  true,
-			".wav":  true,
-			".flac": true,
-		},
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
 	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cachePath = cachePath
+	s.scanPaths = scanPaths
+	s.extensions = extensions
+	s.exclude = opts.Exclude
+	s.hash = opts.Hash
+	s.workers = workers
 }
 
 // Scan performs a full scan of the configured directories
 func (s *Scanner) Scan() (*ScanResult, error) {
+	return s.ScanContext(context.Background(), nil)
+}
+
+// ScanContext performs a full scan like Scan, reading files on a pool of
+// workers. progress, if not nil, is called on the calling goroutine each
+// time a file is done. When ctx is cancelled the scan stops early: the
+// tracks read so far are kept and cached, and ctx.Err() is returned.
+func (s *Scanner) ScanContext(ctx context.Context, progress func(ScanProgress)) (*ScanResult, error) {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	return s.scan(ctx, progress)
+}
+
+// scan performs a full scan; callers must hold s.scanMu
+func (s *Scanner) scan(ctx context.Context, progress func(ScanProgress)) (*ScanResult, error) {
 	result := &ScanResult{
 		ScanTime: time.Now(),
 		Errors:   []string{},
@@ -71,10 +157,11 @@ func (s *Scanner) Scan() (*ScanResult, error) {
 	}
 
 	// Scan all configured paths
-	for _, path := range s.scanPaths {
-		if err := s.scanDirectory(path, result); err != nil {
-			result.Errors = append(result.Errors, fmt.Sprintf("Failed to scan %s: %v", path, err))
-		}
+	s.scanFiles(ctx, s.scanPaths, result, progress)
+	if err := ctx.Err(); err != nil {
+		// Whatever was read need not be read again next time
+		_ = s.saveCache()
+		return nil, err
 	}
 
 	// Remove tracks that no longer exist
@@ -94,6 +181,9 @@ func (s *Scanner) Scan() (*ScanResult, error) {
 
 // IncrementalScan performs a quick scan to check for changes
 func (s *Scanner) IncrementalScan() (*ScanResult, error) {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
 	result := &ScanResult{
 		ScanTime: time.Now(),
 		Errors:   []string{},
@@ -102,7 +192,7 @@ func (s *Scanner) IncrementalScan() (*ScanResult, error) {
 	// Load existing cache
 	if err := s.loadCache(); err != nil {
 		// If cache is corrupted, fall back to full scan
-		return s.Scan()
+		return s.scan(context.Background(), nil)
 	}
 
 	// Check for changes in existing files
@@ -117,7 +207,7 @@ func (s *Scanner) IncrementalScan() (*ScanResult, error) {
 
 	if changed {
 		// Perform full scan if changes detected
-		return s.Scan()
+		return s.scan(context.Background(), nil)
 	}
 
 	// Check for new files
@@ -137,123 +227,63 @@ func (s *Scanner) IncrementalScan() (*ScanResult, error) {
 	return result, nil
 }
 
-// scanDirectory scans a single directory for audio files
-func (s *Scanner) scanDirectory(dirPath string, result *ScanResult) error {
-	entries, err := os.ReadDir(dirPath)
-	if err != nil {
-		return fmt.Errorf("failed to read directory %s: %w", dirPath, err)
-	}
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			// Recursively scan subdirectories
-			subPath := filepath.Join(dirPath, entry.Name())
-			if err := s.scanDirectory(subPath, result); err != nil {
-				result.Errors = append(result.Errors, fmt.Sprintf("Failed to scan subdirectory %s: %v", subPath, err))
-			}
-			continue
-		}
-
-		// Check if it's an audio file
-		ext := strings.ToLower(filepath.Ext(entry.Name()))
-		if !s.extensions[ext] {
-			continue
-		}
-
-		// Process audio file
-		if err := s.processAudioFile(dirPath, entry, result); err != nil {
-			result.Errors = append(result.Errors, fmt.Sprintf("Failed to process %s: %v", entry.Name(), err))
-		}
-	}
-
-	return nil
-}
-
-// processAudioFile processes a single audio file
-func (s *Scanner) processAudioFile(dirPath string, entry os.DirEntry, result *ScanResult) error {
-	fullPath := filepath.Join(dirPath, entry.Name())
-
-	// Check if file already exists in cache
-	existingTrack := s.findTrackByPath(fullPath)
-	if existingTrack != nil {
-		// Check if file has changed
-		if !s.hasFileChanged(fullPath) {
-			return nil // No changes
-		}
-
-		// File changed, update track
-		if err := s.updateTrack(existingTrack, fullPath); err != nil {
-			return fmt.Errorf("failed to update track: %w", err)
-		}
-		result.UpdatedTracks++
-		return nil
-	}
-
-	// New file, create track
-	track, err := s.createTrack(fullPath)
+// createTrack creates a new Track from a file path
+func (s *Scanner) createTrack(filePath string) (*Track, error) {
+	track, fp, err := readTrack(filePath, s.hash)
 	if err != nil {
-		return fmt.Errorf("failed to create track: %w", err)
+		return nil, err
 	}
 
-	s.tracks = append(s.tracks, track)
-	result.NewTracks++
+	// Store fingerprint for change detection
+	s.fingerprints[filePath] = fp
 
-	return nil
+	return track, nil
 }
 
-// createTrack creates a new Track from a file path
-func (s *Scanner) createTrack(filePath string) (*Track, error) {
+// readTrack reads the duration and tags of a file, along with the
+// fingerprint of what was read
+func readTrack(filePath string, hash HashMode) (*Track, fingerprint, error) {
 	// Get file info
 	info, err := os.Stat(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to stat file: %w", err)
+		return nil, fingerprint{}, fmt.Errorf("failed to stat file: %w", err)
 	}
 
 	// Get duration using player decoder
-	duration, err := s.getAudioDuration(filePath)
+	duration, err := audioDuration(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get duration: %w", err)
+		return nil, fingerprint{}, fmt.Errorf("failed to get duration: %w", err)
 	}
 
-	// Create track
+	// Create track, reading its tags while the file is at hand
 	track := NewTrack(filePath, duration, info.Size(), info.ModTime())
+	track.loadMetadata()
 
-	// Store file hash for change detection
-	s.fileHashes[filePath] = s.calculateFileHash(filePath)
-
-	return track, nil
+	fp, err := takeFingerprint(filePath, hash)
+	if err != nil {
+		return nil, fingerprint{}, fmt.Errorf("failed to fingerprint file: %w", err)
+	}
+	return track, fp, nil
 }
 
-// updateTrack updates an existing track with new file information
-func (s *Scanner) updateTrack(track *Track, filePath string) error {
-	// Get file info
+// LoadTrack reads a single audio file outside of any library
+func LoadTrack(filePath string) (*Track, error) {
+	if !player.Supported(filePath) {
+		return nil, fmt.Errorf("unsupported audio format: %s", filepath.Ext(filePath))
+	}
 	info, err := os.Stat(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to stat file: %w", err)
+		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
-
-	// Get duration
-	duration, err := s.getAudioDuration(filePath)
+	duration, err := audioDuration(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to get duration: %w", err)
+		return nil, fmt.Errorf("failed to get duration: %w", err)
 	}
-
-	// Update track
-	track.Duration = duration
-	track.Size = info.Size()
-	track.Modified = info.ModTime()
-
-	// Reset metadata to force reload
-	track.metadata.Loaded = false
-
-	// Update file hash
-	s.fileHashes[filePath] = s.calculateFileHash(filePath)
-
-	return nil
+	return NewTrack(filePath, duration, info.Size(), info.ModTime()), nil
 }
 
-// getAudioDuration gets the duration of an audio file
-func (s *Scanner) getAudioDuration(filePath string) (time.Duration, error) {
+// audioDuration gets the duration of an audio file
+func audioDuration(filePath string) (time.Duration, error) {
 	// Use player decoder to get duration
 	stream, format, err := player.Open(filePath)
 	if err != nil {
@@ -274,64 +304,66 @@ func (s *Scanner) getAudioDuration(filePath string) (time.Duration, error) {
 	return 0, nil
 }
 
-// hasFileChanged checks if a file has changed since last scan
+// hasFileChanged checks if a file has changed since last scan. Hashes the
+// last fingerprint lacked are recorded for unchanged files.
 func (s *Scanner) hasFileChanged(filePath string) bool {
-	currentHash := s.calculateFileHash(filePath)
-	lastHash, exists := s.fileHashes[filePath]
-
+	last, exists := s.fingerprints[filePath]
 	if !exists {
 		return true // New file
 	}
 
-	return currentHash != lastHash
-}
-
-// calculateFileHash calculates MD5 hash of a file
-func (s *Scanner) calculateFileHash(filePath string) string {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return ""
-	}
-	defer file.Close()
-
-	hash := md5.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return ""
+	fp, err := takeFingerprint(filePath, s.hash)
+	if err != nil || fp.changedFrom(last) {
+		return true
 	}
+	s.fingerprints[filePath] = fp
+	return false
+}
 
-	return fmt.Sprintf("%x", hash.Sum(nil))
+// setTracks replaces the tracks and indexes them by ID and by path
+func (s *Scanner) setTracks(tracks []*Track) {
+	byID := make(map[string]*Track, len(tracks))
+	byPath := make(map[string]*Track, len(tracks))
+	wd, _ := os.Getwd()
+	for _, track := range tracks {
+		byID[track.ID] = track
+		byPath[absPath(wd, track.Path)] = track
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracks, s.byID, s.byPath = tracks, byID, byPath
 }
 
-// findTrackByPath finds a track by its file path
-func (s *Scanner) findTrackByPath(filePath string) *Track {
-	for _, track := range s.tracks {
-		if track.Path == filePath {
-			return track
-		}
+// absPath returns path made absolute against the working directory wd,
+// which is cheaper than filepath.Abs for many paths at once
+func absPath(wd, path string) string {
+	if filepath.IsAbs(path) || wd == "" {
+		return filepath.Clean(path)
 	}
-	return nil
+	return filepath.Join(wd, path)
 }
 
-// removeDeletedTracks removes tracks for files that no longer exist
+// removeDeletedTracks removes tracks for files that no longer exist, or
+// that the configuration no longer includes
 func (s *Scanner) removeDeletedTracks(result *ScanResult) {
 	var remainingTracks []*Track
 
 	for _, track := range s.tracks {
-		if _, err := os.Stat(track.Path); os.IsNotExist(err) {
-			// File deleted, remove from cache
-			delete(s.fileHashes, track.Path)
+		if _, err := os.Stat(track.Path); os.IsNotExist(err) || !s.included(track.Path) {
+			// File deleted or no longer part of the library, remove from cache
+			delete(s.fingerprints, track.Path)
 			result.RemovedTracks++
 		} else {
 			remainingTracks = append(remainingTracks, track)
 		}
 	}
 
-	s.tracks = remainingTracks
+	s.setTracks(remainingTracks)
 }
 
 // scanForNewFiles scans for new files that weren't in the cache
 func (s *Scanner) scanForNewFiles(result *ScanResult) int {
-	newCount := 0
+	var added []*Track
 
 	for _, path := range s.scanPaths {
 		entries, err := os.ReadDir(path)
@@ -345,46 +377,127 @@ func (s *Scanner) scanForNewFiles(result *ScanResult) int {
 			}
 
 			ext := strings.ToLower(filepath.Ext(entry.Name()))
-			if !s.extensions[ext] {
+			fullPath := filepath.Join(path, entry.Name())
+			if !s.extensions[ext] || s.excluded(fullPath) {
 				continue
 			}
 
-			fullPath := filepath.Join(path, entry.Name())
-			if s.findTrackByPath(fullPath) == nil {
+			if s.GetTrackByPath(fullPath) == nil {
 				// New file found
 				if track, err := s.createTrack(fullPath); err == nil {
-					s.tracks = append(s.tracks, track)
-					newCount++
+					added = append(added, track)
 				}
 			}
 		}
 	}
 
-	return newCount
+	if len(added) > 0 {
+		s.setTracks(append(s.tracks, added...))
+	}
+	return len(added)
+}
+
+// included reports whether a file belongs to the library as configured
+func (s *Scanner) included(path string) bool {
+	return s.extensions[strings.ToLower(filepath.Ext(path))] && s.underScanPath(path)
+}
+
+// underScanPath reports whether a file or directory is a scan path or
+// below one, and not excluded
+func (s *Scanner) underScanPath(path string) bool {
+	path = filepath.Clean(path)
+	for _, root := range s.scanPaths {
+		root = filepath.Clean(root)
+		if !within(path, root) {
+			continue
+		}
+		// Excluded directories exclude everything below them
+		for dir := path; ; dir = filepath.Dir(dir) {
+			if s.excluded(dir) {
+				return false
+			}
+			if dir == root || dir == filepath.Dir(dir) {
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// excluded reports whether the name or the whole path of a file or
+// directory matches an exclude pattern
+func (s *Scanner) excluded(path string) bool {
+	return matchesAny(s.exclude, path)
+}
+
+// matchesAny reports whether the name or the whole path of a file or
+// directory matches one of patterns
+func matchesAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether path is dir or below it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ScanPaths returns the directories and audio files the scanner scans
+func (s *Scanner) ScanPaths() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string{}, s.scanPaths...)
 }
 
 // GetTracks returns all tracks in the scanner
 func (s *Scanner) GetTracks() []*Track {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.tracks
 }
 
 // GetTrackByID returns a track by its ID
 func (s *Scanner) GetTrackByID(id string) *Track {
-	for _, track := range s.tracks {
-		if track.ID == id {
-			return track
-		}
-	}
-	return nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.byID[id]
 }
 
-// GetTrackByPath returns a track by its file path
+// GetTrackByPath returns the track of the file at path, which may be
+// relative to the working directory like the scan paths
 func (s *Scanner) GetTrackByPath(path string) *Track {
-	return s.findTrackByPath(path)
+	if path == "" {
+		return nil
+	}
+	wd, _ := os.Getwd()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.byPath[absPath(wd, path)]
+}
+
+// scanCache is the cache file of a Scanner. Tags are kept next to the
+// tracks so that listing the library does not open the audio files.
+type scanCache struct {
+	Tracks       []*Track               `json:"tracks"`
+	Fingerprints map[string]fingerprint `json:"fingerprints"`
+	Metadata     map[string]Metadata    `json:"metadata"` // Path -> tags
+	LastScan     time.Time              `json:"last_scan"`
 }
 
 // loadCache loads the track cache from disk
 func (s *Scanner) loadCache() error {
+	if s.cachePath == "" {
+		return nil
+	}
 	data, err := os.ReadFile(s.cachePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -393,18 +506,24 @@ func (s *Scanner) loadCache() error {
 		return fmt.Errorf("failed to read cache: %w", err)
 	}
 
-	var cache struct {
-		Tracks     []*Track          `json:"tracks"`
-		FileHashes map[string]string `json:"file_hashes"`
-		LastScan   time.Time         `json:"last_scan"`
-	}
-
+	var cache scanCache
 	if err := json.Unmarshal(data, &cache); err != nil {
 		return fmt.Errorf("failed to parse cache: %w", err)
 	}
 
-	s.tracks = cache.Tracks
-	s.fileHashes = cache.FileHashes
+	for _, track := range cache.Tracks {
+		// Older versions derived IDs from a weaker hash of the path
+		track.ID = generateID(track.Path)
+		if m, ok := cache.Metadata[track.Path]; ok {
+			track.setMetadata(m)
+		}
+	}
+	if cache.Fingerprints == nil {
+		// Caches of older versions have none, so their files are read again
+		cache.Fingerprints = make(map[string]fingerprint)
+	}
+	s.setTracks(cache.Tracks)
+	s.fingerprints = cache.Fingerprints
 	s.lastScan = cache.LastScan
 
 	return nil
@@ -412,20 +531,25 @@ func (s *Scanner) loadCache() error {
 
 // saveCache saves the track cache to disk
 func (s *Scanner) saveCache() error {
+	if s.cachePath == "" {
+		return nil
+	}
 	// Ensure cache directory exists
 	cacheDir := filepath.Dir(s.cachePath)
 	if err := os.MkdirAll(cacheDir, 0755); err != nil {
 		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
-	cache := struct {
-		Tracks     []*Track          `json:"tracks"`
-		FileHashes map[string]string `json:"file_hashes"`
-		LastScan   time.Time         `json:"last_scan"`
-	}{
-		Tracks:     s.tracks,
-		FileHashes: s.fileHashes,
-		LastScan:   time.Now(),
+	cache := scanCache{
+		Tracks:       s.tracks,
+		Fingerprints: s.fingerprints,
+		Metadata:     make(map[string]Metadata, len(s.tracks)),
+		LastScan:     time.Now(),
+	}
+	for _, track := range s.tracks {
+		if m := track.cachedMetadata(); m != nil {
+			cache.Metadata[track.Path] = *m
+		}
 	}
 
 	data, err := json.MarshalIndent(cache, "", "  ")
@@ -440,20 +564,11 @@ func (s *Scanner) saveCache() error {
 	return nil
 }
 
-// getCachePath determines the cache file path (global vs local)
-func getCachePath() string {
-	// Check if we're in a project directory
-	if _, err := os.Stat("go.mod"); err == nil {
-		// Project directory, use local cache
-		return ".perth/cache.json"
-	}
-
-	// Use global cache
-	homeDir, err := os.UserHomeDir()
+// DefaultCachePath returns cache.json in the user's cache directory
+func DefaultCachePath() string {
+	dir, err := os.UserCacheDir()
 	if err != nil {
-		// Fallback to current directory
-		return ".perth/cache.json"
+		return filepath.Join(".perth", "cache.json")
 	}
-
-	return filepath.Join(homeDir, ".perth", "cache.json")
+	return filepath.Join(dir, "perth", "cache.json")
 }
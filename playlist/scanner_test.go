@@ -0,0 +1,118 @@
+package playlist
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSilence writes a short 16-bit stereo WAV file of silence
+func writeSilence(t *testing.T, path string) {
+	t.Helper()
+	const rate, frames = 8000, 800
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 36+frames*4)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1)
+	binary.LittleEndian.PutUint16(h[22:], 2)
+	binary.LittleEndian.PutUint32(h[24:], rate)
+	binary.LittleEndian.PutUint32(h[28:], rate*4)
+	binary.LittleEndian.PutUint16(h[32:], 4)
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], frames*4)
+	if err := os.WriteFile(path, append(h, make([]byte, frames*4)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScannerLooksUpTracksByIDAndPath(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.wav"), filepath.Join(dir, "b.wav")
+	writeSilence(t, a)
+	writeSilence(t, b)
+
+	s := NewScannerWithOptions([]string{dir}, ScannerOptions{NoCache: true})
+	if _, err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{a, b} {
+		track := s.GetTrackByPath(path)
+		if track == nil {
+			t.Fatalf("no track for %s", path)
+		}
+		if got := s.GetTrackByID(track.ID); got != track {
+			t.Errorf("GetTrackByID(%s) = %v, want the track of %s", track.ID, got, path)
+		}
+	}
+
+	// The index follows the tracks when the watcher reports a removal
+	id := s.GetTrackByPath(a).ID
+	if err := os.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	s.Update([]string{a})
+	if track := s.GetTrackByID(id); track != nil {
+		t.Errorf("removed track is still found by ID: %v", track)
+	}
+	if track := s.GetTrackByPath(a); track != nil {
+		t.Errorf("removed track is still found by path: %v", track)
+	}
+	if s.GetTrackByPath(b) == nil {
+		t.Errorf("remaining track %s is no longer found", b)
+	}
+}
+
+func TestGetTrackByPathAcceptsRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	writeSilence(t, filepath.Join(dir, "a.wav"))
+	t.Chdir(dir)
+
+	s := NewScannerWithOptions([]string{"."}, ScannerOptions{NoCache: true})
+	if _, err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	rel := s.GetTrackByPath("a.wav")
+	if rel == nil {
+		t.Fatal("no track for a.wav")
+	}
+	if abs := s.GetTrackByPath(filepath.Join(dir, "a.wav")); abs != rel {
+		t.Errorf("absolute path finds %v, want %v", abs, rel)
+	}
+}
+
+func TestScannerServesLookupsDuringAScan(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.wav"), filepath.Join(dir, "b.wav")
+	writeSilence(t, a)
+
+	s := NewScannerWithOptions([]string{dir}, ScannerOptions{NoCache: true, Workers: 1})
+	if _, err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	known := s.GetTrackByPath(a)
+
+	// Progress runs while the scan holds the scanner, as a controller
+	// serving other requests would
+	writeSilence(t, b)
+	var calls int
+	_, err := s.ScanContext(context.Background(), func(ScanProgress) {
+		calls++
+		if s.GetTrackByID(known.ID) == nil || len(s.GetTracks()) == 0 || len(s.ScanPaths()) != 1 {
+			t.Error("tracks are not available while scanning")
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls == 0 {
+		t.Fatal("progress was never called")
+	}
+	if s.GetTrackByPath(b) == nil {
+		t.Errorf("no track for %s after the scan", b)
+	}
+}
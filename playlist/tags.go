@@ -0,0 +1,208 @@
+package playlist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// maxTagChunk limits the size of a WAV or AIFF chunk read for tags; ID3
+// chunks with cover art are the largest
+const maxTagChunk = 32 << 20
+
+// MetadataError is returned when the tags of a file cannot be read. Files
+// that have no tags at all are not an error.
+type MetadataError struct {
+	Path string
+	Err  error
+}
+
+func (e *MetadataError) Error() string {
+	return fmt.Sprintf("reading tags of %s: %v", e.Path, e.Err)
+}
+
+func (e *MetadataError) Unwrap() error {
+	return e.Err
+}
+
+// tags holds what Perth uses from a file's tags, whatever their format
+type tags struct {
+	title, artist, album, genre string
+	year, track                 int
+	picture                     *tag.Picture
+}
+
+// readTags reads the tags of an audio file. ID3, Vorbis comments, FLAC
+// and MP4 tags are read by dhowden/tag; WAV and AIFF files keep theirs in
+// chunks that are read here. A file without tags gives empty tags.
+func readTags(path string) (*tags, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, &MetadataError{Path: path, Err: err}
+	}
+	defer file.Close()
+
+	var t *tags
+	if order, ok := sniffIFF(file); ok {
+		t, err = readIFFTags(file, order)
+	} else {
+		var m tag.Metadata
+		m, err = tag.ReadFrom(file)
+		if errors.Is(err, tag.ErrNoTagsFound) {
+			return &tags{}, nil
+		}
+		if err == nil {
+			t = &tags{}
+			t.merge(m)
+		}
+	}
+	if err != nil {
+		return nil, &MetadataError{Path: path, Err: err}
+	}
+	return t, nil
+}
+
+// merge copies the fields m has over those of t
+func (t *tags) merge(m tag.Metadata) {
+	setString(&t.title, m.Title())
+	setString(&t.artist, m.Artist())
+	setString(&t.album, m.Album())
+	setString(&t.genre, m.Genre())
+	if year := m.Year(); year != 0 {
+		t.year = year
+	}
+	if track, _ := m.Track(); track != 0 {
+		t.track = track
+	}
+	if picture := m.Picture(); picture != nil && len(picture.Data) > 0 {
+		t.picture = picture
+	}
+}
+
+// setString sets *dst to src unless src is blank
+func setString(dst *string, src string) {
+	if src = strings.TrimSpace(src); src != "" {
+		*dst = src
+	}
+}
+
+// sniffIFF reports whether r is a WAV (RIFF) or AIFF (FORM) file and the
+// byte order of its chunk sizes. It leaves r after the 12-byte header, or
+// rewound if it is neither.
+func sniffIFF(r io.ReadSeeker) (binary.ByteOrder, bool) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err == nil {
+		form := string(header[8:12])
+		switch {
+		case string(header[:4]) == "RIFF" && form == "WAVE":
+			return binary.LittleEndian, true
+		case string(header[:4]) == "FORM" && (form == "AIFF" || form == "AIFC"):
+			return binary.BigEndian, true
+		}
+	}
+	_, _ = r.Seek(0, io.SeekStart)
+	return nil, false
+}
+
+// readIFFTags walks the chunks of a WAV or AIFF file. WAV files keep tags
+// in a LIST INFO chunk, AIFF files in NAME and AUTH chunks, and both may
+// have an ID3 chunk, which wins when fields are in both.
+func readIFFTags(r io.ReadSeeker, order binary.ByteOrder) (*tags, error) {
+	t := &tags{}
+	var id3 []byte
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			break // End of file, or a truncated chunk that cannot hold tags
+		}
+		id, size := string(header[:4]), int64(order.Uint32(header[4:]))
+		padded := size + size%2 // Chunks are padded to an even size
+
+		switch id {
+		case "LIST", "NAME", "AUTH", "id3 ", "ID3 ":
+			if size > maxTagChunk {
+				return nil, fmt.Errorf("%q chunk too large (%d bytes)", strings.TrimSpace(id), size)
+			}
+			data := make([]byte, padded)
+			n, err := io.ReadFull(r, data)
+			if err != nil && !(errors.Is(err, io.ErrUnexpectedEOF) && int64(n) >= size) {
+				return nil, fmt.Errorf("truncated %q chunk", strings.TrimSpace(id))
+			}
+			data = data[:size]
+
+			switch id {
+			case "LIST":
+				if bytes.HasPrefix(data, []byte("INFO")) {
+					t.readInfo(data[4:])
+				}
+			case "NAME":
+				t.title = iffString(data)
+			case "AUTH":
+				t.artist = iffString(data)
+			default:
+				id3 = data
+			}
+
+		default:
+			if _, err := r.Seek(padded, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if id3 != nil {
+		m, err := tag.ReadID3v2Tags(bytes.NewReader(id3))
+		if err != nil {
+			return nil, fmt.Errorf("ID3 chunk: %w", err)
+		}
+		t.merge(m)
+	}
+	return t, nil
+}
+
+// readInfo reads the sub-chunks of a RIFF INFO list, which always use
+// little-endian sizes
+func (t *tags) readInfo(data []byte) {
+	for len(data) >= 8 {
+		id, size := string(data[:4]), int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) {
+			return
+		}
+		value := iffString(data[:size])
+		data = data[min(size+size%2, len(data)):]
+
+		switch id {
+		case "INAM":
+			t.title = value
+		case "IART":
+			t.artist = value
+		case "IPRD":
+			t.album = value
+		case "IGNR":
+			t.genre = value
+		case "ICRD":
+			// Usually a year, sometimes a whole date
+			if len(value) >= 4 {
+				t.year, _ = strconv.Atoi(value[:4])
+			}
+		case "ITRK", "IPRT":
+			t.track, _ = strconv.Atoi(value)
+		}
+	}
+}
+
+// iffString returns the text of a chunk, which may end in NUL padding
+func iffString(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return strings.TrimSpace(string(data))
+}
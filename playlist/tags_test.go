@@ -0,0 +1,212 @@
+package playlist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// iffChunk builds a chunk with sizes in order, padded to an even length.
+// A negative size is taken from body.
+func iffChunk(order binary.ByteOrder, id string, size int, body []byte) []byte {
+	if size < 0 {
+		size = len(body)
+	}
+	b := append([]byte(id), make([]byte, 4)...)
+	order.PutUint32(b[4:], uint32(size))
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// iffFile builds a RIFF WAVE or FORM AIFF file from chunks
+func iffFile(order binary.ByteOrder, chunks ...[]byte) []byte {
+	magic, form := "RIFF", "WAVE"
+	if order == binary.BigEndian {
+		magic, form = "FORM", "AIFF"
+	}
+	body := []byte(form)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	b := append([]byte(magic), make([]byte, 4)...)
+	order.PutUint32(b[4:], uint32(len(body)))
+	return append(b, body...)
+}
+
+// info builds a LIST INFO chunk body from sub-chunk IDs and values
+func info(pairs ...string) []byte {
+	b := []byte("INFO")
+	for i := 0; i+1 < len(pairs); i += 2 {
+		b = append(b, iffChunk(binary.LittleEndian, pairs[i], -1, []byte(pairs[i+1]))...)
+	}
+	return b
+}
+
+// id3 builds an ID3v2.3 tag with text frames from frame IDs and values
+func id3(pairs ...string) []byte {
+	var frames []byte
+	for i := 0; i+1 < len(pairs); i += 2 {
+		body := append([]byte{0}, pairs[i+1]...) // ISO-8859-1
+		frame := append([]byte(pairs[i]), make([]byte, 6)...)
+		binary.BigEndian.PutUint32(frame[4:], uint32(len(body)))
+		frames = append(frames, append(frame, body...)...)
+	}
+	size := len(frames)
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(header, frames...)
+}
+
+// parseIFF reads the tags of an in-memory WAV or AIFF file
+func parseIFF(t *testing.T, file []byte) (*tags, error) {
+	t.Helper()
+	r := bytes.NewReader(file)
+	order, ok := sniffIFF(r)
+	if !ok {
+		t.Fatal("not recognised as a WAV or AIFF file")
+	}
+	return readIFFTags(r, order)
+}
+
+func TestReadIFFTags(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	data := iffChunk(le, "data", -1, make([]byte, 8))
+	ssnd := iffChunk(be, "SSND", -1, make([]byte, 8))
+
+	tests := []struct {
+		name string
+		file []byte
+		want tags
+	}{
+		{
+			name: "wav info",
+			file: iffFile(le,
+				iffChunk(le, "fmt ", -1, make([]byte, 16)),
+				iffChunk(le, "LIST", -1, info(
+					"INAM", "Title", // Odd length, padded
+					"IART", "Artist\x00",
+					"IPRD", " Album ",
+					"IGNR", "Jazz",
+					"ICRD", "2001-05-03",
+					"ITRK", "7")),
+				data),
+			want: tags{title: "Title", artist: "Artist", album: "Album", genre: "Jazz", year: 2001, track: 7},
+		},
+		{
+			name: "wav info after data",
+			file: iffFile(le, data, iffChunk(le, "LIST", -1, info("INAM", "Late"))),
+			want: tags{title: "Late"},
+		},
+		{
+			name: "wav info with an oversized entry",
+			file: iffFile(le, iffChunk(le, "LIST", -1, append(info("INAM", "Kept"), "IART\xff\x00\x00\x00abc"...))),
+			want: tags{title: "Kept"},
+		},
+		{
+			name: "wav list that is not info",
+			file: iffFile(le, iffChunk(le, "LIST", -1, []byte("adtlxxxx")), data),
+		},
+		{
+			// Both are read wherever they are in the file
+			name: "wav id3 wins over info",
+			file: iffFile(le,
+				iffChunk(le, "LIST", -1, info("INAM", "Info title", "IART", "Info artist")),
+				iffChunk(le, "id3 ", -1, id3("TIT2", "ID3 title", "TALB", "ID3 album")),
+				data),
+			want: tags{title: "ID3 title", artist: "Info artist", album: "ID3 album"},
+		},
+		{
+			name: "aiff name and auth",
+			file: iffFile(be, iffChunk(be, "NAME", -1, []byte("Songs")), iffChunk(be, "AUTH", -1, []byte("Band")), ssnd),
+			want: tags{title: "Songs", artist: "Band"},
+		},
+		{
+			name: "aiff id3",
+			file: iffFile(be, ssnd, iffChunk(be, "ID3 ", -1, id3("TIT2", "Tagged", "TPE1", "Someone", "TRCK", "3"))),
+			want: tags{title: "Tagged", artist: "Someone", track: 3},
+		},
+		{
+			name: "no tags",
+			file: iffFile(le, data),
+		},
+		{
+			// Only the pad byte is missing, which costs nothing
+			name: "missing pad byte",
+			file: bytes.TrimSuffix(iffFile(le, iffChunk(le, "LIST", -1, append(info("INAM", "Odd"), 'x'))), []byte{0}),
+			want: tags{title: "Odd"},
+		},
+		{
+			name: "truncated chunk header",
+			file: append(iffFile(le, iffChunk(le, "LIST", -1, info("INAM", "Kept"))), "da"...),
+			want: tags{title: "Kept"},
+		},
+		{
+			name: "other chunk past the end",
+			file: iffFile(le, iffChunk(le, "LIST", -1, info("INAM", "Kept")), iffChunk(le, "data", 1<<20, nil)),
+			want: tags{title: "Kept"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIFF(t, tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("tags = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadIFFTagsRejectsBadChunks(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"truncated list", iffFile(le, iffChunk(le, "LIST", 100, info("INAM", "Cut")))},
+		{"truncated name", iffFile(be, iffChunk(be, "NAME", 64, []byte("Cut")))},
+		{"oversized list", iffFile(le, iffChunk(le, "LIST", maxTagChunk+1, info("INAM", "Huge")))},
+		{"oversized id3", iffFile(be, iffChunk(be, "ID3 ", 0xffffffff, nil))},
+		{"corrupt id3", iffFile(le, iffChunk(le, "id3 ", -1, []byte("ID3 is not here")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseIFF(t, tt.file); err == nil {
+				t.Errorf("read %+v without an error", *got)
+			}
+		})
+	}
+}
+
+func TestReadTagsFromWAVFile(t *testing.T) {
+	le := binary.LittleEndian
+	path := filepath.Join(t.TempDir(), "tagged.wav")
+	file := iffFile(le, iffChunk(le, "LIST", -1, info("INAM", "On disk")), iffChunk(le, "data", -1, nil))
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readTags(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.title != "On disk" {
+		t.Errorf("title = %q, want %q", got.title, "On disk")
+	}
+
+	// Bad chunks are reported with the file they are in
+	if err := os.WriteFile(path, file[:20], 0644); err != nil {
+		t.Fatal(err)
+	}
+	var merr *MetadataError
+	if _, err := readTags(path); !errors.As(err, &merr) || merr.Path != path {
+		t.Errorf("reading a truncated LIST chunk = %v, want a MetadataError for %s", err, path)
+	}
+}
@@ -2,13 +2,13 @@ package playlist
 
 import (
 	"fmt"
-	"os"
+	"hash/fnv"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/dhowden/tag"
+	"perth/util"
 )
 
 // Track represents a single audio track in the playlist
@@ -21,9 +21,11 @@ type Track struct {
 	Size     int64         `json:"size"`     // File size in bytes
 	Modified time.Time     `json:"modified"` // Last modification time
 
-	// Lazy-loaded metadata
-	metadata   *Metadata    `json:"-"` // Pointer to avoid copying
-	metadataMu sync.RWMutex `json:"-"` // Thread-safe lazy loading
+	// Metadata is read when the track is scanned and kept in the scanner
+	// cache; tracks from elsewhere load it on first access
+	metadata    *Metadata    `json:"-"` // Pointer to avoid copying
+	metadataErr error        `json:"-"` // Why the tags could not be read, if they could not
+	metadataMu  sync.RWMutex `json:"-"` // Thread-safe lazy loading
 }
 
 // Metadata contains track metadata extracted from audio files
@@ -54,8 +56,9 @@ func NewTrack(path string, duration time.Duration, size int64, modified time.Tim
 	}
 }
 
-// DisplayName returns the best available display name for the track
+// DisplayName returns the tag title of the track, or its file name
 func (t *Track) DisplayName() string {
+	t.loadMetadata()
 	t.metadataMu.RLock()
 	defer t.metadataMu.RUnlock()
 
@@ -116,6 +119,17 @@ func (t *Track) HasMetadata() bool {
 	return t.metadata != nil && t.metadata.Loaded
 }
 
+// MetadataErr returns why the tags of the file could not be read, as a
+// *MetadataError, or nil. The track falls back to its file name then. The
+// error is not cached, so it is nil for tracks whose tags came from the
+// scanner cache.
+func (t *Track) MetadataErr() error {
+	t.loadMetadata()
+	t.metadataMu.RLock()
+	defer t.metadataMu.RUnlock()
+	return t.metadataErr
+}
+
 // loadMetadata loads metadata from the audio file if not already loaded
 func (t *Track) loadMetadata() {
 	t.metadataMu.RLock()
@@ -138,76 +152,82 @@ func (t *Track) loadMetadata() {
 		t.metadata = &Metadata{Loaded: false}
 	}
 
-	// Load metadata from file
-	if err := t.extractMetadata(); err != nil {
-		// Log error but don't fail - fall back to filename
-		fmt.Printf("Warning: Failed to extract metadata from %s: %v\n", t.Path, err)
+	// Load metadata from file; on failure the filename stands in for the title
+	t.metadataErr = t.extractMetadata()
+	t.metadata.Loaded = true
+}
+
+// cachedMetadata returns a copy of the loaded metadata for the scanner
+// cache, or nil if it was not loaded
+func (t *Track) cachedMetadata() *Metadata {
+	t.metadataMu.RLock()
+	defer t.metadataMu.RUnlock()
+	if t.metadata == nil || !t.metadata.Loaded {
+		return nil
 	}
+	m := *t.metadata
+	return &m
+}
 
-	t.metadata.Loaded = true
+// setMetadata sets metadata read from the scanner cache
+func (t *Track) setMetadata(m Metadata) {
+	t.metadataMu.Lock()
+	defer t.metadataMu.Unlock()
+	m.Loaded = true
+	t.metadata = &m
+	t.metadataErr = nil
 }
 
 // extractMetadata extracts metadata from the audio file
 func (t *Track) extractMetadata() error {
-	// Only support MP3 for now (can expand to other formats later)
-	if !strings.EqualFold(t.Format, ".mp3") {
-		return fmt.Errorf("metadata extraction not supported for format: %s", t.Format)
-	}
-
-	// Open and read metadata
-	file, err := os.Open(t.Path)
+	tags, err := readTags(t.Path)
 	if err != nil {
-		return fmt.Errorf("failed to open file for metadata: %w", err)
+		return err
 	}
-	defer file.Close()
 
-	// Read metadata using tag library
-	metadata, err := tag.ReadFrom(file)
-	if err != nil {
-		return fmt.Errorf("failed to read metadata: %w", err)
-	}
+	t.metadata.Title = tags.title
+	t.metadata.Artist = tags.artist
+	t.metadata.Album = tags.album
+	t.metadata.Genre = tags.genre
+	t.metadata.Year = tags.year
+	t.metadata.TrackNumber = tags.track
+	return nil
+}
 
-	// Extract metadata
-	if title := metadata.Title(); title != "" {
-		t.metadata.Title = title
-	}
-	if artist := metadata.Artist(); artist != "" {
-		t.metadata.Artist = artist
-	}
-	if album := metadata.Album(); album != "" {
-		t.metadata.Album = album
-	}
-	if genre := metadata.Genre(); genre != "" {
-		t.metadata.Genre = genre
-	}
-	if year := metadata.Year(); year != 0 {
-		t.metadata.Year = year
+// Cover is a picture embedded in a track's tags
+type Cover struct {
+	MIMEType string
+	Data     []byte
+}
+
+// Cover reads the cover art embedded in the file. It returns nil without
+// an error when the file has none. Pictures are not cached since they can
+// be large and are rarely needed.
+func (t *Track) Cover() (*Cover, error) {
+	tags, err := readTags(t.Path)
+	if err != nil {
+		return nil, err
 	}
-	if track, _ := metadata.Track(); track != 0 {
-		t.metadata.TrackNumber = track
+	if tags.picture == nil {
+		return nil, nil
 	}
-
-	return nil
+	return &Cover{MIMEType: tags.picture.MIMEType, Data: tags.picture.Data}, nil
 }
 
-// generateID generates a unique ID for the track based on its path
+// generateID generates a unique ID for the track based on its path. IDs key
+// the queue and every remote command, so a collision would play the wrong
+// file: the 64-bit FNV-1a hash of the absolute path makes one vanishingly
+// unlikely, and the same file gets the same ID from any directory.
 func generateID(path string) string {
-	// Simple hash for now - can be improved with proper hashing if needed
-	hash := 0
-	for _, char := range path {
-		hash = ((hash << 5) - hash + int(char)) & 0xffffffff
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
 	}
-	return fmt.Sprintf("%08x", hash)
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return fmt.Sprintf("%016x", h.Sum64())
 }
 
 // String returns a string representation of the track
 func (t *Track) String() string {
-	return fmt.Sprintf("%s (%s)", t.DisplayName(), formatDuration(t.Duration))
-}
-
-// formatDuration formats duration in MM:SS format
-func formatDuration(d time.Duration) string {
-	minutes := int(d.Minutes())
-	seconds := int(d.Seconds()) % 60
-	return fmt.Sprintf("%02d:%02d", minutes, seconds)
+	return fmt.Sprintf("%s (%s)", t.DisplayName(), util.FormatDuration(t.Duration))
 }
@@ -0,0 +1,25 @@
+package playlist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateIDDoesNotCollide(t *testing.T) {
+	// Both hash to the same value with the 31*h+c string hash
+	if a, b := generateID("/music/Aa.mp3"), generateID("/music/BB.mp3"); a == b {
+		t.Errorf("paths ending in Aa and BB share the ID %s", a)
+	}
+}
+
+func TestGenerateIDUsesAbsolutePath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := filepath.Join("assets", "song.mp3")
+	if a, b := generateID(rel), generateID(filepath.Join(wd, rel)); a != b {
+		t.Errorf("relative and absolute paths of one file have IDs %s and %s", a, b)
+	}
+}
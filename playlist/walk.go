@@ -0,0 +1,225 @@
+package playlist
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// scanJob is an audio file found while walking the scan paths
+type scanJob struct {
+	seq   int         // Position in walk order, which new tracks keep
+	path  string      // "" for a walk error
+	track *Track      // Track of the file from the last scan, nil if new
+	last  fingerprint // Fingerprint from the last scan, if track is set
+	err   string      // Why a directory or scan path could not be walked
+}
+
+// scanOutcome is what a worker found out about a file
+type scanOutcome struct {
+	job   scanJob
+	fp    fingerprint
+	fresh *Track // Track read from the file, nil if it did not change
+	err   error
+}
+
+// scanFiles walks roots, scan paths or paths below them, and checks the files found on a pool of
+// s.workers goroutines, which open, hash and decode them. The tracks and
+// fingerprints are only changed here, as the outcomes come in and once
+// the walk is over, so the workers need no locks.
+func (s *Scanner) scanFiles(ctx context.Context, roots []string, result *ScanResult, progress func(ScanProgress)) {
+	known := make(map[string]*Track, len(s.tracks))
+	for _, track := range s.tracks {
+		known[track.Path] = track
+	}
+
+	var seen atomic.Int64
+	jobs := make(chan scanJob, s.workers)
+	outcomes := make(chan scanOutcome, s.workers)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		s.walk(ctx, roots, known, jobs, &seen)
+	}()
+
+	var workers sync.WaitGroup
+	for range s.workers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				if job.err != "" {
+					outcomes <- scanOutcome{job: job}
+					continue
+				}
+				if ctx.Err() != nil {
+					continue // Drain the jobs the walk queued before it stopped
+				}
+				outcomes <- s.checkFile(job)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		workers.Wait()
+		close(outcomes)
+	}()
+
+	var added []scanOutcome
+	updated := make(map[*Track]*Track)           // Old track -> track read again
+	fingerprints := make(map[string]fingerprint) // The walk reads s.fingerprints
+	var p ScanProgress
+	for out := range outcomes {
+		switch {
+		case out.job.err != "":
+			result.Errors = append(result.Errors, out.job.err)
+		case out.err != nil:
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to process %s: %v", filepath.Base(out.job.path), out.err))
+			p.Processed++
+		case out.fresh == nil:
+			fingerprints[out.job.path] = out.fp
+			p.Processed++
+		case out.job.track == nil:
+			fingerprints[out.job.path] = out.fp
+			added = append(added, out)
+			result.NewTracks++
+			p.Processed++
+		default:
+			fingerprints[out.job.path] = out.fp
+			updated[out.job.track] = out.fresh
+			result.UpdatedTracks++
+			p.Processed++
+		}
+
+		if progress != nil {
+			p.Seen = int(seen.Load())
+			p.Errors = len(result.Errors)
+			if out.job.path != "" {
+				p.Path = out.job.path
+			}
+			progress(p)
+		}
+	}
+
+	for path, fp := range fingerprints {
+		s.fingerprints[path] = fp
+	}
+
+	// Changed tracks are replaced rather than modified, as callers may be
+	// reading them; they keep their IDs, and with them their queue entries
+	tracks := make([]*Track, len(s.tracks), len(s.tracks)+len(added))
+	for i, track := range s.tracks {
+		if fresh, ok := updated[track]; ok {
+			track = fresh
+		}
+		tracks[i] = track
+	}
+
+	// Workers finish in any order; new tracks are added in walk order
+	slices.SortFunc(added, func(a, b scanOutcome) int { return a.job.seq - b.job.seq })
+	for _, out := range added {
+		tracks = append(tracks, out.fresh)
+	}
+	s.setTracks(tracks)
+}
+
+// walk sends a job for every audio file under roots, and for every
+// directory that cannot be read, until ctx is cancelled
+func (s *Scanner) walk(ctx context.Context, roots []string, known map[string]*Track, jobs chan<- scanJob, seen *atomic.Int64) {
+	queued := make(map[string]bool) // Files reachable from more than one scan path
+	send := func(job scanJob) bool {
+		job.seq = len(queued)
+		if job.path != "" {
+			if queued[job.path] {
+				return true
+			}
+			queued[job.path] = true
+			seen.Add(1)
+			if job.track = known[job.path]; job.track != nil {
+				job.last = s.fingerprints[job.path]
+			}
+		}
+		select {
+		case jobs <- job:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var walkDir func(dirPath string) bool
+	walkDir = func(dirPath string) bool {
+		entries, err := os.ReadDir(dirPath)
+		if err != nil {
+			return send(scanJob{err: fmt.Sprintf("Failed to scan subdirectory %s: %v", dirPath, err)})
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dirPath, entry.Name())
+			if s.excluded(path) {
+				continue
+			}
+			if entry.IsDir() {
+				// Recursively scan subdirectories
+				if !walkDir(path) {
+					return false
+				}
+				continue
+			}
+			// Check if it's an audio file
+			if !s.extensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+				continue
+			}
+			if !send(scanJob{path: path}) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, path := range roots {
+		info, err := os.Stat(path)
+		var ok bool
+		switch {
+		case err != nil:
+			ok = send(scanJob{err: fmt.Sprintf("Failed to scan %s: %v", path, err)})
+		case info.IsDir():
+			ok = walkDir(path)
+		case !s.extensions[strings.ToLower(filepath.Ext(path))]:
+			ok = send(scanJob{err: fmt.Sprintf("Failed to scan %s: unsupported audio format: %s", path, filepath.Ext(path))})
+		case s.excluded(path):
+			ok = true
+		default:
+			ok = send(scanJob{path: path})
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// checkFile reads a file again if it changed since the last scan, or for
+// the first time if it is new
+func (s *Scanner) checkFile(job scanJob) scanOutcome {
+	out := scanOutcome{job: job}
+	if job.track != nil {
+		fp, err := takeFingerprint(job.path, s.hash)
+		if err == nil && !fp.changedFrom(job.last) {
+			if !job.track.HasMetadata() {
+				// Cached before tags were kept in the cache
+				job.track.loadMetadata()
+			}
+			out.fp = fp
+			return out
+		}
+	}
+	out.fresh, out.fp, out.err = readTrack(job.path, s.hash)
+	return out
+}
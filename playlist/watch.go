@@ -0,0 +1,154 @@
+package playlist
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// watchDelay is how long the library must be quiet before its changes are
+// delivered, so that copying an album in makes one update, not one per file.
+// Tests shorten it.
+var watchDelay = 2 * time.Second
+
+// Watcher reports changes to the files and directories under the scan
+// paths of a Scanner, including directories created after it started.
+// It only reports them: Scanner.Update applies them, so the owner of the
+// scanner decides when its tracks change.
+type Watcher struct {
+	// Changes delivers the paths that changed, in batches once they have
+	// been quiet for a while. It is closed when the watcher is.
+	Changes <-chan []string
+
+	roots   []string      // Scan paths when the watcher started
+	exclude []string      // Exclude patterns when the watcher started
+	delay   time.Duration // watchDelay when the watcher started
+	events  chan string   // Paths that changed, as they change
+	done    chan struct{}
+	once    sync.Once
+	sys     watcherSys // Platform specific state
+}
+
+// Watch starts watching the scan paths for changes
+func (s *Scanner) Watch() (*Watcher, error) {
+	changes := make(chan []string)
+	s.mu.RLock()
+	w := &Watcher{
+		Changes: changes,
+		roots:   slices.Clone(s.scanPaths),
+		exclude: slices.Clone(s.exclude),
+		delay:   watchDelay,
+		events:  make(chan string, 64),
+		done:    make(chan struct{}),
+	}
+	s.mu.RUnlock()
+	if err := w.start(); err != nil {
+		return nil, fmt.Errorf("failed to watch library: %w", err)
+	}
+	go w.debounce(changes)
+	return w, nil
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.stop()
+	})
+	return err
+}
+
+// report queues a changed path for the next batch
+func (w *Watcher) report(path string) {
+	select {
+	case w.events <- path:
+	case <-w.done:
+	}
+}
+
+// debounce collects changed paths until none came in for the delay, then
+// delivers them as one batch
+func (w *Watcher) debounce(changes chan<- []string) {
+	defer close(changes)
+	timer := time.NewTimer(w.delay)
+	timer.Stop()
+	pending := make(map[string]bool)
+	for {
+		select {
+		case <-w.done:
+			return
+		case path := <-w.events:
+			pending[path] = true
+			timer.Reset(w.delay)
+		case <-timer.C:
+			batch := make([]string, 0, len(pending))
+			for path := range pending {
+				batch = append(batch, path)
+			}
+			slices.Sort(batch)
+			clear(pending)
+			select {
+			case changes <- batch:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// Update brings the tracks in line with the files and directories at
+// paths, as reported by a Watcher, and saves the cache. Directories are
+// scanned whole; tracks at or below paths that are gone are removed.
+func (s *Scanner) Update(paths []string) *ScanResult {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
+	result := &ScanResult{
+		ScanTime: time.Now(),
+		Errors:   []string{},
+	}
+
+	// Tracks of files that are gone, or no longer part of the library
+	var remainingTracks []*Track
+	for _, track := range s.tracks {
+		changed := slices.ContainsFunc(paths, func(path string) bool {
+			return within(track.Path, path)
+		})
+		if changed {
+			if _, err := os.Stat(track.Path); os.IsNotExist(err) || !s.included(track.Path) {
+				delete(s.fingerprints, track.Path)
+				result.RemovedTracks++
+				continue
+			}
+		}
+		remainingTracks = append(remainingTracks, track)
+	}
+	s.setTracks(remainingTracks)
+
+	// New and changed files; anything else under the watched directories,
+	// like cover images or partial downloads, is left alone
+	var roots []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() && s.underScanPath(path) || !info.IsDir() && s.included(path) {
+			roots = append(roots, path)
+		}
+	}
+	s.scanFiles(context.Background(), roots, result, nil)
+
+	if err := s.saveCache(); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Failed to save cache: %v", err))
+	}
+
+	result.Tracks = s.tracks
+	result.TotalFiles = len(s.tracks)
+	result.ScanTime = time.Now()
+	return result
+}
@@ -0,0 +1,156 @@
+//go:build linux
+
+package playlist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+)
+
+// watchMask selects the inotify events that can change the library
+const watchMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_ONLYDIR
+
+// watcherSys is the inotify instance of a Watcher. Its directories are
+// only used by the goroutine reading events once the watcher started.
+type watcherSys struct {
+	fd   int
+	file *os.File         // fd, read through the runtime poller so Close interrupts reads
+	dirs map[int32]string // Watch descriptor -> directory
+}
+
+func (w *Watcher) start() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	w.sys = watcherSys{
+		fd:   fd,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		dirs: make(map[int32]string),
+	}
+
+	for _, root := range w.roots {
+		info, err := os.Stat(root)
+		if err != nil {
+			continue // Missing scan paths have nothing to watch
+		}
+		if info.IsDir() {
+			err = w.addTree(root)
+		} else {
+			// Files are watched through their directory
+			err = w.addDir(filepath.Dir(root))
+		}
+		if err != nil {
+			w.sys.file.Close()
+			return err
+		}
+	}
+	go w.read()
+	return nil
+}
+
+func (w *Watcher) stop() error {
+	return w.sys.file.Close()
+}
+
+// addTree watches dir and the directories below it that are not excluded
+func (w *Watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil // Unreadable directories cannot hold tracks either
+		}
+		if path != dir && matchesAny(w.exclude, path) {
+			return filepath.SkipDir
+		}
+		return w.addDir(path)
+	})
+}
+
+// addDir watches a single directory
+func (w *Watcher) addDir(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.sys.fd, dir, watchMask)
+	if errors.Is(err, syscall.ENOSPC) {
+		return errors.New("too many directories to watch; raise fs.inotify.max_user_watches")
+	}
+	if err != nil {
+		return nil // Removed or unreadable since it was found
+	}
+	w.sys.dirs[int32(wd)] = dir
+	return nil
+}
+
+// forget stops watching dir and the directories below it, which moved away
+func (w *Watcher) forget(dir string) {
+	for wd, path := range w.sys.dirs {
+		if within(path, dir) {
+			_, _ = syscall.InotifyRmWatch(w.sys.fd, uint32(wd))
+			delete(w.sys.dirs, wd)
+		}
+	}
+}
+
+// read reports the paths of inotify events until the watcher is closed
+func (w *Watcher) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.sys.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[off:]))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			size := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			off += syscall.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[off:off+size], "\x00"))
+			off += size
+			w.handle(wd, mask, name)
+		}
+	}
+}
+
+// handle keeps the watches in line with the directories and reports the
+// path an event is about
+func (w *Watcher) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// Events were lost, so everything may have changed
+		for _, root := range w.roots {
+			w.report(root)
+		}
+		return
+	}
+	dir, ok := w.sys.dirs[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.sys.dirs, wd) // Removed along with its directory
+		return
+	}
+	if !ok {
+		return
+	}
+
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+	if mask&syscall.IN_ISDIR != 0 {
+		switch {
+		case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			// Files may be in it before it is watched; the update scans it whole
+			below := slices.ContainsFunc(w.roots, func(root string) bool { return within(path, root) })
+			if below && !matchesAny(w.exclude, path) {
+				_ = w.addTree(path)
+			}
+		case mask&syscall.IN_MOVED_FROM != 0:
+			w.forget(path)
+		}
+	}
+	w.report(path)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package playlist
+
+import "errors"
+
+type watcherSys struct{}
+
+func (w *Watcher) start() error {
+	return errors.New("not supported on this system")
+}
+
+func (w *Watcher) stop() error {
+	return nil
+}
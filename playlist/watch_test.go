@@ -0,0 +1,89 @@
+package playlist
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWatcherCoalescesChanges(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("watching needs inotify")
+	}
+	defer func(delay time.Duration) { watchDelay = delay }(watchDelay)
+	watchDelay = 300 * time.Millisecond
+
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	for _, name := range []string{"kept.wav", "gone.wav", "old.wav"} {
+		writeSilence(t, path(name))
+	}
+	s := NewScannerWithOptions([]string{dir}, ScannerOptions{NoCache: true})
+	if _, err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Changes spaced closer than the delay make one batch
+	steps := []func() error{
+		func() error { writeSilence(t, path("new.wav")); return nil },
+		func() error { return os.Rename(path("old.wav"), path("renamed.wav")) },
+		func() error { return os.Remove(path("gone.wav")) },
+		func() error { return os.Mkdir(path("album"), 0755) },
+		func() error { writeSilence(t, path("album/deep.wav")); return nil },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(watchDelay / 5)
+	}
+
+	var batch []string
+	select {
+	case batch = <-w.Changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no changes were reported")
+	}
+	for _, name := range []string{"new.wav", "old.wav", "renamed.wav", "gone.wav", "album"} {
+		if !slices.ContainsFunc(batch, func(changed string) bool { return within(path(name), changed) }) {
+			t.Errorf("batch %q does not cover %s", batch, name)
+		}
+	}
+	if slices.Contains(batch, path("kept.wav")) {
+		t.Errorf("batch %q reports the untouched kept.wav", batch)
+	}
+	select {
+	case more := <-w.Changes:
+		t.Errorf("a second batch %q followed the first", more)
+	case <-time.After(3 * watchDelay):
+	}
+
+	result := s.Update(batch)
+	if result.RemovedTracks != 2 {
+		t.Errorf("Update removed %d tracks, want 2", result.RemovedTracks)
+	}
+	var names []string
+	for _, track := range s.GetTracks() {
+		rel, _ := filepath.Rel(dir, track.Path)
+		names = append(names, filepath.ToSlash(rel))
+	}
+	slices.Sort(names)
+	if want := []string{"album/deep.wav", "kept.wav", "new.wav", "renamed.wav"}; !slices.Equal(names, want) {
+		t.Errorf("library holds %q after the update, want %q", names, want)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-w.Changes; ok {
+		t.Error("Changes is still open after Close")
+	}
+}
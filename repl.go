@@ -0,0 +1,711 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"perth/internal"
+	"perth/player"
+	"perth/playlist"
+	"perth/util"
+)
+
+// runREPL runs the line-based command interface
+func runREPL(opts *options) int {
+	fmt.Println("🎵 Perth Music Player")
+	ctl, shutdown := newController(opts, true)
+	defer shutdown()
+	fmt.Println()
+
+	fmt.Println("Commands:")
+	fmt.Println("  load <file>     - Load an audio file")
+	fmt.Println("  play            - Start/Resume playback")
+	fmt.Println("  pause           - Pause playback")
+	fmt.Println("  stop            - Stop and reset to beginning")
+	fmt.Println("  seek <seconds>  - Seek to position (in seconds)")
+	fmt.Println("  volume <0-100>  - Set volume (0-100)")
+	fmt.Println("  crossfade <sec> - Crossfade between tracks (0 = gapless)")
+	fmt.Println("  crossfade album <on|off> - Don't crossfade within an album")
+	fmt.Println("  status          - Show current status")
+	fmt.Println("  ls              - List available audio files")
+	fmt.Println("  list            - Show playlist tracks")
+	fmt.Println("  next            - Play next track in playlist")
+	fmt.Println("  prev            - Play previous track in playlist")
+	fmt.Println("  goto <index>    - Jump to track by index")
+	fmt.Println("  repeat <off|one|all> - Set the repeat mode")
+	fmt.Println("  shuffle <on|off> [seed] - Shuffle the queue")
+	fmt.Println("  queue add <index|query> - Add tracks to the play queue")
+	fmt.Println("  queue ls        - Show the play queue")
+	fmt.Println("  queue rm <pos>  - Remove an entry from the queue")
+	fmt.Println("  queue mv <from> <to> - Move a queue entry")
+	fmt.Println("  queue clear     - Empty the queue")
+	fmt.Println("  rescan          - Rescan audio files")
+	fmt.Println("  reload          - Reload the config file and rescan")
+	fmt.Println("  quit            - Exit the player")
+	fmt.Println()
+
+	events, cancel := ctl.Subscribe()
+	defer cancel()
+
+	// Input is read on its own goroutine so that what the controller does
+	// on its own, like moving to the next track, is reported while waiting
+	lines := readLines(os.Stdin)
+	for {
+		fmt.Print("> ")
+		line, ok := waitForInput(lines, events)
+		if !ok {
+			return exitOK
+		}
+
+		input := strings.TrimSpace(line)
+		if input == "" {
+			continue
+		}
+
+		// Validate UTF-8 encoding
+		if !utf8.ValidString(input) {
+			fmt.Println("❌ Invalid character encoding. Please ensure your terminal supports UTF-8.")
+			continue
+		}
+
+		command, args := parseCommand(input)
+
+		switch command {
+		case "load":
+			if len(args) < 1 {
+				fmt.Println("Usage: load <file>")
+				continue
+			}
+			loadFile(ctl, args[0])
+
+		case "play":
+			if err := ctl.Play(); err != nil {
+				fmt.Printf("Error playing: %v\n", err)
+			} else {
+				fmt.Println("▶️  Playing")
+			}
+
+		case "pause":
+			if err := ctl.Pause(); err != nil {
+				fmt.Printf("Error pausing: %v\n", err)
+			} else {
+				fmt.Println("⏸️  Paused")
+			}
+
+		case "stop":
+			if err := ctl.Stop(); err != nil {
+				fmt.Printf("Error stopping: %v\n", err)
+			} else {
+				fmt.Println("⏹️  Stopped")
+			}
+
+		case "seek":
+			if len(args) < 1 {
+				fmt.Println("Usage: seek <seconds>")
+				continue
+			}
+			seekTo(ctl, args[0])
+
+		case "volume":
+			if len(args) < 1 {
+				fmt.Println("Usage: volume <0-100>")
+				continue
+			}
+			setVolume(ctl, args[0])
+
+		case "crossfade":
+			if len(args) < 1 {
+				fmt.Println("Usage: crossfade <seconds> | crossfade album <on|off>")
+				continue
+			}
+			setCrossfade(ctl, args)
+
+		case "repeat":
+			if len(args) < 1 {
+				fmt.Println("Usage: repeat <off|one|all>")
+				continue
+			}
+			setRepeat(ctl, args[0])
+
+		case "shuffle":
+			if len(args) < 1 {
+				fmt.Println("Usage: shuffle <on|off> [seed]")
+				continue
+			}
+			setShuffle(ctl, args)
+
+		case "status":
+			showStatus(ctl)
+
+		case "ls":
+			listAudioFiles(opts.cfg.Library)
+
+		case "list":
+			listPlaylistTracks(ctl)
+
+		case "next":
+			playNextTrack(ctl)
+
+		case "prev":
+			playPreviousTrack(ctl)
+
+		case "goto":
+			if len(args) < 1 {
+				fmt.Println("Usage: goto <index>")
+				continue
+			}
+			gotoTrack(ctl, args[0])
+
+		case "queue":
+			queueCommand(ctl, args)
+
+		case "rescan":
+			rescanAudioFiles(ctl)
+
+		case "reload":
+			reloadConfig(opts, ctl)
+
+		case "quit", "exit":
+			fmt.Println("👋 Goodbye!")
+			return exitOK
+
+		default:
+			fmt.Printf("Unknown command: %s\n", command)
+		}
+	}
+}
+
+// readLines delivers lines from r on a channel that is closed at EOF
+func readLines(r *os.File) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+// waitForInput blocks until the user enters a line, reporting what the
+// controller does on its own in the meantime
+func waitForInput(lines <-chan string, events <-chan internal.Event) (string, bool) {
+	for {
+		select {
+		case line, ok := <-lines:
+			return line, ok
+		case e := <-events:
+			if reportEvent(e) {
+				fmt.Print("> ")
+			}
+		}
+	}
+}
+
+// reportEvent prints events the user did not cause with a command and
+// reports whether anything was printed
+func reportEvent(e internal.Event) bool {
+	if !e.Auto {
+		return false
+	}
+	switch e.Type {
+	case internal.EventTrackChanged:
+		if e.Track == nil {
+			return false
+		}
+		fmt.Printf("\n⏭️  Now playing: %s\n", e.Track.String())
+	case internal.EventQueueEnded:
+		fmt.Println("\n⏹️  End of queue")
+	case internal.EventError:
+		fmt.Printf("\n❌ %v\n", e.Err)
+	default:
+		return false
+	}
+	return true
+}
+
+// parseCommand parses user input with proper Unicode support
+func parseCommand(input string) (string, []string) {
+	// Split by whitespace while preserving Unicode characters
+	parts := strings.Fields(input)
+	if len(parts) == 0 {
+		return "", nil
+	}
+
+	command := strings.ToLower(parts[0])
+	var args []string
+
+	if len(parts) > 1 {
+		switch command {
+		case "load":
+			// For load command, join all remaining parts to handle filenames with spaces
+			// This preserves Unicode characters in filenames
+			filename := strings.Join(parts[1:], " ")
+			// Clean the filename and validate it exists
+			filename = strings.TrimSpace(filename)
+			args = []string{filename}
+		case "seek", "volume":
+			// These commands expect a single numeric argument
+			args = []string{parts[1]}
+		default:
+			args = parts[1:]
+		}
+	}
+
+	return command, args
+}
+
+func loadFile(ctl *internal.Controller, filePath string) {
+	// Validate UTF-8 in filename
+	if !utf8.ValidString(filePath) {
+		fmt.Println("❌ Invalid character encoding in filename. Please ensure your terminal supports UTF-8.")
+		return
+	}
+
+	fmt.Printf("🎵 Loading: %s\n", filePath)
+	if err := ctl.Load(filePath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("❌ File not found: %s\n", filePath)
+			fmt.Println("💡 Tip: Use 'ls assets/' to see available files")
+			return
+		}
+		fmt.Printf("❌ Error loading file: %v\n", err)
+		return
+	}
+
+	if duration := ctl.Status().Duration; duration > 0 {
+		fmt.Printf("✅ Loaded successfully (Duration: %s)\n", util.FormatDuration(duration))
+	} else {
+		fmt.Println("✅ Loaded successfully")
+	}
+}
+
+func seekTo(ctl *internal.Controller, secondsStr string) {
+	seconds, err := strconv.ParseFloat(secondsStr, 64)
+	if err != nil {
+		fmt.Printf("Invalid time: %s\n", secondsStr)
+		return
+	}
+
+	pos := time.Duration(seconds * float64(time.Second))
+	if err := ctl.Seek(pos); err != nil {
+		fmt.Printf("Error seeking: %v\n", err)
+		return
+	}
+
+	fmt.Printf("⏩ Seeked to %s\n", util.FormatDuration(pos))
+}
+
+func setVolume(ctl *internal.Controller, volumeStr string) {
+	volume, err := strconv.ParseFloat(volumeStr, 64)
+	if err != nil {
+		fmt.Printf("Invalid volume: %s\n", volumeStr)
+		return
+	}
+
+	if volume < 0 || volume > 100 {
+		fmt.Println("Volume must be between 0 and 100")
+		return
+	}
+
+	// Convert percentage to linear volume (0.0 to 1.0)
+	ctl.SetVolume(volume / 100.0)
+	fmt.Printf("🔊 Volume set to %.0f%%\n", volume)
+}
+
+func setCrossfade(ctl *internal.Controller, args []string) {
+	if args[0] == "album" {
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			fmt.Println("Usage: crossfade album <on|off>")
+			return
+		}
+		ctl.SetGaplessAlbums(args[1] == "on")
+		if args[1] == "on" {
+			fmt.Println("💿 Tracks from the same album will play gaplessly")
+		} else {
+			fmt.Println("💿 Crossfading between all tracks")
+		}
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || seconds < 0 {
+		fmt.Printf("Invalid crossfade length: %s\n", args[0])
+		return
+	}
+
+	ctl.SetCrossfade(time.Duration(seconds * float64(time.Second)))
+	if seconds == 0 {
+		fmt.Println("🔀 Crossfade disabled")
+	} else {
+		fmt.Printf("🔀 Crossfade set to %.1fs\n", seconds)
+	}
+}
+
+func setRepeat(ctl *internal.Controller, arg string) {
+	mode, err := playlist.ParseRepeatMode(arg)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+	ctl.SetRepeat(mode)
+	fmt.Printf("🔁 Repeat: %s\n", mode)
+}
+
+func setShuffle(ctl *internal.Controller, args []string) {
+	switch args[0] {
+	case "on":
+		seed := time.Now().UnixNano()
+		if len(args) > 1 {
+			var err error
+			if seed, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				fmt.Printf("❌ Invalid seed: %s\n", args[1])
+				return
+			}
+		}
+		ctl.SetShuffle(true, seed)
+		fmt.Printf("🔀 Shuffle on (seed %d)\n", seed)
+
+	case "off":
+		ctl.SetShuffle(false, 0)
+		fmt.Println("➡️  Shuffle off")
+
+	default:
+		fmt.Println("Usage: shuffle <on|off> [seed]")
+	}
+}
+
+func showStatus(ctl *internal.Controller) {
+	status := ctl.Status()
+
+	fmt.Printf("📊 Status:\n")
+	fmt.Printf("  State: %s\n", status.State)
+	fmt.Printf("  Position: %s\n", util.FormatDuration(status.Position))
+	if status.Duration > 0 {
+		fmt.Printf("  Duration: %s\n", util.FormatDuration(status.Duration))
+		progress := float64(status.Position) / float64(status.Duration) * 100
+		fmt.Printf("  Progress: %.1f%%\n", progress)
+	}
+	if status.Crossfade > 0 {
+		fmt.Printf("  Crossfade: %.1fs\n", status.Crossfade.Seconds())
+	}
+	fmt.Printf("  Repeat: %s\n", status.Repeat)
+	if status.Shuffle {
+		fmt.Printf("  Shuffle: on (seed %d)\n", status.Seed)
+	} else {
+		fmt.Println("  Shuffle: off")
+	}
+}
+
+func listAudioFiles(dirs []string) {
+	fmt.Println("🎵 Available audio files:")
+
+	found := false
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			fmt.Printf("❌ Cannot read %s: %v\n", dir, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			if player.Supported(entry.Name()) {
+				// Display the full relative path that users should type
+				fmt.Printf("  %s\n", filepath.Join(dir, entry.Name()))
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		fmt.Println("  No audio files found in the library directories")
+	} else {
+		fmt.Println("\n💡 Copy and paste the full path to load a file")
+	}
+}
+
+// Playlist management functions
+
+func listPlaylistTracks(ctl *internal.Controller) {
+	tracks := ctl.Tracks()
+	if len(tracks) == 0 {
+		fmt.Println("📭 No tracks found in playlist")
+		return
+	}
+
+	var currentID string
+	if current := ctl.Status().Track; current != nil {
+		currentID = current.ID
+	}
+	currentIndex := -1
+
+	fmt.Printf("🎵 Playlist (%d tracks):\n", len(tracks))
+	for i, track := range tracks {
+		marker := "  "
+		if track.ID == currentID {
+			marker = "▶️ "
+			currentIndex = i
+		}
+		fmt.Printf("%s%d. %s\n", marker, i+1, track.String())
+
+		// Show metadata for current track
+		if track.ID == currentID && track.HasMetadata() {
+			artist := track.Artist()
+			album := track.Album()
+			if artist != "" {
+				fmt.Printf("     Artist: %s\n", artist)
+			}
+			if album != "" {
+				fmt.Printf("     Album: %s\n", album)
+			}
+		}
+	}
+
+	if currentIndex >= 0 {
+		fmt.Printf("\n💡 Current track: %d\n", currentIndex+1)
+	}
+}
+
+func playNextTrack(ctl *internal.Controller) {
+	if err := ctl.Next(); err != nil {
+		printQueueError(err)
+		return
+	}
+	if track := ctl.Status().Track; track != nil {
+		fmt.Printf("⏭️  Next track: %s\n", track.String())
+	}
+	fmt.Println("▶️  Playing next track")
+}
+
+func playPreviousTrack(ctl *internal.Controller) {
+	if err := ctl.Prev(); err != nil {
+		printQueueError(err)
+		return
+	}
+	if track := ctl.Status().Track; track != nil {
+		fmt.Printf("⏮️  Previous track: %s\n", track.String())
+	}
+	fmt.Println("▶️  Playing previous track")
+}
+
+// printQueueError reports a failed queue navigation
+func printQueueError(err error) {
+	switch {
+	case errors.Is(err, internal.ErrEmptyQueue):
+		fmt.Println("📭 No tracks in playlist")
+	case errors.Is(err, internal.ErrEndOfQueue):
+		fmt.Println("⏹️  End of queue")
+	case errors.Is(err, internal.ErrStartOfQueue):
+		fmt.Println("⏮️  Already at the start of the queue")
+	default:
+		fmt.Printf("❌ %v\n", err)
+	}
+}
+
+func gotoTrack(ctl *internal.Controller, indexStr string) {
+	index, err := strconv.Atoi(indexStr)
+	if err != nil {
+		fmt.Printf("❌ Invalid index: %s\n", indexStr)
+		return
+	}
+
+	tracks := ctl.Tracks()
+	if index < 1 || index > len(tracks) {
+		fmt.Printf("❌ Index out of range (1-%d)\n", len(tracks))
+		return
+	}
+
+	track := tracks[index-1]
+	fmt.Printf("🎯 Jumping to track %d: %s\n", index, track.String())
+	if err := ctl.PlayTrack(track.ID); err != nil {
+		fmt.Printf("❌ Failed to play track: %v\n", err)
+		return
+	}
+	fmt.Println("▶️  Playing selected track")
+}
+
+// Queue commands
+
+func queueCommand(ctl *internal.Controller, args []string) {
+	if len(args) == 0 {
+		listQueue(ctl)
+		return
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 2 {
+			fmt.Println("Usage: queue add <index|query>")
+			return
+		}
+		addToQueue(ctl, strings.Join(args[1:], " "))
+
+	case "ls", "list":
+		listQueue(ctl)
+
+	case "rm", "remove":
+		if len(args) < 2 {
+			fmt.Println("Usage: queue rm <position>")
+			return
+		}
+		pos, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Printf("❌ Invalid position: %s\n", args[1])
+			return
+		}
+		if err := ctl.RemoveQueued(pos - 1); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+		fmt.Printf("🗑️  Removed entry %d from the queue\n", pos)
+
+	case "mv", "move":
+		if len(args) < 3 {
+			fmt.Println("Usage: queue mv <from> <to>")
+			return
+		}
+		from, err1 := strconv.Atoi(args[1])
+		to, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			fmt.Println("❌ Positions must be numbers")
+			return
+		}
+		if err := ctl.MoveQueued(from-1, to-1); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+		fmt.Printf("↕️  Moved entry %d to %d\n", from, to)
+
+	case "clear":
+		ctl.ClearQueue()
+		fmt.Println("🧹 Queue cleared")
+
+	default:
+		fmt.Printf("Unknown queue command: %s\n", args[0])
+	}
+}
+
+// addToQueue enqueues a library track by its 1-based index, or every track
+// whose title, filename, artist or album contains the query
+func addToQueue(ctl *internal.Controller, arg string) {
+	if index, err := strconv.Atoi(arg); err == nil {
+		tracks := ctl.Tracks()
+		if index < 1 || index > len(tracks) {
+			fmt.Printf("❌ Index out of range (1-%d)\n", len(tracks))
+			return
+		}
+		ctl.Enqueue(tracks[index-1].ID)
+		fmt.Printf("➕ Queued: %s\n", tracks[index-1].String())
+		return
+	}
+
+	added, err := ctl.EnqueueMatching(arg)
+	if err != nil {
+		fmt.Printf("🔍 No tracks match %q\n", arg)
+		return
+	}
+	for _, track := range added {
+		fmt.Printf("➕ Queued: %s\n", track.String())
+	}
+}
+
+func listQueue(ctl *internal.Controller) {
+	entries := ctl.Queue()
+	if len(entries) == 0 {
+		fmt.Println("📭 Queue is empty")
+		return
+	}
+
+	current := ctl.Status().QueueIndex
+	fmt.Printf("📜 Queue (%d tracks):\n", len(entries))
+	for i, entry := range entries {
+		marker := "  "
+		if i == current {
+			marker = "▶️ "
+		}
+		name := "(missing track)"
+		if entry.Track != nil {
+			name = entry.Track.String()
+		}
+		fmt.Printf("%s%d. %s\n", marker, i+1, name)
+	}
+}
+
+func rescanAudioFiles(ctl *internal.Controller) {
+	fmt.Println("🔄 Rescanning audio files... (ctrl+c to stop)")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, removed, err := ctl.Rescan(ctx, scanProgress())
+	fmt.Print("\r\033[K") // Clear the progress line
+	if errors.Is(err, context.Canceled) {
+		fmt.Printf("⏹️  Rescan stopped, %d tracks\n", len(ctl.Tracks()))
+		return
+	}
+	if err != nil {
+		fmt.Printf("❌ Rescan failed: %v\n", err)
+		return
+	}
+
+	fmt.Printf("✅ Rescan completed!\n")
+	fmt.Printf("📊 Results:\n")
+	fmt.Printf("  Total tracks: %d\n", result.TotalFiles)
+	fmt.Printf("  New tracks: %d\n", result.NewTracks)
+	fmt.Printf("  Updated tracks: %d\n", result.UpdatedTracks)
+	fmt.Printf("  Removed tracks: %d\n", result.RemovedTracks)
+
+	if len(result.Errors) > 0 {
+		fmt.Printf("⚠️  Errors encountered:\n")
+		for _, err := range result.Errors {
+			fmt.Printf("    - %s\n", err)
+		}
+	}
+
+	if removed > 0 {
+		fmt.Printf("🗑️  Removed %d missing tracks from the queue\n", removed)
+	}
+}
+
+// scanProgress returns a scan progress callback that redraws a progress
+// line, at most ten times a second
+func scanProgress() func(playlist.ScanProgress) {
+	const width = 20
+	var last time.Time
+	return func(p playlist.ScanProgress) {
+		if time.Since(last) < 100*time.Millisecond {
+			return
+		}
+		last = time.Now()
+
+		// More files may turn up while the walk goes on
+		done := width * p.Processed / max(p.Seen, 1)
+		bar := strings.Repeat("█", done) + strings.Repeat("░", width-done)
+		name := filepath.Base(p.Path)
+		if utf8.RuneCountInString(name) > 40 {
+			name = string([]rune(name)[:39]) + "…"
+		}
+		fmt.Printf("\r\033[K%s %d/%d files, %d errors  %s", bar, p.Processed, p.Seen, p.Errors, name)
+	}
+}
+
+func reloadConfig(opts *options, ctl *internal.Controller) {
+	result, err := reload(context.Background(), opts, ctl, nil)
+	if err != nil {
+		fmt.Printf("❌ Reload failed: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ Reloaded configuration, %d tracks\n", result.TotalFiles)
+}
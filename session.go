@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"perth/internal"
+	"perth/util"
+)
+
+// sessionInterval is how often the session is saved while it changes, so
+// little is lost when Perth does not get to quit cleanly
+const sessionInterval = 10 * time.Second
+
+// sessionKeeper saves the session of a controller in the background
+type sessionKeeper struct {
+	path string
+	ctl  *internal.Controller
+
+	mu   sync.Mutex // Serializes saves
+	last []byte     // Last session written, to skip unchanged saves
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// keepSession restores the last session when resuming is on and keeps
+// saving the session until the keeper is stopped. The session is kept in
+// the state directory. With ask set, the user is asked before resuming
+// when stdin is a terminal.
+func keepSession(opts *options, ctl *internal.Controller, ask bool) *sessionKeeper {
+	k := &sessionKeeper{
+		path:    filepath.Join(opts.cfg.State, "session.json"),
+		ctl:     ctl,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if opts.cfg.Resume {
+		k.restore(opts, ask)
+	}
+	go k.run()
+	return k
+}
+
+// restore brings back the saved session. Flags given on the command line
+// win over what was saved.
+func (k *sessionKeeper) restore(opts *options, ask bool) {
+	s, err := internal.LoadSession(k.path)
+	if err != nil {
+		fmt.Printf("⚠️  Warning: %v\n", err)
+		return
+	}
+	if s == nil {
+		return
+	}
+	if ask && s.Path != "" && isTerminal(os.Stdin) {
+		fmt.Printf("⏯️  Resume %s at %s? [Y/n] ", filepath.Base(s.Path), util.FormatDuration(s.Position))
+		if answer := strings.ToLower(readAnswer(os.Stdin)); answer != "" && answer != "y" && answer != "yes" {
+			return
+		}
+	}
+	if opts.set["volume"] {
+		s.Volume = opts.cfg.Volume / 100
+	}
+	if opts.set["repeat"] {
+		s.Repeat = opts.cfg.Repeat
+	}
+	if opts.set["shuffle"] {
+		s.Shuffle, s.Seed = opts.cfg.Shuffle, time.Now().UnixNano()
+		s.Order = nil // Belongs to the old seed
+	}
+
+	if err := k.ctl.Restore(*s); err != nil {
+		fmt.Printf("⚠️  Warning: Failed to resume: %v\n", err)
+		return
+	}
+	if st := k.ctl.Status(); st.Path != "" {
+		name := filepath.Base(st.Path)
+		if st.Track != nil {
+			name = st.Track.DisplayName()
+		}
+		fmt.Printf("⏸️  Resuming %s at %s, paused\n", name, util.FormatDuration(st.Position))
+	}
+}
+
+// readAnswer reads a line from f a byte at a time, so nothing after it is
+// taken from readers that come later
+func readAnswer(f *os.File) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if n, err := f.Read(b); n == 0 || err != nil || b[0] == '\n' {
+			return strings.TrimSpace(string(line))
+		}
+		line = append(line, b[0])
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (k *sessionKeeper) run() {
+	defer close(k.stopped)
+	ticker := time.NewTicker(sessionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+			// Printing could garble the TUI; stop reports the final save instead
+			_ = k.save()
+		}
+	}
+}
+
+// save writes the session if it changed since the last save
+func (k *sessionKeeper) save() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	s := k.ctl.Session()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, k.last) {
+		return nil
+	}
+	if err := internal.SaveSession(k.path, s); err != nil {
+		return err
+	}
+	k.last = data
+	return nil
+}
+
+// stop ends the background saves and saves the session a last time. It
+// must be called before the controller is closed.
+func (k *sessionKeeper) stop() {
+	close(k.done)
+	<-k.stopped
+	if err := k.save(); err != nil {
+		fmt.Printf("⚠️  Warning: %v\n", err)
+	}
+}
@@ -0,0 +1,184 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+)
+
+// keyMap holds every key binding of the TUI. It implements help.KeyMap so
+// the help bar is generated from the same definitions that Update matches.
+type keyMap struct {
+	// Navigation
+	Up         key.Binding
+	Down       key.Binding
+	PageUp     key.Binding
+	PageDown   key.Binding
+	Top        key.Binding
+	Bottom     key.Binding
+	SwitchView key.Binding
+
+	// Playback
+	PlaySelected key.Binding
+	Toggle       key.Binding
+	Stop         key.Binding
+	Next         key.Binding
+	Prev         key.Binding
+	SeekForward  key.Binding
+	SeekBack     key.Binding
+	SeekTo       key.Binding
+	VolumeUp     key.Binding
+	VolumeDown   key.Binding
+
+	// Modes
+	Repeat         key.Binding
+	Shuffle        key.Binding
+	CrossfadeUp    key.Binding
+	CrossfadeDown  key.Binding
+	CrossfadeAlbum key.Binding
+
+	// Queue
+	Enqueue    key.Binding
+	Search     key.Binding
+	Remove     key.Binding
+	MoveUp     key.Binding
+	MoveDown   key.Binding
+	ClearQueue key.Binding
+
+	// Misc
+	Open   key.Binding
+	Rescan key.Binding
+	Reload key.Binding
+	Help   key.Binding
+	Quit   key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		Up:         key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "up")),
+		Down:       key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "down")),
+		PageUp:     key.NewBinding(key.WithKeys("pgup", "ctrl+u"), key.WithHelp("pgup", "page up")),
+		PageDown:   key.NewBinding(key.WithKeys("pgdown", "ctrl+d"), key.WithHelp("pgdn", "page down")),
+		Top:        key.NewBinding(key.WithKeys("home", "g"), key.WithHelp("g/home", "first")),
+		Bottom:     key.NewBinding(key.WithKeys("end", "G"), key.WithHelp("G/end", "last")),
+		SwitchView: key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "library/queue")),
+
+		PlaySelected: key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "play selected")),
+		Toggle:       key.NewBinding(key.WithKeys(" "), key.WithHelp("space", "play/pause")),
+		Stop:         key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "stop")),
+		Next:         key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "next")),
+		Prev:         key.NewBinding(key.WithKeys("p"), key.WithHelp("p", "previous")),
+		SeekForward:  key.NewBinding(key.WithKeys("right", "l"), key.WithHelp("→/l", "+5s")),
+		SeekBack:     key.NewBinding(key.WithKeys("left", "h"), key.WithHelp("←/h", "-5s")),
+		SeekTo:       key.NewBinding(key.WithKeys("t"), key.WithHelp("t", "seek to")),
+		VolumeUp:     key.NewBinding(key.WithKeys("+", "="), key.WithHelp("+", "volume up")),
+		VolumeDown:   key.NewBinding(key.WithKeys("-"), key.WithHelp("-", "volume down")),
+
+		Repeat:         key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "repeat mode")),
+		Shuffle:        key.NewBinding(key.WithKeys("z"), key.WithHelp("z", "shuffle")),
+		CrossfadeUp:    key.NewBinding(key.WithKeys("]"), key.WithHelp("]", "crossfade +1s")),
+		CrossfadeDown:  key.NewBinding(key.WithKeys("["), key.WithHelp("[", "crossfade -1s")),
+		CrossfadeAlbum: key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "gapless albums")),
+
+		Enqueue:    key.NewBinding(key.WithKeys("e"), key.WithHelp("e", "add to queue")),
+		Search:     key.NewBinding(key.WithKeys("/"), key.WithHelp("/", "queue matches")),
+		Remove:     key.NewBinding(key.WithKeys("d", "delete"), key.WithHelp("d", "remove from queue")),
+		MoveUp:     key.NewBinding(key.WithKeys("K"), key.WithHelp("K", "move up")),
+		MoveDown:   key.NewBinding(key.WithKeys("J"), key.WithHelp("J", "move down")),
+		ClearQueue: key.NewBinding(key.WithKeys("C"), key.WithHelp("C", "clear queue")),
+
+		Open:   key.NewBinding(key.WithKeys("o"), key.WithHelp("o", "load file")),
+		Rescan: key.NewBinding(key.WithKeys("R"), key.WithHelp("R", "rescan")),
+		Reload: key.NewBinding(key.WithKeys("ctrl+r"), key.WithHelp("ctrl+r", "reload config")),
+		Help:   key.NewBinding(key.WithKeys("?"), key.WithHelp("?", "help")),
+		Quit:   key.NewBinding(key.WithKeys("q", "ctrl+c"), key.WithHelp("q", "quit")),
+	}
+}
+
+// ShortHelp returns the bindings shown in the collapsed help bar
+func (k keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.PlaySelected, k.Toggle, k.Next, k.Prev, k.SwitchView, k.Help, k.Quit}
+}
+
+// FullHelp returns all bindings, one column per group
+func (k keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Up, k.Down, k.PageUp, k.PageDown, k.Top, k.Bottom, k.SwitchView},
+		{k.PlaySelected, k.Toggle, k.Stop, k.Next, k.Prev, k.SeekForward, k.SeekBack, k.SeekTo},
+		{k.VolumeUp, k.VolumeDown, k.Repeat, k.Shuffle, k.CrossfadeUp, k.CrossfadeDown, k.CrossfadeAlbum},
+		{k.Enqueue, k.Search, k.Remove, k.MoveUp, k.MoveDown, k.ClearQueue},
+		{k.Open, k.Rescan, k.Reload, k.Help, k.Quit},
+	}
+}
+
+// actions returns the bindings by the names the config file uses for them
+func (k *keyMap) actions() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"up":              &k.Up,
+		"down":            &k.Down,
+		"page_up":         &k.PageUp,
+		"page_down":       &k.PageDown,
+		"top":             &k.Top,
+		"bottom":          &k.Bottom,
+		"switch_view":     &k.SwitchView,
+		"play_selected":   &k.PlaySelected,
+		"toggle":          &k.Toggle,
+		"stop":            &k.Stop,
+		"next":            &k.Next,
+		"prev":            &k.Prev,
+		"seek_forward":    &k.SeekForward,
+		"seek_back":       &k.SeekBack,
+		"seek_to":         &k.SeekTo,
+		"volume_up":       &k.VolumeUp,
+		"volume_down":     &k.VolumeDown,
+		"repeat":          &k.Repeat,
+		"shuffle":         &k.Shuffle,
+		"crossfade_up":    &k.CrossfadeUp,
+		"crossfade_down":  &k.CrossfadeDown,
+		"crossfade_album": &k.CrossfadeAlbum,
+		"enqueue":         &k.Enqueue,
+		"search":          &k.Search,
+		"remove":          &k.Remove,
+		"move_up":         &k.MoveUp,
+		"move_down":       &k.MoveDown,
+		"clear_queue":     &k.ClearQueue,
+		"open":            &k.Open,
+		"rescan":          &k.Rescan,
+		"reload":          &k.Reload,
+		"help":            &k.Help,
+		"quit":            &k.Quit,
+	}
+}
+
+// keyMapWith returns the default key map with the keys of some actions
+// replaced, as the config file describes them
+func keyMapWith(keys map[string][]string) (keyMap, error) {
+	k := defaultKeyMap()
+	actions := k.actions()
+	for name, bound := range keys {
+		binding, ok := actions[name]
+		if !ok {
+			names := make([]string, 0, len(actions))
+			for name := range actions {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return keyMap{}, fmt.Errorf("unknown key action %q (want one of %s)", name, strings.Join(names, ", "))
+		}
+		if len(bound) == 0 {
+			return keyMap{}, fmt.Errorf("key action %q has no keys", name)
+		}
+		binding.SetKeys(bound...)
+		binding.SetHelp(strings.Join(bound, "/"), binding.Help().Desc)
+	}
+	return k, nil
+}
+
+// CheckKeys reports whether key bindings, as the config file gives them,
+// name known actions and keys
+func CheckKeys(keys map[string][]string) error {
+	_, err := keyMapWith(keys)
+	return err
+}
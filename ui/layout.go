@@ -0,0 +1,266 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+
+	"perth/internal"
+	"perth/player"
+	"perth/util"
+)
+
+const (
+	listShare   = 0.6 // Share of the width taken by the track list
+	volumeWidth = 10  // Number of blocks in the volume indicator
+)
+
+func (m Model) View() string {
+	if m.width == 0 {
+		// No WindowSizeMsg yet
+		return ""
+	}
+
+	st := m.ctl.Status()
+	header := m.headerView(st)
+	footer := m.footerView(st)
+	body := m.bodyView(st, m.height-lipgloss.Height(header)-lipgloss.Height(footer))
+	return lipgloss.JoinVertical(lipgloss.Left, header, body, footer)
+}
+
+// listHeight returns the number of track rows that fit in the list pane
+func (m Model) listHeight() int {
+	if m.height == 0 {
+		return 1
+	}
+	st := m.ctl.Status()
+	body := m.height - lipgloss.Height(m.headerView(st)) - lipgloss.Height(m.footerView(st))
+	// Border and pane title
+	return max(1, body-3)
+}
+
+func (m Model) headerView(st internal.Status) string {
+	shuffle := "off"
+	if st.Shuffle {
+		shuffle = "on"
+	}
+	modes := fmt.Sprintf("repeat %s · shuffle %s", st.Repeat, shuffle)
+	if fade := st.Crossfade; fade > 0 {
+		modes += fmt.Sprintf(" · crossfade %.0fs", fade.Seconds())
+		if st.GaplessAlbums {
+			modes += " (gapless albums)"
+		}
+	}
+	return lipgloss.JoinHorizontal(lipgloss.Center, titleStyle.Render("🎵 Perth"), modeStyle.Render(modes))
+}
+
+func (m Model) bodyView(st internal.Status, height int) string {
+	height = max(height, 4)
+	listWidth := int(float64(m.width) * listShare)
+	list := m.listView(st, listWidth, height)
+	nowPlaying := m.nowPlayingView(st, m.width-listWidth, height)
+	return lipgloss.JoinHorizontal(lipgloss.Top, list, nowPlaying)
+}
+
+// listView renders the library or the queue with the cursor and the
+// playing track highlighted. Only the visible rows are rendered, as the
+// lists can hold the whole library.
+func (m Model) listView(st internal.Status, width, height int) string {
+	inner := max(1, width-paneStyle.GetHorizontalFrameSize())
+	rows := max(1, height-paneStyle.GetVerticalFrameSize()-1)
+
+	var title, empty string
+	var count int
+	var row func(i int) string
+
+	if m.view == queueView {
+		entries := m.ctl.Queue()
+		count = len(entries)
+		title = fmt.Sprintf("Queue (%d)", count)
+		empty = "Queue is empty. Press e or / to add tracks."
+		row = func(i int) string {
+			name, length := "(missing track)", ""
+			if track := entries[i].Track; track != nil {
+				name, length = track.DisplayName(), util.FormatDuration(track.Duration)
+			}
+			return m.listRow(i, fmt.Sprintf("%3d. %s", i+1, name), length, i == st.QueueIndex, inner)
+		}
+	} else {
+		tracks := m.ctl.Tracks()
+		count = len(tracks)
+		title = fmt.Sprintf("Library (%d)", count)
+		empty = "No tracks found. Add files under assets/ and press R."
+		row = func(i int) string {
+			track := tracks[i]
+			isPlaying := st.Track != nil && track.ID == st.Track.ID
+			label := fmt.Sprintf("%3d. %s", i+1, track.DisplayName())
+			return m.listRow(i, label, util.FormatDuration(track.Duration), isPlaying, inner)
+		}
+	}
+
+	content := []string{paneTitleStyle.Render(title)}
+	if count == 0 {
+		content = append(content, dimStyle.Render(empty))
+	}
+	off := min(m.offset[m.view], max(0, count-1))
+	for i := off; i < min(count, off+rows); i++ {
+		content = append(content, row(i))
+	}
+
+	return paneStyle.
+		Width(width - paneStyle.GetHorizontalBorderSize()).
+		Height(height - paneStyle.GetVerticalBorderSize()).
+		Render(strings.Join(content, "\n"))
+}
+
+// listRow renders one list entry with its duration aligned to the right
+func (m Model) listRow(i int, label, right string, playing bool, width int) string {
+	marker := "  "
+	if playing {
+		marker = "▶ "
+	}
+	line := fitLine(marker+label, right, width)
+	switch {
+	case i == m.cursor[m.view]:
+		return cursorStyle.Render(line)
+	case playing:
+		return playingStyle.Render(line)
+	}
+	return line
+}
+
+// nowPlayingView shows the current track with its tags and what plays next
+func (m Model) nowPlayingView(st internal.Status, width, height int) string {
+	inner := max(1, width-paneStyle.GetHorizontalFrameSize())
+	content := []string{paneTitleStyle.Render("Now Playing")}
+
+	path, track := st.Path, st.Track
+	if path == "" {
+		content = append(content, dimStyle.Render("Nothing loaded. Select a track and press enter."))
+	} else {
+		title := filepath.Base(path)
+		if track != nil {
+			title = track.DisplayName()
+		}
+		content = append(content, nowTitleStyle.Render(truncate(title, inner)), "")
+
+		if track != nil {
+			content = append(content,
+				field("Artist", track.Artist(), inner),
+				field("Album", track.Album(), inner),
+				field("Genre", track.Genre(), inner),
+			)
+			if year := track.Year(); year > 0 {
+				content = append(content, field("Year", fmt.Sprint(year), inner))
+			}
+		}
+		content = append(content, field("Format", strings.TrimPrefix(strings.ToUpper(filepath.Ext(path)), "."), inner))
+
+		if next := st.Next; next != nil {
+			content = append(content, "", dimStyle.Render(truncate("Up next: "+next.DisplayName(), inner)))
+		}
+	}
+
+	return paneStyle.
+		Width(width - paneStyle.GetHorizontalBorderSize()).
+		Height(height - paneStyle.GetVerticalBorderSize()).
+		Render(strings.Join(content, "\n"))
+}
+
+// field renders a labelled tag value, or "—" when the tag is empty
+func field(label, value string, width int) string {
+	if value == "" {
+		value = "—"
+	}
+	valueWidth := max(1, width-labelStyle.GetWidth())
+	return labelStyle.Render(label) + valueStyle.Render(truncate(value, valueWidth))
+}
+
+// footerView renders the progress line, the status line and the help.
+// While a rescan runs the progress line shows how far it is.
+func (m Model) footerView(st internal.Status) string {
+	progress := m.progressView(st)
+	if m.scan != nil {
+		progress = m.scanView()
+	}
+	status := statusStyle.Render(m.status)
+	if m.statusErr {
+		status = errorStyle.Render(m.status)
+	}
+	if m.prompt != noPrompt {
+		status = statusStyle.Render(m.input.View())
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, progress, status, m.help.View(m.keys))
+}
+
+// progressView renders the play state, the progress bar and the volume
+func (m Model) progressView(st internal.Status) string {
+	state := "⏹"
+	switch st.State {
+	case player.StatePlaying:
+		state = "▶"
+	case player.StatePaused:
+		state = "⏸"
+	case player.StateError:
+		state = "⚠"
+	}
+
+	position, duration := st.Position, st.Duration
+	times := fmt.Sprintf("%s / %s", util.FormatDuration(position), util.FormatDuration(duration))
+	volume := volumeView(st.Volume)
+
+	left := fmt.Sprintf(" %s %s ", state, times)
+	bar := m.progress
+	bar.Width = max(10, m.width-lipgloss.Width(left)-lipgloss.Width(volume)-2)
+
+	percent := 0.0
+	if duration > 0 {
+		percent = min(1, float64(position)/float64(duration))
+	}
+	return left + bar.ViewAs(percent) + "  " + volume
+}
+
+// scanView renders how far a rescan is in place of the progress line
+func (m Model) scanView() string {
+	p := m.scan.last
+	left := fmt.Sprintf(" 🔄 %d/%d files, %d errors ", p.Processed, p.Seen, p.Errors)
+	name := ""
+	if p.Path != "" {
+		name = dimStyle.Render(truncate(filepath.Base(p.Path), 40))
+	}
+	bar := m.progress
+	bar.Width = max(10, m.width-lipgloss.Width(left)-lipgloss.Width(name)-2)
+
+	// More files may turn up while the walk goes on
+	return left + bar.ViewAs(min(1, float64(p.Processed)/float64(max(p.Seen, 1)))) + "  " + name
+}
+
+// volumeView renders the volume as a row of blocks and a percentage
+func volumeView(volume float64) string {
+	filled := min(volumeWidth, int(volume*volumeWidth+0.5))
+	icon := "🔊"
+	if volume == 0 {
+		icon = "🔇"
+	}
+	blocks := volumeOnStyle.Render(strings.Repeat("▮", filled)) +
+		volumeOffStyle.Render(strings.Repeat("▯", volumeWidth-filled))
+	return fmt.Sprintf("%s %s %3.0f%%", icon, blocks, volume*100)
+}
+
+// fitLine pads or truncates left so that right ends exactly at width
+func fitLine(left, right string, width int) string {
+	room := width - runewidth.StringWidth(right) - 1
+	if room < 1 {
+		return truncate(left, width)
+	}
+	left = truncate(left, room)
+	return left + strings.Repeat(" ", width-runewidth.StringWidth(left)-runewidth.StringWidth(right)) + right
+}
+
+// truncate shortens s to at most width terminal cells
+func truncate(s string, width int) string {
+	return runewidth.Truncate(s, width, "…")
+}
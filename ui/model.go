@@ -0,0 +1,791 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"perth/internal"
+	"perth/playlist"
+	"perth/util"
+)
+
+const (
+	tickInterval  = 250 * time.Millisecond // Progress bar refresh
+	seekStep      = 5 * time.Second
+	volumeStep    = 5 // Percent
+	maxCrossfade  = 12 * time.Second
+	crossfadeStep = time.Second
+)
+
+// listView selects what the list pane shows
+type listView int
+
+const (
+	libraryView listView = iota
+	queueView
+)
+
+// promptKind is the text prompt currently shown in the status line
+type promptKind int
+
+const (
+	noPrompt promptKind = iota
+	openPrompt
+	seekPrompt
+	searchPrompt
+)
+
+type tickMsg time.Time
+
+// eventMsg carries a controller event; ok is false once the subscription ended
+type eventMsg struct {
+	event internal.Event
+	ok    bool
+}
+
+// scanState is a rescan running in the background
+type scanState struct {
+	cancel   context.CancelFunc
+	progress chan playlist.ScanProgress
+	last     playlist.ScanProgress // Latest progress received
+}
+
+// scanProgressMsg carries the progress of a rescan; ok is false once it ended
+type scanProgressMsg struct {
+	scan     *scanState
+	progress playlist.ScanProgress
+	ok       bool
+}
+
+// scanDoneMsg carries the outcome of a rescan, or of a reload with the
+// keys it read
+type scanDoneMsg struct {
+	result *playlist.ScanResult
+	err    error
+	reload bool
+	keys   map[string][]string
+}
+
+// Model is the Bubble Tea model of the player. Key presses become
+// controller commands; automatic track advances happen in the controller
+// and arrive here as events.
+type Model struct {
+	ctl    *internal.Controller
+	events <-chan internal.Event
+	reload func(context.Context, func(playlist.ScanProgress)) (map[string][]string, error)
+
+	keys     keyMap
+	help     help.Model
+	input    textinput.Model
+	progress progress.Model
+
+	view   listView
+	cursor [2]int // Selected row per view
+	offset [2]int // First visible row per view
+	prompt promptKind
+	width  int
+	height int
+
+	status    string
+	statusErr bool
+
+	scan *scanState // Running rescan, or nil
+}
+
+// Options configures the UI
+type Options struct {
+	// Keys replaces the keys of actions, by the names the config file uses
+	Keys map[string][]string
+
+	// Reload reloads the configuration, rescanning the library with ctx
+	// and progress, and returns the new keys. When the rescan is stopped
+	// the new configuration stays and it returns the keys with ctx's
+	// error. The reload binding is disabled when it is nil.
+	Reload func(ctx context.Context, progress func(playlist.ScanProgress)) (map[string][]string, error)
+}
+
+// New creates the UI for a controller. events must be a subscription to
+// the same controller.
+func New(ctl *internal.Controller, events <-chan internal.Event) Model {
+	m, _ := NewWithOptions(ctl, events, Options{}) // The default keys are valid
+	return m
+}
+
+// NewWithOptions creates the UI for a controller with custom keys
+func NewWithOptions(ctl *internal.Controller, events <-chan internal.Event, opts Options) (Model, error) {
+	keys, err := keyMapWith(opts.Keys)
+	if err != nil {
+		return Model{}, err
+	}
+	keys.Reload.SetEnabled(opts.Reload != nil)
+
+	input := textinput.New()
+	input.CharLimit = 512
+
+	// A resumed session waits paused for the user to carry on
+	status := fmt.Sprintf("Found %d tracks", len(ctl.Tracks()))
+	if st := ctl.Status(); st.Path != "" {
+		name := filepath.Base(st.Path)
+		if st.Track != nil {
+			name = st.Track.DisplayName()
+		}
+		status = fmt.Sprintf("⏸️  Resumed %s at %s, press %s to play",
+			name, util.FormatDuration(st.Position), keys.Toggle.Help().Key)
+	}
+
+	return Model{
+		ctl:      ctl,
+		events:   events,
+		reload:   opts.Reload,
+		keys:     keys,
+		help:     help.New(),
+		input:    input,
+		progress: progress.New(progress.WithGradient("#5A3FC0", "#9D7CFF"), progress.WithoutPercentage()),
+		status:   status,
+	}, nil
+}
+
+// Run starts the full-screen UI and blocks until the user quits
+func Run(ctl *internal.Controller) error {
+	return RunWithOptions(ctl, Options{})
+}
+
+// RunWithOptions starts the full-screen UI with custom keys and blocks
+// until the user quits
+func RunWithOptions(ctl *internal.Controller, opts Options) error {
+	events, cancel := ctl.Subscribe()
+	defer cancel()
+
+	m, err := NewWithOptions(ctl, events, opts)
+	if err != nil {
+		return err
+	}
+	_, err = tea.NewProgram(m, tea.WithAltScreen()).Run()
+	return err
+}
+
+func (m Model) Init() tea.Cmd {
+	return tea.Batch(tick(), m.waitForEvent())
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(tickInterval, func(t time.Time) tea.Msg {
+		return tickMsg(t)
+	})
+}
+
+// waitForEvent delivers the next controller event as a message
+func (m Model) waitForEvent() tea.Cmd {
+	return func() tea.Msg {
+		e, ok := <-m.events
+		return eventMsg{event: e, ok: ok}
+	}
+}
+
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.help.Width = msg.Width
+		m.scroll()
+		return m, nil
+
+	case tickMsg:
+		return m, tick()
+
+	case eventMsg:
+		if !msg.ok {
+			return m, nil
+		}
+		m.handleEvent(msg.event)
+		return m, m.waitForEvent()
+
+	case scanProgressMsg:
+		if !msg.ok || msg.scan != m.scan {
+			return m, nil
+		}
+		m.scan.last = msg.progress
+		return m, m.scan.wait()
+
+	case scanDoneMsg:
+		m.scanDone(msg)
+		return m, nil
+
+	case tea.KeyMsg:
+		if m.prompt != noPrompt {
+			return m.updatePrompt(msg)
+		}
+		if m.scan != nil && msg.Type == tea.KeyEsc {
+			m.scan.cancel()
+			m.setStatus("⏹️  Stopping the rescan...")
+			return m, nil
+		}
+		return m.handleKey(msg)
+	}
+
+	if m.prompt != noPrompt {
+		var cmd tea.Cmd
+		m.input, cmd = m.input.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+// handleEvent reports what the controller did on its own and keeps the
+// cursor valid when lists change
+func (m *Model) handleEvent(e internal.Event) {
+	switch e.Type {
+	case internal.EventQueueChanged, internal.EventLibraryChanged:
+		m.moveCursor(0)
+	}
+	if !e.Auto {
+		return
+	}
+	switch e.Type {
+	case internal.EventTrackChanged:
+		if e.Track != nil {
+			m.setStatus("⏭️  Now playing %s", e.Track.String())
+		}
+	case internal.EventQueueEnded:
+		m.setStatus("⏹️  End of queue")
+	case internal.EventError:
+		m.setError(e.Err)
+	}
+}
+
+func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	k := m.keys
+	switch {
+	case key.Matches(msg, k.Quit):
+		if m.scan != nil {
+			m.scan.cancel()
+		}
+		return m, tea.Quit
+	case key.Matches(msg, k.Help):
+		m.help.ShowAll = !m.help.ShowAll
+		m.scroll()
+
+	// Navigation
+	case key.Matches(msg, k.Up):
+		m.moveCursor(-1)
+	case key.Matches(msg, k.Down):
+		m.moveCursor(1)
+	case key.Matches(msg, k.PageUp):
+		m.moveCursor(-m.listHeight())
+	case key.Matches(msg, k.PageDown):
+		m.moveCursor(m.listHeight())
+	case key.Matches(msg, k.Top):
+		m.moveCursor(-m.rows())
+	case key.Matches(msg, k.Bottom):
+		m.moveCursor(m.rows())
+	case key.Matches(msg, k.SwitchView):
+		m.view = 1 - m.view
+		m.moveCursor(0)
+
+	// Playback
+	case key.Matches(msg, k.PlaySelected):
+		m.playSelected()
+	case key.Matches(msg, k.Toggle):
+		m.toggle()
+	case key.Matches(msg, k.Stop):
+		if err := m.ctl.Stop(); err != nil {
+			m.setError(err)
+		} else {
+			m.setStatus("⏹️  Stopped")
+		}
+	case key.Matches(msg, k.Next):
+		m.playNext()
+	case key.Matches(msg, k.Prev):
+		m.playPrev()
+	case key.Matches(msg, k.SeekForward):
+		m.seekBy(seekStep)
+	case key.Matches(msg, k.SeekBack):
+		m.seekBy(-seekStep)
+	case key.Matches(msg, k.SeekTo):
+		return m.startPrompt(seekPrompt, "Seek to (seconds or mm:ss): ")
+	case key.Matches(msg, k.VolumeUp):
+		m.changeVolume(volumeStep)
+	case key.Matches(msg, k.VolumeDown):
+		m.changeVolume(-volumeStep)
+
+	// Modes
+	case key.Matches(msg, k.Repeat):
+		m.cycleRepeat()
+	case key.Matches(msg, k.Shuffle):
+		m.toggleShuffle()
+	case key.Matches(msg, k.CrossfadeUp):
+		m.changeCrossfade(crossfadeStep)
+	case key.Matches(msg, k.CrossfadeDown):
+		m.changeCrossfade(-crossfadeStep)
+	case key.Matches(msg, k.CrossfadeAlbum):
+		m.toggleAlbumGapless()
+
+	// Queue
+	case key.Matches(msg, k.Enqueue):
+		m.enqueueSelected()
+	case key.Matches(msg, k.Search):
+		return m.startPrompt(searchPrompt, "Queue tracks matching: ")
+	case key.Matches(msg, k.Remove):
+		m.removeSelected()
+	case key.Matches(msg, k.MoveUp):
+		m.moveSelected(-1)
+	case key.Matches(msg, k.MoveDown):
+		m.moveSelected(1)
+	case key.Matches(msg, k.ClearQueue):
+		m.ctl.ClearQueue()
+		m.moveCursor(0)
+		m.setStatus("🧹 Queue cleared")
+
+	// Misc
+	case key.Matches(msg, k.Open):
+		return m.startPrompt(openPrompt, "Load file: ")
+	case key.Matches(msg, k.Rescan):
+		return m, m.rescan()
+	case key.Matches(msg, k.Reload):
+		return m, m.reloadConfig()
+	}
+	return m, nil
+}
+
+// startPrompt shows a text prompt in the status line
+func (m Model) startPrompt(kind promptKind, prompt string) (tea.Model, tea.Cmd) {
+	m.prompt = kind
+	m.input.Prompt = prompt
+	m.input.SetValue("")
+	return m, m.input.Focus()
+}
+
+func (m Model) updatePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+
+	case tea.KeyEsc:
+		m.prompt = noPrompt
+		m.input.Blur()
+		return m, nil
+
+	case tea.KeyEnter:
+		kind := m.prompt
+		value := strings.TrimSpace(m.input.Value())
+		m.prompt = noPrompt
+		m.input.Blur()
+		if value == "" {
+			return m, nil
+		}
+		switch kind {
+		case openPrompt:
+			m.loadFile(value)
+		case seekPrompt:
+			m.seekTo(value)
+		case searchPrompt:
+			m.enqueueMatching(value)
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+func (m *Model) setStatus(format string, args ...any) {
+	m.status = fmt.Sprintf(format, args...)
+	m.statusErr = false
+}
+
+func (m *Model) setError(err error) {
+	m.status = "❌ " + err.Error()
+	m.statusErr = true
+}
+
+// List navigation
+
+// rows returns the number of rows in the current view
+func (m *Model) rows() int {
+	if m.view == queueView {
+		return len(m.ctl.Queue())
+	}
+	return len(m.ctl.Tracks())
+}
+
+// moveCursor moves the selection by delta rows, clamped to the list
+func (m *Model) moveCursor(delta int) {
+	c := m.cursor[m.view] + delta
+	if n := m.rows(); c >= n {
+		c = n - 1
+	}
+	if c < 0 {
+		c = 0
+	}
+	m.cursor[m.view] = c
+	m.scroll()
+}
+
+// scroll keeps the selected row inside the visible part of the list
+func (m *Model) scroll() {
+	h := m.listHeight()
+	c, off := m.cursor[m.view], m.offset[m.view]
+	if c < off {
+		off = c
+	}
+	if c >= off+h {
+		off = c - h + 1
+	}
+	if off < 0 {
+		off = 0
+	}
+	m.offset[m.view] = off
+}
+
+// selectedTrack returns the track under the cursor, or nil
+func (m *Model) selectedTrack() *playlist.Track {
+	c := m.cursor[m.view]
+	if m.view == queueView {
+		entries := m.ctl.Queue()
+		if c >= len(entries) {
+			return nil
+		}
+		return entries[c].Track
+	}
+	tracks := m.ctl.Tracks()
+	if c >= len(tracks) {
+		return nil
+	}
+	return tracks[c]
+}
+
+// Playback
+
+// playSelected plays the track under the cursor. A library track is jumped
+// to if it is queued and played next otherwise; a queue entry becomes current.
+func (m *Model) playSelected() {
+	var err error
+	switch {
+	case m.view == queueView && m.rows() > 0:
+		err = m.ctl.PlayQueued(m.cursor[queueView])
+	case m.view == libraryView:
+		track := m.selectedTrack()
+		if track == nil {
+			return
+		}
+		err = m.ctl.PlayTrack(track.ID)
+	default:
+		return
+	}
+	m.reportPlaying(err)
+}
+
+// reportPlaying shows the track a playback command started, or its error
+func (m *Model) reportPlaying(err error) {
+	if err != nil {
+		m.setError(err)
+		return
+	}
+	if track := m.ctl.Status().Track; track != nil {
+		m.setStatus("▶️  Playing %s", track.String())
+	}
+}
+
+func (m *Model) toggle() {
+	st := m.ctl.Status()
+	switch {
+	case st.Path == "":
+		m.playSelected()
+	case st.Playing:
+		if err := m.ctl.Pause(); err != nil {
+			m.setError(err)
+			return
+		}
+		m.setStatus("⏸️  Paused")
+	default:
+		if err := m.ctl.Play(); err != nil {
+			m.setError(err)
+			return
+		}
+		m.setStatus("▶️  Playing")
+	}
+}
+
+func (m *Model) playNext() {
+	err := m.ctl.Next()
+	if errors.Is(err, internal.ErrEndOfQueue) || errors.Is(err, internal.ErrEmptyQueue) {
+		m.setStatus("⏹️  End of queue")
+		return
+	}
+	m.reportPlaying(err)
+}
+
+func (m *Model) playPrev() {
+	err := m.ctl.Prev()
+	if errors.Is(err, internal.ErrStartOfQueue) || errors.Is(err, internal.ErrEmptyQueue) {
+		m.setStatus("⏮️  Already at the start of the queue")
+		return
+	}
+	m.reportPlaying(err)
+}
+
+func (m *Model) seekBy(d time.Duration) {
+	if err := m.ctl.SeekBy(d); err != nil {
+		m.setError(err)
+	}
+}
+
+// seekTo seeks to a position given in seconds or as mm:ss
+func (m *Model) seekTo(value string) {
+	var pos time.Duration
+	if mm, ss, ok := strings.Cut(value, ":"); ok {
+		minutes, err1 := strconv.Atoi(mm)
+		seconds, err2 := strconv.ParseFloat(ss, 64)
+		if err1 != nil || err2 != nil || minutes < 0 || seconds < 0 {
+			m.setError(fmt.Errorf("invalid position: %s", value))
+			return
+		}
+		pos = time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+	} else {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < 0 {
+			m.setError(fmt.Errorf("invalid position: %s", value))
+			return
+		}
+		pos = time.Duration(seconds * float64(time.Second))
+	}
+
+	if err := m.ctl.Seek(pos); err != nil {
+		m.setError(err)
+		return
+	}
+	m.setStatus("⏩ Seeked to %s", util.FormatDuration(pos))
+}
+
+func (m *Model) changeVolume(delta int) {
+	volume := int(math.Round(m.ctl.Status().Volume*100)) + delta
+	volume = max(0, min(100, volume))
+	m.ctl.SetVolume(float64(volume) / 100)
+	m.setStatus("🔊 Volume %d%%", volume)
+}
+
+// loadFile loads an audio file by path without starting playback
+func (m *Model) loadFile(path string) {
+	if err := m.ctl.Load(path); err != nil {
+		m.setError(fmt.Errorf("loading %s: %w", filepath.Base(path), err))
+		return
+	}
+	m.setStatus("🎵 Loaded %s, press space to play", filepath.Base(path))
+}
+
+// Modes
+
+func (m *Model) cycleRepeat() {
+	mode := playlist.RepeatOff
+	switch m.ctl.Status().Repeat {
+	case playlist.RepeatOff:
+		mode = playlist.RepeatAll
+	case playlist.RepeatAll:
+		mode = playlist.RepeatOne
+	}
+	m.ctl.SetRepeat(mode)
+	m.setStatus("🔁 Repeat: %s", mode)
+}
+
+func (m *Model) toggleShuffle() {
+	if m.ctl.Status().Shuffle {
+		m.ctl.SetShuffle(false, 0)
+		m.setStatus("➡️  Shuffle off")
+		return
+	}
+	seed := time.Now().UnixNano()
+	m.ctl.SetShuffle(true, seed)
+	m.setStatus("🔀 Shuffle on (seed %d)", seed)
+}
+
+func (m *Model) changeCrossfade(delta time.Duration) {
+	fade := max(0, min(maxCrossfade, m.ctl.Status().Crossfade+delta))
+	m.ctl.SetCrossfade(fade)
+	if fade == 0 {
+		m.setStatus("🔀 Crossfade disabled")
+	} else {
+		m.setStatus("🔀 Crossfade set to %.0fs", fade.Seconds())
+	}
+}
+
+func (m *Model) toggleAlbumGapless() {
+	on := !m.ctl.Status().GaplessAlbums
+	m.ctl.SetGaplessAlbums(on)
+	if on {
+		m.setStatus("💿 Tracks from the same album will play gaplessly")
+	} else {
+		m.setStatus("💿 Crossfading between all tracks")
+	}
+}
+
+// Queue
+
+func (m *Model) enqueueSelected() {
+	if m.view != libraryView {
+		return
+	}
+	track := m.selectedTrack()
+	if track == nil {
+		return
+	}
+	m.ctl.Enqueue(track.ID)
+	m.setStatus("➕ Queued %s", track.String())
+}
+
+// enqueueMatching queues every track whose title, filename, artist or
+// album contains the query
+func (m *Model) enqueueMatching(query string) {
+	added, err := m.ctl.EnqueueMatching(query)
+	if err != nil {
+		m.setStatus("🔍 No tracks match %q", query)
+		return
+	}
+	m.setStatus("➕ Queued %d tracks", len(added))
+}
+
+func (m *Model) removeSelected() {
+	if m.view != queueView || m.rows() == 0 {
+		return
+	}
+	if err := m.ctl.RemoveQueued(m.cursor[queueView]); err != nil {
+		m.setError(err)
+		return
+	}
+	m.moveCursor(0)
+	m.setStatus("🗑️  Removed from the queue")
+}
+
+func (m *Model) moveSelected(delta int) {
+	if m.view != queueView {
+		return
+	}
+	from := m.cursor[queueView]
+	to := from + delta
+	if to < 0 || to >= m.rows() {
+		return
+	}
+	if err := m.ctl.MoveQueued(from, to); err != nil {
+		m.setError(err)
+		return
+	}
+	m.moveCursor(delta)
+}
+
+// reloadConfig reloads the configuration in the background, like a
+// rescan, since it rescans the library. The keys are rebound once it ends.
+func (m *Model) reloadConfig() tea.Cmd {
+	reload := m.reload
+	return m.startScan("🔄 Reloading configuration, press esc to stop the rescan",
+		func(ctx context.Context, progress func(playlist.ScanProgress)) scanDoneMsg {
+			keys, err := reload(ctx, progress)
+			return scanDoneMsg{err: err, reload: true, keys: keys}
+		})
+}
+
+// rescan starts rescanning the library in the background
+func (m *Model) rescan() tea.Cmd {
+	ctl := m.ctl
+	return m.startScan("🔄 Rescanning, press esc to stop",
+		func(ctx context.Context, progress func(playlist.ScanProgress)) scanDoneMsg {
+			result, _, err := ctl.Rescan(ctx, progress)
+			return scanDoneMsg{result: result, err: err}
+		})
+}
+
+// startScan runs scan in the background. Until it ends the footer shows
+// its progress in place of the playback progress, and esc stops it.
+func (m *Model) startScan(status string, scan func(context.Context, func(playlist.ScanProgress)) scanDoneMsg) tea.Cmd {
+	if m.scan != nil {
+		m.setStatus("🔄 Already rescanning, press esc to stop")
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.scan = &scanState{
+		cancel:   cancel,
+		progress: make(chan playlist.ScanProgress, 1),
+	}
+	m.setStatus("%s", status)
+
+	state := m.scan
+	run := func() tea.Msg {
+		msg := scan(ctx, state.report)
+		close(state.progress)
+		return msg
+	}
+	return tea.Batch(run, state.wait())
+}
+
+// report passes on the progress of the scan; only the latest matters
+func (s *scanState) report(p playlist.ScanProgress) {
+	select {
+	case <-s.progress:
+	default:
+	}
+	select {
+	case s.progress <- p:
+	default:
+	}
+}
+
+// wait delivers the next progress of the scan as a message
+func (s *scanState) wait() tea.Cmd {
+	return func() tea.Msg {
+		p, ok := <-s.progress
+		return scanProgressMsg{scan: s, progress: p, ok: ok}
+	}
+}
+
+// scanDone reports how a rescan ended
+func (m *Model) scanDone(msg scanDoneMsg) {
+	m.scan.cancel()
+	m.scan = nil
+	m.moveCursor(0)
+	if msg.reload {
+		m.reloaded(msg)
+		return
+	}
+	switch {
+	case errors.Is(msg.err, context.Canceled):
+		m.setStatus("⏹️  Rescan stopped, %d tracks", len(m.ctl.Tracks()))
+	case msg.err != nil:
+		m.setError(fmt.Errorf("rescan failed: %w", msg.err))
+	default:
+		result := msg.result
+		m.setStatus("✅ %d tracks (%d new, %d updated, %d removed, %d errors)",
+			result.TotalFiles, result.NewTracks, result.UpdatedTracks, result.RemovedTracks, len(result.Errors))
+	}
+}
+
+// reloaded rebinds the keys after a reload. A stopped rescan still leaves
+// the new configuration in place.
+func (m *Model) reloaded(msg scanDoneMsg) {
+	stopped := errors.Is(msg.err, context.Canceled)
+	if msg.err != nil && !stopped {
+		m.setError(fmt.Errorf("reload failed: %w", msg.err))
+		return
+	}
+	keys, err := keyMapWith(msg.keys)
+	if err != nil {
+		m.setError(fmt.Errorf("reload failed: %w", err))
+		return
+	}
+	m.keys = keys
+	if stopped {
+		m.setStatus("⏹️  Reloaded configuration, rescan stopped, %d tracks", len(m.ctl.Tracks()))
+		return
+	}
+	m.setStatus("✅ Reloaded configuration, %d tracks", len(m.ctl.Tracks()))
+}
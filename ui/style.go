@@ -0,0 +1,72 @@
+package ui
+
+import "github.com/charmbracelet/lipgloss"
+
+// Colors
+var (
+	accentColor = lipgloss.AdaptiveColor{Light: "#5A3FC0", Dark: "#9D7CFF"}
+	playColor   = lipgloss.AdaptiveColor{Light: "#1A7F37", Dark: "#5AF78E"}
+	subtleColor = lipgloss.AdaptiveColor{Light: "#8C8C8C", Dark: "#6C6C6C"}
+	textColor   = lipgloss.AdaptiveColor{Light: "#1F1F1F", Dark: "#E4E4E4"}
+	errorColor  = lipgloss.AdaptiveColor{Light: "#C62828", Dark: "#FF6B6B"}
+)
+
+// Styles
+var (
+	titleStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#FFFFFF")).
+			Background(accentColor).
+			Padding(0, 1)
+
+	modeStyle = lipgloss.NewStyle().
+			Foreground(subtleColor).
+			PaddingLeft(1)
+
+	// paneStyle frames the track list and the now-playing pane
+	paneStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(subtleColor).
+			Padding(0, 1)
+
+	paneTitleStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(accentColor)
+
+	cursorStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(textColor).
+			Background(lipgloss.AdaptiveColor{Light: "#E2DCFA", Dark: "#3B3060"})
+
+	playingStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(playColor)
+
+	labelStyle = lipgloss.NewStyle().
+			Foreground(subtleColor).
+			Width(8)
+
+	valueStyle = lipgloss.NewStyle().
+			Foreground(textColor)
+
+	nowTitleStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(textColor)
+
+	dimStyle = lipgloss.NewStyle().
+			Foreground(subtleColor)
+
+	statusStyle = lipgloss.NewStyle().
+			Foreground(textColor).
+			PaddingLeft(1)
+
+	errorStyle = lipgloss.NewStyle().
+			Foreground(errorColor).
+			PaddingLeft(1)
+
+	volumeOnStyle = lipgloss.NewStyle().
+			Foreground(accentColor)
+
+	volumeOffStyle = lipgloss.NewStyle().
+			Foreground(subtleColor)
+)
@@ -0,0 +1,16 @@
+package util
+
+import (
+	"fmt"
+	"time"
+)
+
+// FormatDuration formats duration in MM:SS format
+func FormatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	minutes := int(d.Minutes())
+	seconds := int(d.Seconds()) % 60
+	return fmt.Sprintf("%02d:%02d", minutes, seconds)
+}
@@ -0,0 +1,268 @@
+// Perth web UI. Everything goes through the HTTP API; the event stream
+// keeps the page current so it never polls.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+let status = null;
+let library = [];
+let queue = { index: -1, entries: [] };
+let coverID = null;
+
+async function api(method, path, body) {
+  const opts = { method, headers: {} };
+  if (body !== undefined) {
+    opts.headers["Content-Type"] = "application/json";
+    opts.body = JSON.stringify(body);
+  }
+  const res = await fetch(path, opts);
+  const data = await res.json().catch(() => null);
+  if (!res.ok) {
+    throw new Error((data && data.error) || res.statusText);
+  }
+  return data;
+}
+
+// run sends a command and shows its error, if any
+async function run(method, path, body) {
+  try {
+    const data = await api(method, path, body);
+    if (data && data.state) {
+      setStatus(data);
+    }
+    return data;
+  } catch (err) {
+    toast(err.message);
+  }
+}
+
+let toastTimer = 0;
+function toast(message) {
+  const el = $("toast");
+  el.textContent = message;
+  el.hidden = false;
+  clearTimeout(toastTimer);
+  toastTimer = setTimeout(() => (el.hidden = true), 3000);
+}
+
+function clock(seconds) {
+  seconds = Math.max(0, Math.floor(seconds || 0));
+  const m = Math.floor(seconds / 60);
+  const s = seconds % 60;
+  return String(m).padStart(2, "0") + ":" + String(s).padStart(2, "0");
+}
+
+function meta(track) {
+  return [track.artist, track.album].filter(Boolean).join(" — ");
+}
+
+// Now playing
+
+function setStatus(st) {
+  status = st;
+  const track = st.track;
+  $("title").textContent = track ? track.title : "Nothing playing";
+  $("subtitle").textContent = track ? meta(track) : "";
+  $("toggle").textContent = st.state === "playing" ? "⏸" : "▶";
+  if (document.activeElement !== $("volume")) {
+    $("volume").value = Math.round(st.volume * 100);
+  }
+  setCover(track ? track.id : null);
+  setPosition(st.position, st.duration);
+  if (st.queue_index !== queue.index) {
+    queue.index = st.queue_index;
+    renderQueue();
+  }
+}
+
+function setPosition(position, duration) {
+  $("position").textContent = clock(position);
+  $("duration").textContent = clock(duration);
+  const ratio = duration > 0 ? Math.min(position / duration, 1) : 0;
+  $("progress-fill").style.width = ratio * 100 + "%";
+}
+
+function setCover(id) {
+  if (id === coverID) {
+    return;
+  }
+  coverID = id;
+  const img = $("cover");
+  img.hidden = true;
+  if (id) {
+    img.src = "/tracks/" + encodeURIComponent(id) + "/cover";
+  } else {
+    img.removeAttribute("src");
+  }
+}
+
+$("cover").addEventListener("load", () => ($("cover").hidden = false));
+$("cover").addEventListener("error", () => ($("cover").hidden = true));
+
+$("toggle").addEventListener("click", () => {
+  run("POST", status && status.state === "playing" ? "/pause" : "/play");
+});
+
+document.querySelectorAll("#buttons [data-action]").forEach((btn) => {
+  btn.addEventListener("click", () => run("POST", "/" + btn.dataset.action));
+});
+
+$("progress").addEventListener("click", (e) => {
+  if (!status || !status.duration) {
+    return;
+  }
+  const rect = e.currentTarget.getBoundingClientRect();
+  const ratio = (e.clientX - rect.left) / rect.width;
+  run("POST", "/seek", { position: ratio * status.duration });
+});
+
+$("volume").addEventListener("change", (e) => {
+  run("PUT", "/volume", { volume: e.target.value / 100 });
+});
+
+// Library
+
+function matches(track, words) {
+  const text = [track.title, track.artist, track.album, track.genre, track.path]
+    .join(" ")
+    .toLowerCase();
+  return words.every((w) => text.includes(w));
+}
+
+function renderLibrary() {
+  const words = $("search").value.toLowerCase().split(/\s+/).filter(Boolean);
+  const list = $("tracks");
+  list.replaceChildren();
+  for (const track of library) {
+    if (words.length && !matches(track, words)) {
+      continue;
+    }
+    const li = row(track);
+    li.querySelector(".info").addEventListener("click", () =>
+      run("POST", "/tracks/" + encodeURIComponent(track.id) + "/play"),
+    );
+    const add = document.createElement("button");
+    add.textContent = "+";
+    add.title = "Add to queue";
+    add.addEventListener("click", async () => {
+      if (await run("POST", "/queue", { ids: [track.id] })) {
+        toast("Queued " + track.title);
+      }
+    });
+    li.append(add);
+    list.append(li);
+  }
+}
+
+function row(track) {
+  const li = document.createElement("li");
+  const info = document.createElement("div");
+  info.className = "info";
+  const name = document.createElement("div");
+  name.className = "name";
+  name.textContent = track ? track.title : "(missing track)";
+  const sub = document.createElement("div");
+  sub.className = "meta";
+  sub.textContent = track ? meta(track) : "";
+  info.append(name, sub);
+  const length = document.createElement("span");
+  length.className = "length";
+  length.textContent = track ? clock(track.duration) : "";
+  li.append(info, length);
+  return li;
+}
+
+async function loadLibrary() {
+  try {
+    library = await api("GET", "/tracks");
+    renderLibrary();
+  } catch (err) {
+    toast(err.message);
+  }
+}
+
+$("search").addEventListener("input", renderLibrary);
+
+$("queue-matches").addEventListener("click", async () => {
+  const query = $("search").value.trim();
+  if (!query) {
+    toast("Type a search first");
+    return;
+  }
+  const q = await run("POST", "/queue", { query });
+  if (q) {
+    toast("Queue has " + q.entries.length + " tracks");
+  }
+});
+
+// Queue
+
+function renderQueue() {
+  const list = $("entries");
+  list.replaceChildren();
+  queue.entries.forEach((entry, i) => {
+    const li = row(entry.track);
+    if (i === queue.index) {
+      li.classList.add("current");
+    }
+    li.querySelector(".info").addEventListener("click", () => run("POST", "/queue/" + i + "/play"));
+    list.append(li);
+  });
+}
+
+async function loadQueue() {
+  try {
+    queue = await api("GET", "/queue");
+    renderQueue();
+  } catch (err) {
+    toast(err.message);
+  }
+}
+
+// Tabs
+
+document.querySelectorAll(".tab").forEach((tab) => {
+  tab.addEventListener("click", () => {
+    document.querySelectorAll(".tab").forEach((t) => t.classList.toggle("active", t === tab));
+    document.querySelectorAll(".view").forEach((v) => (v.hidden = v.id !== tab.dataset.view));
+  });
+});
+
+// Event stream
+
+function connect() {
+  const source = new EventSource("/events");
+  const onStatus = (e) => {
+    const data = JSON.parse(e.data);
+    setStatus(data.status);
+    if (data.error) {
+      toast(data.error);
+    }
+  };
+  for (const name of ["status", "track", "playback", "volume", "mode", "queue-ended", "error"]) {
+    source.addEventListener(name, onStatus);
+  }
+  source.addEventListener("queue", (e) => {
+    onStatus(e);
+    loadQueue();
+  });
+  source.addEventListener("library", (e) => {
+    onStatus(e);
+    loadLibrary();
+    loadQueue();
+  });
+  source.addEventListener("position", (e) => {
+    const data = JSON.parse(e.data);
+    if (status) {
+      status.position = data.position;
+    }
+    setPosition(data.position, data.duration);
+  });
+  // EventSource reconnects on its own; refetch once it does
+  source.addEventListener("open", () => {
+    loadLibrary();
+    loadQueue();
+  });
+}
+
+connect();
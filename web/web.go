@@ -0,0 +1,27 @@
+// Package web serves the browser interface. The page is a single static
+// app embedded in the binary that talks to the HTTP API.
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// New returns a handler serving the web UI under /ui/ and everything
+// else from api. The site root redirects to the UI.
+func New(api http.Handler) http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err) // The directory is embedded at build time
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /ui/", http.StripPrefix("/ui/", http.FileServerFS(files)))
+	mux.Handle("GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+	mux.Handle("/", api)
+	return mux
+}